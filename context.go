@@ -165,12 +165,9 @@ func NewContext(ctx context.Context, options ...ContextOption) Context {
 // are reset for the derived context.
 func (c *mcontext) Derive(options ...ContextOption) Context {
 
-	var opaqueCopy map[string]interface{}
-	if len(c.opaque) > 0 {
-		opaqueCopy = make(map[string]interface{}, len(c.opaque))
-		for k, v := range c.opaque {
-			opaqueCopy[k] = v
-		}
+	opaqueCopy := make(map[string]interface{}, len(c.opaque))
+	for k, v := range c.opaque {
+		opaqueCopy[k] = v
 	}
 
 	var paramsCopy url.Values
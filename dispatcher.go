@@ -0,0 +1,255 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manipulate
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.aporeto.io/elemental"
+)
+
+// An EventHandler handles an event received by a Dispatcher.
+// The given object is the entity of the event, decoded into
+// the concrete elemental.Identifiable of its identity.
+type EventHandler func(ctx context.Context, eventType elemental.EventType, object elemental.Identifiable) error
+
+type dispatcherConfig struct {
+	concurrency   int
+	errorHandler  func(error)
+	statusHandler func(SubscriberStatus)
+}
+
+// A DispatcherOption represents an option that can be passed to NewDispatcher.
+type DispatcherOption func(*dispatcherConfig)
+
+// DispatcherOptionConcurrency sets the maximum number of handlers
+// that can run at the same time. The default is 1, meaning the
+// events are handled one by one, in order. With a higher value,
+// the events may be handled out of order.
+func DispatcherOptionConcurrency(concurrency int) DispatcherOption {
+	return func(cfg *dispatcherConfig) {
+		if concurrency < 1 {
+			panic("concurrency must be greater than 0")
+		}
+		cfg.concurrency = concurrency
+	}
+}
+
+// DispatcherOptionErrorHandler sets the function called with the errors
+// received by the subscriber, and the errors returned by the handlers,
+// including the recovered panics. It may be called concurrently.
+func DispatcherOptionErrorHandler(handler func(error)) DispatcherOption {
+	return func(cfg *dispatcherConfig) {
+		cfg.errorHandler = handler
+	}
+}
+
+// DispatcherOptionStatusHandler sets the function called with
+// the statuses received by the subscriber.
+func DispatcherOptionStatusHandler(handler func(SubscriberStatus)) DispatcherOption {
+	return func(cfg *dispatcherConfig) {
+		cfg.statusHandler = handler
+	}
+}
+
+// A registration is a handler registered for some event
+// types. It is registered for all types if there is none.
+type registration struct {
+	eventTypes []elemental.EventType
+	handler    EventHandler
+}
+
+func (r registration) matches(eventType elemental.EventType) bool {
+
+	if len(r.eventTypes) == 0 {
+		return true
+	}
+
+	for _, t := range r.eventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A Dispatcher reads the events of a Subscriber, decodes them
+// using an elemental.ModelManager and calls the handlers
+// registered for their identity and type.
+type Dispatcher struct {
+	subscriber Subscriber
+	manager    elemental.ModelManager
+	config     dispatcherConfig
+	handlers   map[string][]registration
+	lock       sync.RWMutex
+}
+
+// NewDispatcher returns a new Dispatcher reading the events
+// of the given Subscriber.
+func NewDispatcher(subscriber Subscriber, manager elemental.ModelManager, options ...DispatcherOption) *Dispatcher {
+
+	if subscriber == nil {
+		panic("subscriber must not be nil")
+	}
+
+	if manager == nil {
+		panic("manager must not be nil")
+	}
+
+	cfg := dispatcherConfig{
+		concurrency: 1,
+	}
+
+	for _, opt := range options {
+		opt(&cfg)
+	}
+
+	return &Dispatcher{
+		subscriber: subscriber,
+		manager:    manager,
+		config:     cfg,
+		handlers:   map[string][]registration{},
+	}
+}
+
+// Handle registers the given handler for the events of the given
+// identity and types. If no type is given, the handler is registered
+// for all types. Multiple handlers can be registered for the same
+// identity and type. They are called in the order they have been registered.
+func (d *Dispatcher) Handle(identity elemental.Identity, handler EventHandler, eventTypes ...elemental.EventType) {
+
+	if handler == nil {
+		panic("handler must not be nil")
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.handlers[identity.Name] = append(d.handlers[identity.Name], registration{
+		eventTypes: append([]elemental.EventType{}, eventTypes...),
+		handler:    handler,
+	})
+}
+
+// Run dispatches the events of the subscriber until the given context
+// is done, or the subscriber reports a SubscriberStatusFinalDisconnection.
+// It returns once all the running handlers are done.
+// The subscriber must be started separately.
+func (d *Dispatcher) Run(ctx context.Context) {
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	sem := make(chan struct{}, d.config.concurrency)
+
+	for {
+
+		select {
+
+		case evt := <-d.subscriber.Events():
+
+			handlers := d.handlersFor(evt)
+			if len(handlers) == 0 {
+				continue
+			}
+
+			obj := d.manager.IdentifiableFromString(evt.Identity)
+			if obj == nil {
+				d.reportError(fmt.Errorf("unable to dispatch event: unknown identity '%s'", evt.Identity))
+				continue
+			}
+
+			if err := evt.Decode(obj); err != nil {
+				d.reportError(fmt.Errorf("unable to decode %s event for '%s': %s", evt.Type, evt.Identity, err))
+				continue
+			}
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			wg.Add(1)
+			go func(evt *elemental.Event) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				d.dispatch(ctx, evt.Type, obj, handlers)
+			}(evt)
+
+		case err := <-d.subscriber.Errors():
+			d.reportError(err)
+
+		case status := <-d.subscriber.Status():
+
+			if d.config.statusHandler != nil {
+				d.config.statusHandler(status)
+			}
+
+			if status == SubscriberStatusFinalDisconnection {
+				return
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) handlersFor(evt *elemental.Event) []EventHandler {
+
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	var handlers []EventHandler
+	for _, r := range d.handlers[evt.Identity] {
+		if r.matches(evt.Type) {
+			handlers = append(handlers, r.handler)
+		}
+	}
+
+	return handlers
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, eventType elemental.EventType, obj elemental.Identifiable, handlers []EventHandler) {
+
+	for _, h := range handlers {
+		if err := d.call(ctx, eventType, obj, h); err != nil {
+			d.reportError(err)
+		}
+	}
+}
+
+func (d *Dispatcher) call(ctx context.Context, eventType elemental.EventType, obj elemental.Identifiable, handler EventHandler) (err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic in handler for %s event for '%s': %v", eventType, obj.Identity().Name, r)
+		}
+	}()
+
+	if err = handler(ctx, eventType, obj); err != nil {
+		return fmt.Errorf("unable to handle %s event for '%s': %s", eventType, obj.Identity().Name, err)
+	}
+
+	return nil
+}
+
+func (d *Dispatcher) reportError(err error) {
+
+	if d.config.errorHandler != nil {
+		d.config.errorHandler(err)
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manipulate
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	testmodel "go.aporeto.io/elemental/test/model"
+)
+
+// A testSubscriber is a Subscriber whose channels can be fed by the tests.
+type testSubscriber struct {
+	events chan *elemental.Event
+	errors chan error
+	status chan SubscriberStatus
+}
+
+func newTestSubscriber() *testSubscriber {
+	return &testSubscriber{
+		events: make(chan *elemental.Event, 10),
+		errors: make(chan error, 10),
+		status: make(chan SubscriberStatus, 10),
+	}
+}
+
+func (s *testSubscriber) Start(context.Context, *elemental.PushConfig) {}
+func (s *testSubscriber) UpdateFilter(*elemental.PushConfig)           {}
+func (s *testSubscriber) Events() chan *elemental.Event                { return s.events }
+func (s *testSubscriber) Errors() chan error                           { return s.errors }
+func (s *testSubscriber) Status() chan SubscriberStatus                { return s.status }
+
+func TestDispatcher_New(t *testing.T) {
+
+	Convey("Given I create a dispatcher with a nil subscriber", t, func() {
+		So(func() { NewDispatcher(nil, testmodel.Manager()) }, ShouldPanicWith, "subscriber must not be nil")
+	})
+
+	Convey("Given I create a dispatcher with a nil manager", t, func() {
+		So(func() { NewDispatcher(newTestSubscriber(), nil) }, ShouldPanicWith, "manager must not be nil")
+	})
+
+	Convey("Given I create a dispatcher with an invalid concurrency", t, func() {
+		So(func() { NewDispatcher(newTestSubscriber(), testmodel.Manager(), DispatcherOptionConcurrency(0)) }, ShouldPanicWith, "concurrency must be greater than 0")
+	})
+
+	Convey("Given I create a dispatcher with options", t, func() {
+
+		d := NewDispatcher(
+			newTestSubscriber(),
+			testmodel.Manager(),
+			DispatcherOptionConcurrency(4),
+			DispatcherOptionErrorHandler(func(error) {}),
+			DispatcherOptionStatusHandler(func(SubscriberStatus) {}),
+		)
+
+		Convey("Then the config should be correct", func() {
+			So(d.config.concurrency, ShouldEqual, 4)
+			So(d.config.errorHandler, ShouldNotBeNil)
+			So(d.config.statusHandler, ShouldNotBeNil)
+		})
+
+		Convey("Then registering a nil handler should panic", func() {
+			So(func() { d.Handle(testmodel.ListIdentity, nil) }, ShouldPanicWith, "handler must not be nil")
+		})
+	})
+}
+
+func TestDispatcher_Run(t *testing.T) {
+
+	Convey("Given I have a dispatcher with some handlers", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		sub := newTestSubscriber()
+		errs := make(chan error, 10)
+		statuses := make(chan SubscriberStatus, 10)
+
+		d := NewDispatcher(
+			sub,
+			testmodel.Manager(),
+			DispatcherOptionErrorHandler(func(err error) { errs <- err }),
+			DispatcherOptionStatusHandler(func(s SubscriberStatus) { statuses <- s }),
+		)
+
+		var lock sync.Mutex
+		var calls []string
+
+		record := func(name string) EventHandler {
+			return func(ctx context.Context, eventType elemental.EventType, obj elemental.Identifiable) error {
+				lock.Lock()
+				calls = append(calls, fmt.Sprintf("%s:%s:%s", name, eventType, obj.(*testmodel.List).Name))
+				lock.Unlock()
+				return nil
+			}
+		}
+
+		d.Handle(testmodel.ListIdentity, record("create"), elemental.EventCreate)
+		d.Handle(testmodel.ListIdentity, record("all"))
+
+		done := make(chan struct{})
+		go func() {
+			d.Run(ctx)
+			close(done)
+		}()
+
+		Convey("When I receive some events and a final disconnection", func() {
+
+			sub.events <- elemental.NewEvent(elemental.EventCreate, &testmodel.List{ID: "1", Name: "a"})
+			sub.events <- elemental.NewEvent(elemental.EventUpdate, &testmodel.List{ID: "1", Name: "b"})
+			sub.events <- elemental.NewEvent(elemental.EventCreate, &testmodel.Task{ID: "2", Name: "c"})
+
+			time.Sleep(100 * time.Millisecond)
+			sub.status <- SubscriberStatusFinalDisconnection
+
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				panic("dispatcher did not stop in time")
+			}
+
+			Convey("Then the handlers should have been called with the decoded objects", func() {
+				So(calls, ShouldResemble, []string{
+					"create:create:a",
+					"all:create:a",
+					"all:update:b",
+				})
+			})
+
+			Convey("Then the status should have been forwarded", func() {
+				So(<-statuses, ShouldEqual, SubscriberStatusFinalDisconnection)
+			})
+		})
+
+		Convey("When handlers for all types are registered before type-specific ones", func() {
+
+			d.Handle(testmodel.TaskIdentity, func(context.Context, elemental.EventType, elemental.Identifiable) error {
+				lock.Lock()
+				calls = append(calls, "task:all")
+				lock.Unlock()
+				return nil
+			})
+			d.Handle(testmodel.TaskIdentity, func(context.Context, elemental.EventType, elemental.Identifiable) error {
+				lock.Lock()
+				calls = append(calls, "task:create")
+				lock.Unlock()
+				return nil
+			}, elemental.EventCreate)
+
+			sub.events <- elemental.NewEvent(elemental.EventCreate, &testmodel.Task{ID: "2", Name: "c"})
+
+			time.Sleep(100 * time.Millisecond)
+			cancel()
+			<-done
+
+			Convey("Then they should have been called in the order they have been registered", func() {
+				So(calls, ShouldResemble, []string{
+					"task:all",
+					"task:create",
+				})
+			})
+		})
+
+		Convey("When a handler fails or panics", func() {
+
+			d.Handle(testmodel.TaskIdentity, func(context.Context, elemental.EventType, elemental.Identifiable) error {
+				return fmt.Errorf("boom")
+			})
+			d.Handle(testmodel.TaskIdentity, func(context.Context, elemental.EventType, elemental.Identifiable) error {
+				panic("oh no")
+			})
+
+			sub.events <- elemental.NewEvent(elemental.EventCreate, &testmodel.Task{ID: "2", Name: "c"})
+
+			var err1, err2 error
+			select {
+			case err1 = <-errs:
+				err2 = <-errs
+			case <-time.After(time.Second):
+				panic("no error reported in time")
+			}
+
+			cancel()
+			<-done
+
+			Convey("Then the errors should have been reported", func() {
+				So(err1.Error(), ShouldEqual, "unable to handle create event for 'task': boom")
+				So(err2.Error(), ShouldEqual, "panic in handler for create event for 'task': oh no")
+			})
+		})
+
+		Convey("When I receive an error from the subscriber", func() {
+
+			sub.errors <- fmt.Errorf("connection error")
+
+			var err error
+			select {
+			case err = <-errs:
+			case <-time.After(time.Second):
+				panic("no error reported in time")
+			}
+
+			cancel()
+			<-done
+
+			Convey("Then the error should have been reported", func() {
+				So(err.Error(), ShouldEqual, "connection error")
+			})
+		})
+	})
+
+	Convey("Given I have a dispatcher with a concurrency of 2", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		sub := newTestSubscriber()
+		d := NewDispatcher(sub, testmodel.Manager(), DispatcherOptionConcurrency(2))
+
+		var lock sync.Mutex
+		var running, max int
+		release := make(chan struct{})
+
+		d.Handle(testmodel.ListIdentity, func(context.Context, elemental.EventType, elemental.Identifiable) error {
+			lock.Lock()
+			running++
+			if running > max {
+				max = running
+			}
+			lock.Unlock()
+
+			<-release
+
+			lock.Lock()
+			running--
+			lock.Unlock()
+			return nil
+		})
+
+		done := make(chan struct{})
+		go func() {
+			d.Run(ctx)
+			close(done)
+		}()
+
+		Convey("When I receive more events than the concurrency", func() {
+
+			for i := 0; i < 5; i++ {
+				sub.events <- elemental.NewEvent(elemental.EventCreate, &testmodel.List{ID: fmt.Sprintf("%d", i)})
+			}
+
+			time.Sleep(100 * time.Millisecond)
+			close(release)
+			time.Sleep(100 * time.Millisecond)
+
+			cancel()
+			<-done
+
+			Convey("Then at most 2 handlers should have run at the same time", func() {
+				So(max, ShouldEqual, 2)
+				So(len(sub.events), ShouldEqual, 0)
+			})
+		})
+	})
+}
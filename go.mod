@@ -12,10 +12,11 @@ require (
 	github.com/gofrs/uuid v3.2.0+incompatible
 	github.com/gorilla/websocket v1.4.1
 	github.com/hashicorp/go-memdb v1.1.0
-	github.com/hashicorp/golang-lru v0.5.4 // indirect
+	github.com/hashicorp/golang-lru v0.5.4
 	github.com/mitchellh/copystructure v1.0.0
 	github.com/opentracing/opentracing-go v1.1.0
 	github.com/smartystreets/goconvey v1.6.4
+	github.com/ugorji/go/codec v1.1.7
 	go.uber.org/zap v1.14.0
 	golang.org/x/lint v0.0.0-20200130185559-910be7a94367 // indirect
 	golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e
@@ -0,0 +1,67 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"net/http"
+	"time"
+)
+
+// A Config configures a subscriber. The zero value is
+// a valid configuration using the default values.
+type Config struct {
+
+	// Channels.
+
+	// EventsChSize is the size of the events channel.
+	EventsChSize int
+
+	// ErrorsChSize is the size of the errors channel.
+	ErrorsChSize int
+
+	// Overflow.
+
+	// OverflowPolicy defines what happens when
+	// the events channel is full.
+	OverflowPolicy OverflowPolicy
+
+	// SpillDir is the directory holding the events
+	// queued on disk when OverflowPolicy is OverflowSpill.
+	SpillDir string
+
+	// SpillMaxSize is the maximum size of the events
+	// queued on disk when OverflowPolicy is OverflowSpill.
+	SpillMaxSize int64
+
+	// Connection.
+
+	// Transport defines how the events are received.
+	Transport Transport
+
+	// HTTPClient is used to receive the server-sent events.
+	HTTPClient *http.Client
+
+	// AuthorizationScheme, if set, makes the subscriber send the
+	// token in the Authorization header with this scheme instead
+	// of in the url, and send the renewed tokens as control messages.
+	AuthorizationScheme string
+
+	// Delivery.
+
+	// DedupWindow, if set, makes the subscriber drop
+	// the events already seen within the window.
+	DedupWindow time.Duration
+
+	// ReorderDelay, if set, makes the subscriber hold the events
+	// of each object for the delay and publish them in order.
+	ReorderDelay time.Duration
+}
@@ -0,0 +1,149 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.aporeto.io/elemental"
+)
+
+// orderer drops the events already seen within a sliding
+// window, and holds the events of each object for a delay
+// so they can be published in the order of their timestamps.
+// Events without an object ID are published right away.
+type orderer struct {
+	window time.Duration
+	delay  time.Duration
+
+	seen      map[string]struct{}
+	seenOrder *list.List
+
+	pending      map[string]*pendingEvents
+	pendingOrder *list.List
+}
+
+type seenEvent struct {
+	key string
+	at  time.Time
+}
+
+type pendingEvents struct {
+	key      string
+	deadline time.Time
+	events   []*elemental.Event
+}
+
+func newOrderer(window time.Duration, delay time.Duration) *orderer {
+
+	return &orderer{
+		window:       window,
+		delay:        delay,
+		seen:         map[string]struct{}{},
+		seenOrder:    list.New(),
+		pending:      map[string]*pendingEvents{},
+		pendingOrder: list.New(),
+	}
+}
+
+// add adds the given event received at the given time, and
+// returns the events that can be published right away.
+func (o *orderer) add(evt *elemental.Event, now time.Time) []*elemental.Event {
+
+	key := eventKey(evt)
+	if key == "" {
+		return []*elemental.Event{evt}
+	}
+
+	if o.window > 0 && !evt.Timestamp.IsZero() {
+
+		o.forget(now)
+
+		id := fmt.Sprintf("%s/%s/%s", key, evt.Type, evt.Timestamp.Format(time.RFC3339Nano))
+		if _, ok := o.seen[id]; ok {
+			return nil
+		}
+
+		o.seen[id] = struct{}{}
+		o.seenOrder.PushBack(seenEvent{key: id, at: now})
+	}
+
+	if o.delay <= 0 {
+		return []*elemental.Event{evt}
+	}
+
+	p, ok := o.pending[key]
+	if !ok {
+		p = &pendingEvents{key: key, deadline: now.Add(o.delay)}
+		o.pending[key] = p
+		o.pendingOrder.PushBack(p)
+	}
+
+	p.events = append(p.events, evt)
+
+	return nil
+}
+
+// due returns the events of the objects whose delay
+// expired at the given time, sorted by timestamp.
+func (o *orderer) due(now time.Time) []*elemental.Event {
+
+	var out []*elemental.Event
+
+	for e := o.pendingOrder.Front(); e != nil; e = o.pendingOrder.Front() {
+
+		p := e.Value.(*pendingEvents)
+		if p.deadline.After(now) {
+			break
+		}
+
+		o.pendingOrder.Remove(e)
+		delete(o.pending, p.key)
+
+		sort.SliceStable(p.events, func(i, j int) bool {
+			return p.events[i].Timestamp.Before(p.events[j].Timestamp)
+		})
+
+		out = append(out, p.events...)
+	}
+
+	return out
+}
+
+// next returns the time at which the next events will be due.
+func (o *orderer) next() (time.Time, bool) {
+
+	e := o.pendingOrder.Front()
+	if e == nil {
+		return time.Time{}, false
+	}
+
+	return e.Value.(*pendingEvents).deadline, true
+}
+
+// forget forgets the events seen before the window.
+func (o *orderer) forget(now time.Time) {
+
+	for e := o.seenOrder.Front(); e != nil; e = o.seenOrder.Front() {
+
+		s := e.Value.(seenEvent)
+		if now.Sub(s.at) < o.window {
+			return
+		}
+
+		o.seenOrder.Remove(e)
+		delete(o.seen, s.key)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.aporeto.io/elemental"
+)
+
+func newTimedEvent(t elemental.EventType, id string, name string, ts time.Time) *elemental.Event {
+
+	evt := newTestEvent(t, id, name)
+	evt.Timestamp = ts
+
+	return evt
+}
+
+func Test_ordererDedup(t *testing.T) {
+
+	now := time.Now()
+	o := newOrderer(time.Minute, 0)
+
+	evt := newTimedEvent(elemental.EventCreate, "1", "a", now)
+
+	if out := o.add(evt, now); len(out) != 1 {
+		t.Fatalf("add() = %d events, want 1", len(out))
+	}
+
+	if out := o.add(newTimedEvent(elemental.EventCreate, "1", "a", now), now.Add(time.Second)); len(out) != 0 {
+		t.Errorf("add() = %d events, want the duplicate to be dropped", len(out))
+	}
+
+	if out := o.add(newTimedEvent(elemental.EventUpdate, "1", "a", now), now.Add(time.Second)); len(out) != 1 {
+		t.Errorf("add() = %d events, want an event of another type to be kept", len(out))
+	}
+
+	if out := o.add(newTimedEvent(elemental.EventCreate, "1", "a", now), now.Add(2*time.Minute)); len(out) != 1 {
+		t.Errorf("add() = %d events, want the event to be kept once out of the window", len(out))
+	}
+
+	if out := o.add(newTimedEvent(elemental.EventCreate, "1", "a", time.Time{}), now); len(out) != 1 {
+		t.Errorf("add() = %d events, want events without timestamp to be kept", len(out))
+	}
+	if out := o.add(newTimedEvent(elemental.EventCreate, "1", "a", time.Time{}), now); len(out) != 1 {
+		t.Errorf("add() = %d events, want events without timestamp to be kept", len(out))
+	}
+}
+
+func Test_ordererReorder(t *testing.T) {
+
+	now := time.Now()
+	o := newOrderer(0, time.Second)
+
+	_ = o.add(newTimedEvent(elemental.EventUpdate, "1", "b", now.Add(2*time.Millisecond)), now)
+	_ = o.add(newTimedEvent(elemental.EventCreate, "2", "c", now.Add(3*time.Millisecond)), now.Add(100*time.Millisecond))
+	_ = o.add(newTimedEvent(elemental.EventCreate, "1", "a", now.Add(1*time.Millisecond)), now.Add(200*time.Millisecond))
+
+	if deadline, ok := o.next(); !ok || !deadline.Equal(now.Add(time.Second)) {
+		t.Fatalf("next() = %s, want the deadline of the first object", deadline)
+	}
+
+	if out := o.due(now.Add(500 * time.Millisecond)); len(out) != 0 {
+		t.Fatalf("due() = %d events, want 0 before the delay", len(out))
+	}
+
+	out := o.due(now.Add(time.Second))
+	if len(out) != 2 {
+		t.Fatalf("due() = %d events, want 2", len(out))
+	}
+
+	if eventName(t, out[0]) != "a" || eventName(t, out[1]) != "b" {
+		t.Errorf("due() = [%s %s], want [a b]", eventName(t, out[0]), eventName(t, out[1]))
+	}
+
+	out = o.due(now.Add(2 * time.Second))
+	if len(out) != 1 || eventName(t, out[0]) != "c" {
+		t.Errorf("due() should return the events of the second object")
+	}
+
+	if _, ok := o.next(); ok {
+		t.Errorf("next() should return false when nothing is pending")
+	}
+}
+
+func Test_order(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := &subscription{
+		events:  make(chan *elemental.Event, 10),
+		errors:  make(chan error, 10),
+		orderer: newOrderer(time.Minute, 20*time.Millisecond),
+		orderIn: make(chan *elemental.Event, orderChSize),
+		stats:   newStats(),
+	}
+
+	go s.order(ctx)
+
+	now := time.Now()
+	s.forwardEvent(ctx, newTimedEvent(elemental.EventUpdate, "1", "b", now.Add(time.Millisecond)))
+	s.forwardEvent(ctx, newTimedEvent(elemental.EventCreate, "1", "a", now))
+	s.forwardEvent(ctx, newTimedEvent(elemental.EventCreate, "1", "a", now))
+
+	var names []string
+	for i := 0; i < 2; i++ {
+		select {
+		case evt := <-s.events:
+			names = append(names, eventName(t, evt))
+		case <-time.After(time.Second):
+			t.Fatalf("missing events: %v", names)
+		}
+	}
+
+	if names[0] != "a" || names[1] != "b" {
+		t.Errorf("events = %v, want [a b]", names)
+	}
+
+	select {
+	case evt := <-s.events:
+		t.Errorf("unexpected event %s", eventName(t, evt))
+	case <-time.After(50 * time.Millisecond):
+	}
+}
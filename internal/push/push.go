@@ -26,11 +26,34 @@ import (
 	"go.aporeto.io/wsc"
 )
 
+const (
+	// sinceParameter is the parameter used to ask the server to
+	// replay the events that happened since the given time.
+	sinceParameter = "since"
+
+	// replayHeader is the header the server sets to "true" in the
+	// upgrade response when it replays the requested events.
+	replayHeader = "X-Push-Replay"
+
+	// controlTokenRenewal is the control message sent
+	// to give the renewed token to the server.
+	controlTokenRenewal = "renewToken"
+)
+
+// A controlMessage is sent to the server to act on
+// the connection without changing the push config.
+type controlMessage struct {
+	Control string `json:"control" msgpack:"control"`
+	Token   string `json:"token,omitempty" msgpack:"token,omitempty"`
+}
+
 const (
 	eventChSize  = 2048
 	errorChSize  = 64
 	statusChSize = 8
 	filterChSize = 2
+	tokenChSize  = 1
+	orderChSize  = 64
 )
 
 type subscription struct {
@@ -43,8 +66,10 @@ type subscription struct {
 	supportErrorEvents      bool
 	recursive               bool
 	status                  chan manipulate.SubscriberStatus
-	url                     string
+	urls                    []string
+	currentURL              int
 	filters                 chan *elemental.PushConfig
+	tokens                  chan string
 	currentFilter           *elemental.PushConfig
 	currentFilterLock       sync.RWMutex
 	currentToken            string
@@ -54,11 +79,23 @@ type subscription struct {
 	readEncoding            elemental.EncodingType
 	writeEncoding           elemental.EncodingType
 	credsInTokenKey         string
+	lastEventTime           time.Time
+	queueConfig             Config
+	overflow                overflowQueue
+	overflowLock            sync.Mutex
+	overflowInflight        bool
+	overflowSignal          chan struct{}
+	sse                     bool
+	orderer                 *orderer
+	orderIn                 chan *elemental.Event
+	stats                   *stats
 }
 
 // NewSubscriber creates a new Subscription.
+// It connects to the first of the given urls, and moves
+// to the next one when the connection dies or fails.
 func NewSubscriber(
-	url string,
+	urls []string,
 	ns string,
 	token string,
 	registerTokenNotifier func(string, func(string)),
@@ -68,12 +105,25 @@ func NewSubscriber(
 	supportErrorEvents bool,
 	recursive bool,
 	credsInTokenKey string,
+	queueConfig Config,
 ) manipulate.Subscriber {
 
+	if len(urls) == 0 {
+		panic("no url given to push.NewSubscriber")
+	}
+
 	if headers == nil {
 		headers = http.Header{}
 	}
 
+	if queueConfig.EventsChSize <= 0 {
+		queueConfig.EventsChSize = eventChSize
+	}
+
+	if queueConfig.ErrorsChSize <= 0 {
+		queueConfig.ErrorsChSize = errorChSize
+	}
+
 	readEncoding, writeEncoding, err := elemental.EncodingFromHeaders(headers)
 	if err != nil {
 		panic(err)
@@ -81,7 +131,7 @@ func NewSubscriber(
 
 	return &subscription{
 		id:                      uuid.Must(uuid.NewV4()).String(),
-		url:                     url,
+		urls:                    urls,
 		ns:                      ns,
 		recursive:               recursive,
 		supportErrorEvents:      supportErrorEvents,
@@ -89,14 +139,19 @@ func NewSubscriber(
 		currentTokenLock:        sync.RWMutex{},
 		unregisterTokenNotifier: unregisterTokenNotifier,
 		registerTokenNotifier:   registerTokenNotifier,
-		events:                  make(chan *elemental.Event, eventChSize),
-		errors:                  make(chan error, errorChSize),
+		events:                  make(chan *elemental.Event, queueConfig.EventsChSize),
+		errors:                  make(chan error, queueConfig.ErrorsChSize),
 		status:                  make(chan manipulate.SubscriberStatus, statusChSize),
 		filters:                 make(chan *elemental.PushConfig, filterChSize),
+		tokens:                  make(chan string, tokenChSize),
 		currentFilterLock:       sync.RWMutex{},
 		readEncoding:            readEncoding,
 		writeEncoding:           writeEncoding,
 		credsInTokenKey:         credsInTokenKey,
+		queueConfig:             queueConfig,
+		overflowSignal:          make(chan struct{}, 1),
+		sse:                     queueConfig.Transport == TransportSSE,
+		stats:                   newStats(),
 		config: wsc.Config{
 			PongWait:     10 * time.Second,
 			WriteWait:    10 * time.Second,
@@ -111,6 +166,15 @@ func NewSubscriber(
 func (s *subscription) Events() chan *elemental.Event            { return s.events }
 func (s *subscription) Errors() chan error                       { return s.errors }
 func (s *subscription) Status() chan manipulate.SubscriberStatus { return s.status }
+func (s *subscription) DetectsGaps() bool                        { return true }
+
+func (s *subscription) Stats() manipulate.SubscriberStats {
+
+	out := s.stats.snapshot()
+	out.Filter = s.getCurrentFilter()
+
+	return out
+}
 
 func (s *subscription) Start(ctx context.Context, filter *elemental.PushConfig) {
 
@@ -120,6 +184,32 @@ func (s *subscription) Start(ctx context.Context, filter *elemental.PushConfig)
 
 	s.registerTokenNotifier(s.id, s.setCurrentToken)
 
+	switch s.queueConfig.OverflowPolicy {
+
+	case OverflowCoalesce:
+		s.overflow = newCoalesceQueue()
+
+	case OverflowSpill:
+		q, err := newSpillQueue(s.queueConfig.SpillDir, s.queueConfig.SpillMaxSize, s.readEncoding)
+		if err != nil {
+			// We cannot spill, so we drop events like by default.
+			s.publishError(err)
+			s.queueConfig.OverflowPolicy = OverflowDrop
+			break
+		}
+		s.overflow = q
+	}
+
+	if s.overflow != nil {
+		go s.pump(ctx)
+	}
+
+	if s.queueConfig.DedupWindow > 0 || s.queueConfig.ReorderDelay > 0 {
+		s.orderer = newOrderer(s.queueConfig.DedupWindow, s.queueConfig.ReorderDelay)
+		s.orderIn = make(chan *elemental.Event, orderChSize)
+		go s.order(ctx)
+	}
+
 	go s.listen(ctx)
 }
 
@@ -133,7 +223,9 @@ func (s *subscription) UpdateFilter(filter *elemental.PushConfig) {
 	}
 }
 
-func (s *subscription) connect(ctx context.Context, initial bool) (err error) {
+// connect connects to the server. When reconnecting, since must be the
+// time from which the server should replay the events.
+func (s *subscription) connect(ctx context.Context, initial bool, since string) (err error) {
 
 	var resp *http.Response
 	var try int
@@ -149,20 +241,43 @@ func (s *subscription) connect(ctx context.Context, initial bool) (err error) {
 		}
 
 		var url string
-		switch s.credsInTokenKey {
-		case "":
-			url = makeURL(s.url, s.ns, s.getCurrentToken(), s.recursive, s.supportErrorEvents)
-		default:
-			url = makeURL(s.url, s.ns, "", s.recursive, s.supportErrorEvents)
+		switch {
+		case s.queueConfig.AuthorizationScheme != "":
+			url = makeURL(s.urls[s.currentURL], s.ns, "", s.recursive, s.supportErrorEvents, since)
+			s.config.Headers.Set("Authorization", fmt.Sprintf("%s %s", s.queueConfig.AuthorizationScheme, s.getCurrentToken()))
+		case s.credsInTokenKey != "":
+			url = makeURL(s.urls[s.currentURL], s.ns, "", s.recursive, s.supportErrorEvents, since)
 			s.config.Headers.Set("Cookie", fmt.Sprintf("%s=%s", s.credsInTokenKey, s.getCurrentToken()))
+		default:
+			url = makeURL(s.urls[s.currentURL], s.ns, s.getCurrentToken(), s.recursive, s.supportErrorEvents, since)
+		}
+
+		if s.sse {
+			s.conn, resp, err = connectSSE(ctx, url, s.config, s.writeEncoding, s.queueConfig.HTTPClient)
+		} else {
+			s.conn, resp, err = wsc.Connect(ctx, url, s.config)
 		}
 
-		if s.conn, resp, err = wsc.Connect(ctx, url, s.config); err == nil {
+		if err == nil {
+
+			replayed := resp.Header.Get(replayHeader) == "true"
+
+			s.stats.connected(initial)
 
 			if initial {
 				s.publishStatus(manipulate.SubscriberStatusInitialConnection)
 			} else {
 				s.publishStatus(manipulate.SubscriberStatusReconnection)
+				if !replayed {
+					s.publishGap(ctx)
+				}
+			}
+
+			// If the server does not replay the events, we will
+			// not miss any event from the time it accepted the
+			// connection. We only use the clock of the server.
+			if !replayed {
+				s.lastEventTime = serverTime(resp)
 			}
 
 			_ = resp.Body.Close() // nolint
@@ -170,20 +285,33 @@ func (s *subscription) connect(ctx context.Context, initial bool) (err error) {
 			return nil
 		}
 
+		// If the fallback is enabled and the upgrade has been refused
+		// by something that does not speak websocket, we use
+		// server-sent events from now on.
+		if !s.sse && s.queueConfig.Transport == TransportAuto && upgradeRefused(resp) {
+			s.sse = true
+			continue
+		}
+
 		if initial {
 			s.publishStatus(manipulate.SubscriberStatusInitialConnectionFailure)
 		} else {
 			s.publishStatus(manipulate.SubscriberStatusReconnectionFailure)
 		}
 
-		if resp == nil {
+		s.nextURL()
+
+		if resp == nil || (resp.StatusCode >= 200 && resp.StatusCode < 300) {
 			s.errors <- err
 		} else if resp.StatusCode != http.StatusSwitchingProtocols {
 			s.errors <- decodeErrors(resp.Body, s.writeEncoding)
 		}
 
+		backoff := nextBackoff(try)
+		s.stats.waiting(backoff)
+
 		select {
-		case <-time.After(nextBackoff(try)):
+		case <-time.After(backoff):
 		case <-ctx.Done():
 			s.publishStatus(manipulate.SubscriberStatusFinalDisconnection)
 		}
@@ -195,16 +323,35 @@ func (s *subscription) listen(ctx context.Context) {
 
 	var err error
 	var isReconnection bool
-	var filterData []byte
+	var since string
 
 	for {
 
-		if err = s.connect(ctx, !isReconnection); err != nil {
+		// If we do not know the time of the server, we cannot
+		// ask for a replay, so a gap will be reported.
+		since = ""
+		if isReconnection && !s.lastEventTime.IsZero() {
+			since = s.lastEventTime.Format(time.RFC3339Nano)
+		}
+
+		if err = s.connect(ctx, !isReconnection, since); err != nil {
 			s.publishError(err)
 			return
 		}
-		// If we have a current filter, we send it right away
-		if f := s.getCurrentFilter(); f != nil {
+
+		// If we have a current filter, we send it right away.
+		// When reconnecting, we send it with the time from which
+		// the server should replay the events.
+		f := s.getCurrentFilter()
+		if since != "" {
+			if rf, rerr := resumeFilter(f, since); rerr != nil {
+				s.publishError(rerr)
+			} else {
+				f = rf
+			}
+		}
+
+		if f != nil {
 			select {
 			case s.filters <- f:
 			default:
@@ -219,24 +366,26 @@ func (s *subscription) listen(ctx context.Context) {
 			select {
 
 			case filter := <-s.filters:
+				s.writeFilter(filter)
 
-				filterData, err = elemental.Encode(s.writeEncoding, filter)
-				if err != nil {
-					s.publishError(err)
-					continue
-				}
-
-				s.conn.Write(filterData)
+			case token := <-s.tokens:
+				s.writeToken(token)
 
 			case data := <-s.conn.Read():
 
 				event := &elemental.Event{}
-				if err = elemental.Decode(s.readEncoding, data, event); err != nil {
+				if err = elemental.Decode(s.connReadEncoding(), data, event); err != nil {
 					s.publishError(err)
 					continue
 				}
 
-				s.publishEvent(event)
+				s.stats.eventReceived(event.Identity)
+
+				if !event.Timestamp.IsZero() {
+					s.lastEventTime = event.Timestamp
+				}
+
+				s.forwardEvent(ctx, event)
 
 			case err = <-s.conn.Error():
 				s.publishError(err)
@@ -253,13 +402,59 @@ func (s *subscription) listen(ctx context.Context) {
 
 				s.unregisterTokenNotifier(s.id)
 				s.conn.Close(websocket.CloseGoingAway)
+				s.stats.disconnected()
 				s.publishStatus(manipulate.SubscriberStatusFinalDisconnection)
 				return
 			}
 		}
 
+		s.stats.disconnected()
 		s.publishStatus(manipulate.SubscriberStatusDisconnection)
+
+		// The connection died, so we reconnect to the next url.
+		s.nextURL()
+	}
+}
+
+// writeFilter sends the given filter to the server.
+func (s *subscription) writeFilter(filter *elemental.PushConfig) {
+
+	data, err := elemental.Encode(s.writeEncoding, filter)
+	if err != nil {
+		s.publishError(err)
+		return
 	}
+
+	s.conn.Write(data)
+}
+
+// writeToken sends the given renewed token to the server.
+func (s *subscription) writeToken(token string) {
+
+	data, err := elemental.Encode(s.writeEncoding, controlMessage{Control: controlTokenRenewal, Token: token})
+	if err != nil {
+		s.publishError(err)
+		return
+	}
+
+	s.conn.Write(data)
+}
+
+// connReadEncoding returns the encoding of the
+// events received through the current connection.
+func (s *subscription) connReadEncoding() elemental.EncodingType {
+
+	// Server-sent events are text, so they are always JSON.
+	if s.sse {
+		return elemental.EncodingTypeJSON
+	}
+
+	return s.readEncoding
+}
+
+// nextURL moves to the next url to connect to.
+func (s *subscription) nextURL() {
+	s.currentURL = (s.currentURL + 1) % len(s.urls)
 }
 
 func (s *subscription) publishError(err error) {
@@ -269,11 +464,199 @@ func (s *subscription) publishError(err error) {
 	}
 }
 
-func (s *subscription) publishEvent(evt *elemental.Event) {
-	select {
-	case s.events <- evt:
+// forwardEvent publishes the given event, going through
+// the ordering stage if it is enabled.
+func (s *subscription) forwardEvent(ctx context.Context, evt *elemental.Event) {
+
+	switch {
+	case s.orderer != nil:
+		s.deliver(ctx, s.orderIn, evt)
+	case s.queueConfig.OverflowPolicy == OverflowBlock:
+		s.deliver(ctx, s.events, evt)
+	default:
+		s.publishEvent(ctx, evt)
+	}
+}
+
+// deliver sends the given event to the given channel, blocking until it is
+// read or the given context is done. As it is called from the listen loop,
+// it keeps sending the filter updates and renewed tokens while it blocks.
+func (s *subscription) deliver(ctx context.Context, ch chan *elemental.Event, evt *elemental.Event) {
+
+	for {
+		select {
+		case ch <- evt:
+			return
+		case filter := <-s.filters:
+			s.writeFilter(filter)
+		case token := <-s.tokens:
+			s.writeToken(token)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// order de-duplicates and reorders the events
+// until the given context is done.
+func (s *subscription) order(ctx context.Context) {
+
+	var timer *time.Timer
+	var timerC <-chan time.Time
+
+	for {
+
+		select {
+
+		case evt := <-s.orderIn:
+			for _, e := range s.orderer.add(evt, time.Now()) {
+				s.publishEvent(ctx, e)
+			}
+
+		case <-timerC:
+
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		}
+
+		for _, e := range s.orderer.due(time.Now()) {
+			s.publishEvent(ctx, e)
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+
+		timerC = nil
+		if deadline, ok := s.orderer.next(); ok {
+			timer = time.NewTimer(time.Until(deadline))
+			timerC = timer.C
+		}
+	}
+}
+
+func (s *subscription) publishEvent(ctx context.Context, evt *elemental.Event) {
+
+	switch s.queueConfig.OverflowPolicy {
+
+	case OverflowBlock:
+		select {
+		case s.events <- evt:
+		case <-ctx.Done():
+		}
+
+	case OverflowDropOldest:
+		for {
+			select {
+			case s.events <- evt:
+				return
+			default:
+			}
+
+			select {
+			case old := <-s.events:
+				s.stats.eventDropped(old.Identity)
+				s.publishError(fmt.Errorf("channel full: oldest event dropped"))
+			default:
+			}
+		}
+
+	case OverflowCoalesce, OverflowSpill:
+
+		s.overflowLock.Lock()
+
+		// To keep the events in order, we can only publish
+		// directly when no event is waiting in the queue.
+		if s.overflow.len() == 0 && !s.overflowInflight {
+			select {
+			case s.events <- evt:
+				s.overflowLock.Unlock()
+				return
+			default:
+			}
+		}
+
+		err := s.overflow.push(evt)
+		s.overflowLock.Unlock()
+
+		if err != nil {
+			s.stats.eventDropped(evt.Identity)
+			s.publishError(err)
+			return
+		}
+
+		select {
+		case s.overflowSignal <- struct{}{}:
+		default:
+		}
+
 	default:
-		s.publishError(fmt.Errorf("unable to forward event: channel full"))
+		select {
+		case s.events <- evt:
+		default:
+			s.stats.eventDropped(evt.Identity)
+			s.publishError(fmt.Errorf("unable to forward event: channel full"))
+		}
+	}
+}
+
+// pump publishes the events of the overflow queue
+// until the given context is done.
+func (s *subscription) pump(ctx context.Context) {
+
+	defer func() {
+		s.overflowLock.Lock()
+		_ = s.overflow.close() // nolint
+		s.overflowLock.Unlock()
+	}()
+
+	var failures int
+
+	for {
+
+		s.overflowLock.Lock()
+		evt, err := s.overflow.pop()
+		s.overflowInflight = evt != nil
+		s.overflowLock.Unlock()
+
+		// If the queue keeps failing, we back off so
+		// we do not spin and flood the errors channel.
+		if err != nil {
+			s.publishError(err)
+
+			select {
+			case <-time.After(nextBackoff(failures)):
+			case <-ctx.Done():
+				return
+			}
+
+			failures++
+			continue
+		}
+
+		failures = 0
+
+		if evt == nil {
+			select {
+			case <-s.overflowSignal:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case s.events <- evt:
+		case <-ctx.Done():
+			return
+		}
+
+		s.overflowLock.Lock()
+		s.overflowInflight = false
+		s.overflowLock.Unlock()
 	}
 }
 
@@ -284,12 +667,41 @@ func (s *subscription) publishStatus(st manipulate.SubscriberStatus) {
 	}
 }
 
+// publishGap publishes SubscriberStatusGapDetected. As the consumers
+// rely on it to resync, it is never dropped: it blocks until it is
+// read or the given context is done.
+func (s *subscription) publishGap(ctx context.Context) {
+	select {
+	case s.status <- manipulate.SubscriberStatusGapDetected:
+	case <-ctx.Done():
+	}
+}
+
 func (s *subscription) setCurrentToken(t string) {
 
 	s.currentTokenLock.Lock()
 	s.currentToken = t
 	s.currentTokenLock.Unlock()
 
+	// When authenticating with the Authorization header, the
+	// token is not part of the filter, so we send a control
+	// message instead. Only the latest token matters.
+	if s.queueConfig.AuthorizationScheme != "" {
+
+		select {
+		case <-s.tokens:
+		default:
+		}
+
+		select {
+		case s.tokens <- t:
+		default:
+		}
+
+		s.publishStatus(manipulate.SubscriberStatusTokenRenewal)
+		return
+	}
+
 	// We get the current filter
 	filter := s.getCurrentFilter()
 	if filter == nil {
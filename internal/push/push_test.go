@@ -0,0 +1,186 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.aporeto.io/elemental"
+	testmodel "go.aporeto.io/elemental/test/model"
+	"go.aporeto.io/manipulate"
+)
+
+func Test_publishGap(t *testing.T) {
+
+	s := &subscription{
+		status: make(chan manipulate.SubscriberStatus, 1),
+	}
+
+	s.publishStatus(manipulate.SubscriberStatusReconnection)
+
+	done := make(chan struct{})
+	go func() {
+		s.publishGap(context.Background())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("publishGap should block while the status channel is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if st := <-s.status; st != manipulate.SubscriberStatusReconnection {
+		t.Errorf("status = %v, want reconnection", st)
+	}
+
+	<-done
+
+	if st := <-s.status; st != manipulate.SubscriberStatusGapDetected {
+		t.Errorf("status = %v, want gap detected", st)
+	}
+
+	s.publishStatus(manipulate.SubscriberStatusReconnection)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s.publishGap(ctx)
+}
+
+func Test_reconnection(t *testing.T) {
+
+	eventTime := time.Date(2019, 3, 4, 5, 6, 7, 8, time.UTC)
+
+	tests := []struct {
+		name   string
+		replay bool
+		want   []manipulate.SubscriberStatus
+	}{
+		{
+			"replayed",
+			true,
+			[]manipulate.SubscriberStatus{
+				manipulate.SubscriberStatusInitialConnection,
+				manipulate.SubscriberStatusDisconnection,
+				manipulate.SubscriberStatusReconnection,
+			},
+		},
+		{
+			"not replayed",
+			false,
+			[]manipulate.SubscriberStatus{
+				manipulate.SubscriberStatusInitialConnection,
+				manipulate.SubscriberStatusDisconnection,
+				manipulate.SubscriberStatusReconnection,
+				manipulate.SubscriberStatusGapDetected,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var connections int32
+			sinces := make(chan string, 2)
+
+			// The first connection sends one event and ends, so the
+			// subscriber reconnects and asks for a replay since then.
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+				if r.Method == http.MethodPost {
+					return
+				}
+
+				sinces <- r.URL.Query().Get(sinceParameter)
+
+				w.Header().Set("Content-Type", sseContentType)
+
+				if atomic.AddInt32(&connections, 1) == 1 {
+
+					evt := elemental.NewEvent(elemental.EventCreate, testmodel.NewList())
+					evt.Timestamp = eventTime
+
+					data, err := elemental.Encode(elemental.EncodingTypeJSON, evt)
+					if err != nil {
+						t.Errorf("unable to encode event: %s", err)
+					}
+
+					fmt.Fprintf(w, "data: %s\n\n", data)
+					return
+				}
+
+				if tt.replay {
+					w.Header().Set(replayHeader, "true")
+				}
+
+				w.WriteHeader(http.StatusOK)
+				w.(http.Flusher).Flush()
+				<-r.Context().Done()
+			}))
+			defer ts.Close()
+
+			s := NewSubscriber(
+				[]string{ts.URL},
+				"/ns",
+				"token",
+				func(string, func(string)) {},
+				func(string) {},
+				nil,
+				nil,
+				false,
+				false,
+				"",
+				Config{Transport: TransportSSE},
+			)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			s.Start(ctx, nil)
+
+			for _, want := range tt.want {
+				select {
+				case st := <-s.Status():
+					if st != want {
+						t.Fatalf("status = %v, want %v", st, want)
+					}
+				case <-time.After(2 * time.Second):
+					t.Fatalf("missing status %v", want)
+				}
+			}
+
+			select {
+			case evt := <-s.Events():
+				if !evt.Timestamp.Equal(eventTime) {
+					t.Errorf("event timestamp = %s, want %s", evt.Timestamp, eventTime)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("missing event")
+			}
+
+			if since := <-sinces; since != "" {
+				t.Errorf("initial since = %s, want none", since)
+			}
+
+			if since := <-sinces; since != eventTime.Format(time.RFC3339Nano) {
+				t.Errorf("since = %s, want the time of the last event", since)
+			}
+		})
+	}
+}
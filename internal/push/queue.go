@@ -0,0 +1,283 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"go.aporeto.io/elemental"
+)
+
+// An OverflowPolicy defines what happens when
+// the events channel of a subscriber is full.
+type OverflowPolicy int
+
+// Various values of OverflowPolicy.
+const (
+	// OverflowDrop drops the new event.
+	OverflowDrop OverflowPolicy = iota
+
+	// OverflowBlock blocks until the event can be published,
+	// which stops reading from the websocket. Filter updates
+	// and renewed tokens are still sent to the server.
+	OverflowBlock
+
+	// OverflowDropOldest drops the oldest event of the channel.
+	OverflowDropOldest
+
+	// OverflowCoalesce keeps the events in memory until they can be
+	// published, merging the updates of an object into its pending event.
+	OverflowCoalesce
+
+	// OverflowSpill keeps the events in a bounded queue on disk
+	// until they can be published.
+	OverflowSpill
+)
+
+// An overflowQueue holds the events that could not be
+// published because the events channel was full.
+type overflowQueue interface {
+	push(*elemental.Event) error
+	pop() (*elemental.Event, error)
+	len() int
+	close() error
+}
+
+// coalesceQueue keeps the events in memory. An update is merged into the
+// pending create or update of the same object, keeping its type, so the
+// consumer never misses a create. Creates and deletes are never merged.
+type coalesceQueue struct {
+	events *list.List
+	index  map[string]*list.Element
+}
+
+func newCoalesceQueue() *coalesceQueue {
+
+	return &coalesceQueue{
+		events: list.New(),
+		index:  map[string]*list.Element{},
+	}
+}
+
+func (q *coalesceQueue) push(evt *elemental.Event) error {
+
+	key := eventKey(evt)
+
+	if key != "" && evt.Type == elemental.EventUpdate {
+		if e, ok := q.index[key]; ok {
+			if pending := e.Value.(*elemental.Event); pending.Type != elemental.EventDelete {
+				evt.Type = pending.Type
+				e.Value = evt
+				return nil
+			}
+		}
+	}
+
+	e := q.events.PushBack(evt)
+
+	if key != "" {
+		q.index[key] = e
+	}
+
+	return nil
+}
+
+func (q *coalesceQueue) pop() (*elemental.Event, error) {
+
+	e := q.events.Front()
+	if e == nil {
+		return nil, nil
+	}
+
+	evt := q.events.Remove(e).(*elemental.Event)
+
+	if key := eventKey(evt); key != "" && q.index[key] == e {
+		delete(q.index, key)
+	}
+
+	return evt, nil
+}
+
+func (q *coalesceQueue) len() int { return q.events.Len() }
+
+func (q *coalesceQueue) close() error { return nil }
+
+// eventKey returns the key of the object of the given
+// event, or an empty string if it cannot be found.
+func eventKey(evt *elemental.Event) string {
+
+	obj := map[string]interface{}{}
+	if err := evt.Decode(&obj); err != nil {
+		return ""
+	}
+
+	id, ok := obj["ID"].(string)
+	if !ok || id == "" {
+		return ""
+	}
+
+	return evt.Identity + "/" + id
+}
+
+// spillQueue keeps the events in a file. Once all the events have been
+// read, the file is truncated. Once more than half of it has been read, or
+// when it is full, the unread events are moved to the start of the file.
+type spillQueue struct {
+	file        *os.File
+	encoding    elemental.EncodingType
+	maxSize     int64
+	readOffset  int64
+	writeOffset int64
+	count       int
+}
+
+func newSpillQueue(dir string, maxSize int64, encoding elemental.EncodingType) (*spillQueue, error) {
+
+	f, err := ioutil.TempFile(dir, "push-spill-")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create spill file: %s", err)
+	}
+
+	return &spillQueue{
+		file:     f,
+		encoding: encoding,
+		maxSize:  maxSize,
+	}, nil
+}
+
+func (q *spillQueue) push(evt *elemental.Event) error {
+
+	data, err := elemental.Encode(q.encoding, evt)
+	if err != nil {
+		return fmt.Errorf("unable to spill event: %s", err)
+	}
+
+	size := int64(4 + len(data))
+	if q.maxSize > 0 && q.writeOffset+size > q.maxSize && q.readOffset > 0 {
+		if err := q.compact(); err != nil {
+			return fmt.Errorf("unable to spill event: %s. %d events dropped", err, q.reset())
+		}
+	}
+
+	if q.maxSize > 0 && q.writeOffset+size > q.maxSize {
+		return fmt.Errorf("unable to spill event: spill file full")
+	}
+
+	buf := make([]byte, size)
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[4:], data)
+
+	if _, err := q.file.WriteAt(buf, q.writeOffset); err != nil {
+		return fmt.Errorf("unable to spill event: %s", err)
+	}
+
+	q.writeOffset += size
+	q.count++
+
+	return nil
+}
+
+func (q *spillQueue) pop() (*elemental.Event, error) {
+
+	if q.count == 0 {
+		return nil, nil
+	}
+
+	// If we cannot read the file, we cannot read any
+	// of the next events either, so we drop them all.
+	header := make([]byte, 4)
+	if _, err := q.file.ReadAt(header, q.readOffset); err != nil {
+		return nil, fmt.Errorf("unable to read spilled events: %s. %d events dropped", err, q.reset())
+	}
+
+	data := make([]byte, binary.BigEndian.Uint32(header))
+	if _, err := q.file.ReadAt(data, q.readOffset+4); err != nil {
+		return nil, fmt.Errorf("unable to read spilled events: %s. %d events dropped", err, q.reset())
+	}
+
+	q.readOffset += int64(4 + len(data))
+	q.count--
+
+	switch {
+	case q.count == 0:
+		q.reset()
+	case q.readOffset > q.writeOffset-q.readOffset:
+		if err := q.compact(); err != nil {
+			return nil, fmt.Errorf("unable to compact spilled events: %s. %d events dropped", err, q.reset())
+		}
+	}
+
+	evt := &elemental.Event{}
+	if err := elemental.Decode(q.encoding, data, evt); err != nil {
+		return nil, fmt.Errorf("unable to decode spilled event: %s", err)
+	}
+
+	return evt, nil
+}
+
+func (q *spillQueue) len() int { return q.count }
+
+// compact moves the unread events to the start of the file.
+func (q *spillQueue) compact() error {
+
+	buf := make([]byte, 32*1024)
+	var n int64
+
+	for q.readOffset+n < q.writeOffset {
+
+		chunk := buf
+		if remaining := q.writeOffset - q.readOffset - n; remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+
+		if _, err := q.file.ReadAt(chunk, q.readOffset+n); err != nil {
+			return err
+		}
+
+		if _, err := q.file.WriteAt(chunk, n); err != nil {
+			return err
+		}
+
+		n += int64(len(chunk))
+	}
+
+	if err := q.file.Truncate(n); err != nil {
+		return err
+	}
+
+	q.readOffset, q.writeOffset = 0, n
+
+	return nil
+}
+
+// reset empties the queue and returns the
+// number of events that have been dropped.
+func (q *spillQueue) reset() int {
+
+	dropped := q.count
+
+	_ = q.file.Truncate(0) // nolint
+	q.readOffset, q.writeOffset, q.count = 0, 0, 0
+
+	return dropped
+}
+
+func (q *spillQueue) close() error {
+
+	_ = q.file.Close() // nolint
+
+	return os.Remove(q.file.Name())
+}
@@ -0,0 +1,428 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.aporeto.io/elemental"
+	testmodel "go.aporeto.io/elemental/test/model"
+)
+
+func newTestEvent(t elemental.EventType, id string, name string) *elemental.Event {
+
+	l := testmodel.NewList()
+	l.ID = id
+	l.Name = name
+
+	return elemental.NewEvent(t, l)
+}
+
+func eventName(t *testing.T, evt *elemental.Event) string {
+
+	l := testmodel.NewList()
+	if err := evt.Decode(l); err != nil {
+		t.Fatalf("unable to decode event: %s", err)
+	}
+
+	return l.Name
+}
+
+func Test_coalesceQueue(t *testing.T) {
+
+	q := newCoalesceQueue()
+
+	_ = q.push(newTestEvent(elemental.EventCreate, "1", "a"))
+	_ = q.push(newTestEvent(elemental.EventCreate, "2", "b"))
+	_ = q.push(newTestEvent(elemental.EventUpdate, "1", "c"))
+
+	if q.len() != 2 {
+		t.Fatalf("len() = %d, want 2", q.len())
+	}
+
+	evt, _ := q.pop()
+	if evt.Type != elemental.EventCreate || eventName(t, evt) != "c" {
+		t.Errorf("pop() = %s %s, want the create of the first object with the latest data", evt.Type, eventName(t, evt))
+	}
+
+	evt, _ = q.pop()
+	if eventName(t, evt) != "b" {
+		t.Errorf("pop() = %s, want b", eventName(t, evt))
+	}
+
+	_ = q.push(newTestEvent(elemental.EventDelete, "1", "d"))
+	evt, _ = q.pop()
+	if evt.Type != elemental.EventDelete {
+		t.Errorf("pop() = %s, want a new event once the previous one has been popped", evt.Type)
+	}
+
+	if evt, _ = q.pop(); evt != nil {
+		t.Errorf("pop() = %v, want nil", evt)
+	}
+}
+
+func Test_coalesceQueueCreateDelete(t *testing.T) {
+
+	q := newCoalesceQueue()
+
+	_ = q.push(newTestEvent(elemental.EventCreate, "1", "a"))
+	_ = q.push(newTestEvent(elemental.EventUpdate, "1", "b"))
+	_ = q.push(newTestEvent(elemental.EventDelete, "1", "c"))
+	_ = q.push(newTestEvent(elemental.EventUpdate, "1", "d"))
+
+	want := []struct {
+		typ  elemental.EventType
+		name string
+	}{
+		{elemental.EventCreate, "b"},
+		{elemental.EventDelete, "c"},
+		{elemental.EventUpdate, "d"},
+	}
+
+	if q.len() != len(want) {
+		t.Fatalf("len() = %d, want %d", q.len(), len(want))
+	}
+
+	for _, w := range want {
+		evt, _ := q.pop()
+		if evt.Type != w.typ || eventName(t, evt) != w.name {
+			t.Errorf("pop() = %s %s, want %s %s", evt.Type, eventName(t, evt), w.typ, w.name)
+		}
+	}
+}
+
+func Test_spillQueue(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint
+
+	q, err := newSpillQueue(dir, 0, elemental.EncodingTypeMSGPACK)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a", "b", "c"} {
+		if err := q.push(newTestEvent(elemental.EventCreate, name, name)); err != nil {
+			t.Fatalf("push() error = %s", err)
+		}
+	}
+
+	if q.len() != 3 {
+		t.Fatalf("len() = %d, want 3", q.len())
+	}
+
+	for _, name := range []string{"a", "b", "c"} {
+		evt, err := q.pop()
+		if err != nil {
+			t.Fatalf("pop() error = %s", err)
+		}
+		if eventName(t, evt) != name {
+			t.Errorf("pop() = %s, want %s", eventName(t, evt), name)
+		}
+	}
+
+	if info, _ := q.file.Stat(); info.Size() != 0 {
+		t.Errorf("file size = %d, want 0 once drained", info.Size())
+	}
+
+	path := q.file.Name()
+	if err := q.close(); err != nil {
+		t.Fatalf("close() error = %s", err)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("spill file should have been removed")
+	}
+}
+
+func Test_spillQueueFull(t *testing.T) {
+
+	q, err := newSpillQueue("", 10, elemental.EncodingTypeMSGPACK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.close() // nolint
+
+	if err := q.push(newTestEvent(elemental.EventCreate, "1", "a")); err == nil {
+		t.Errorf("push() should fail when the file is full")
+	}
+}
+
+// failingQueue is an overflowQueue that always fails to pop.
+type failingQueue struct {
+	pops int32
+}
+
+func (q *failingQueue) push(*elemental.Event) error { return nil }
+func (q *failingQueue) pop() (*elemental.Event, error) {
+	atomic.AddInt32(&q.pops, 1)
+	return nil, fmt.Errorf("boom")
+}
+func (q *failingQueue) len() int     { return 1 }
+func (q *failingQueue) close() error { return nil }
+
+func Test_pumpBackoff(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	q := &failingQueue{}
+	s := &subscription{
+		events:         make(chan *elemental.Event, 1),
+		errors:         make(chan error, 100),
+		overflow:       q,
+		overflowSignal: make(chan struct{}, 1),
+		stats:          newStats(),
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.pump(ctx)
+		close(done)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	<-done
+
+	if n := atomic.LoadInt32(&q.pops); n < 2 || n > 10 {
+		t.Errorf("pops = %d, want the pump to back off after failures", n)
+	}
+	if len(s.errors) != int(atomic.LoadInt32(&q.pops)) {
+		t.Errorf("errors = %d, want one per failure", len(s.errors))
+	}
+}
+
+func Test_spillQueueCompaction(t *testing.T) {
+
+	evt := newTestEvent(elemental.EventCreate, "1", "00")
+	data, err := elemental.Encode(elemental.EncodingTypeMSGPACK, evt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(4 + len(data))
+
+	q, err := newSpillQueue("", 3*size, elemental.EncodingTypeMSGPACK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.close() // nolint
+
+	// The consumer always stays behind by a few events, so the
+	// queue is never drained and the file must not keep growing.
+	for i := 0; i < 3; i++ {
+		if err := q.push(newTestEvent(elemental.EventCreate, "1", fmt.Sprintf("%02d", i))); err != nil {
+			t.Fatalf("push() error = %s", err)
+		}
+	}
+
+	for i := 3; i < 100; i++ {
+
+		evt, err := q.pop()
+		if err != nil {
+			t.Fatalf("pop() error = %s", err)
+		}
+		if name := eventName(t, evt); name != fmt.Sprintf("%02d", i-3) {
+			t.Fatalf("pop() = %s, want %02d", name, i-3)
+		}
+
+		if err := q.push(newTestEvent(elemental.EventCreate, "1", fmt.Sprintf("%02d", i))); err != nil {
+			t.Fatalf("push() %d error = %s", i, err)
+		}
+	}
+
+	if info, _ := q.file.Stat(); info.Size() > 3*size {
+		t.Errorf("file size = %d, want at most %d", info.Size(), 3*size)
+	}
+
+	for i := 97; i < 100; i++ {
+		evt, err := q.pop()
+		if err != nil {
+			t.Fatalf("pop() error = %s", err)
+		}
+		if name := eventName(t, evt); name != fmt.Sprintf("%02d", i) {
+			t.Errorf("pop() = %s, want %02d", name, i)
+		}
+	}
+}
+
+func Test_publishEvent(t *testing.T) {
+
+	newSub := func(policy OverflowPolicy) *subscription {
+		return &subscription{
+			events:         make(chan *elemental.Event, 1),
+			errors:         make(chan error, 10),
+			queueConfig:    Config{OverflowPolicy: policy},
+			overflowSignal: make(chan struct{}, 1),
+			stats:          newStats(),
+		}
+	}
+
+	t.Run("drop", func(t *testing.T) {
+
+		s := newSub(OverflowDrop)
+		s.publishEvent(context.Background(), newTestEvent(elemental.EventCreate, "1", "a"))
+		s.publishEvent(context.Background(), newTestEvent(elemental.EventCreate, "2", "b"))
+
+		if name := eventName(t, <-s.events); name != "a" {
+			t.Errorf("event = %s, want a", name)
+		}
+		if len(s.errors) != 1 {
+			t.Errorf("errors = %d, want 1", len(s.errors))
+		}
+		if n := s.stats.snapshot().EventsDropped["list"]; n != 1 {
+			t.Errorf("dropped = %d, want 1", n)
+		}
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+
+		s := newSub(OverflowDropOldest)
+		s.publishEvent(context.Background(), newTestEvent(elemental.EventCreate, "1", "a"))
+		s.publishEvent(context.Background(), newTestEvent(elemental.EventCreate, "2", "b"))
+
+		if name := eventName(t, <-s.events); name != "b" {
+			t.Errorf("event = %s, want b", name)
+		}
+		if len(s.errors) != 1 {
+			t.Errorf("errors = %d, want 1", len(s.errors))
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+
+		s := newSub(OverflowBlock)
+		s.publishEvent(context.Background(), newTestEvent(elemental.EventCreate, "1", "a"))
+
+		done := make(chan struct{})
+		go func() {
+			s.publishEvent(context.Background(), newTestEvent(elemental.EventCreate, "2", "b"))
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			t.Fatalf("publishEvent should block")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		<-s.events
+		<-done
+
+		if name := eventName(t, <-s.events); name != "b" {
+			t.Errorf("event = %s, want b", name)
+		}
+	})
+
+	t.Run("coalesce", func(t *testing.T) {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		s := newSub(OverflowCoalesce)
+		s.overflow = newCoalesceQueue()
+
+		s.publishEvent(ctx, newTestEvent(elemental.EventCreate, "1", "a"))
+		s.publishEvent(ctx, newTestEvent(elemental.EventCreate, "2", "b"))
+		s.publishEvent(ctx, newTestEvent(elemental.EventUpdate, "2", "c"))
+
+		go s.pump(ctx)
+
+		var names []string
+		for i := 0; i < 2; i++ {
+			select {
+			case evt := <-s.events:
+				names = append(names, eventName(t, evt))
+			case <-time.After(time.Second):
+				t.Fatalf("missing events: %v", names)
+			}
+		}
+
+		if names[0] != "a" || names[1] != "c" {
+			t.Errorf("events = %v, want [a c]", names)
+		}
+		if len(s.errors) != 0 {
+			t.Errorf("errors = %d, want 0", len(s.errors))
+		}
+	})
+}
+
+// writeConn is a wsc.Websocket recording what is written.
+type writeConn struct {
+	writes chan []byte
+}
+
+func (c *writeConn) Read() chan []byte { return nil }
+func (c *writeConn) Error() chan error { return nil }
+func (c *writeConn) Done() chan error  { return nil }
+func (c *writeConn) Write(data []byte) { c.writes <- data }
+func (c *writeConn) Close(int)         {}
+
+func Test_forwardEventBlock(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	conn := &writeConn{writes: make(chan []byte, 10)}
+	s := &subscription{
+		conn:          conn,
+		events:        make(chan *elemental.Event, 1),
+		errors:        make(chan error, 10),
+		filters:       make(chan *elemental.PushConfig, filterChSize),
+		tokens:        make(chan string, tokenChSize),
+		queueConfig:   Config{OverflowPolicy: OverflowBlock},
+		writeEncoding: elemental.EncodingTypeJSON,
+		stats:         newStats(),
+	}
+
+	s.forwardEvent(ctx, newTestEvent(elemental.EventCreate, "1", "a"))
+
+	done := make(chan struct{})
+	go func() {
+		s.forwardEvent(ctx, newTestEvent(elemental.EventCreate, "2", "b"))
+		close(done)
+	}()
+
+	// While blocked, the filter updates and
+	// renewed tokens must still be sent.
+	s.UpdateFilter(elemental.NewPushConfig())
+	s.tokens <- "token"
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-conn.writes:
+		case <-time.After(time.Second):
+			t.Fatalf("control messages should be sent while blocked")
+		}
+	}
+
+	select {
+	case <-done:
+		t.Fatalf("forwardEvent should block")
+	default:
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("forwardEvent should return once the context is done")
+	}
+}
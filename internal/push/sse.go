@@ -0,0 +1,356 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/wsc"
+)
+
+// A Transport defines how a subscriber receives the events.
+type Transport int
+
+// Various values of Transport.
+const (
+	// TransportWebsocket only uses a websocket. This is the default.
+	TransportWebsocket Transport = iota
+
+	// TransportSSE only uses server-sent events.
+	TransportSSE
+
+	// TransportAuto uses a websocket, and falls back to
+	// server-sent events if the upgrade is refused, for
+	// instance by a proxy stripping the Upgrade headers.
+	TransportAuto
+)
+
+const (
+	// sessionHeader is the header the server sets in the
+	// server-sent events response to identify the session.
+	// The filters are posted with the same header.
+	sessionHeader = "X-Push-Session"
+
+	sseContentType = "text/event-stream"
+	sseWriteChSize = 8
+)
+
+// sseConn is a wsc.Websocket receiving the events through
+// server-sent events, and posting the filters with regular
+// requests. The events are always sent as JSON by the server.
+type sseConn struct {
+	client    *http.Client
+	ownClient bool
+	url       string
+	session   string
+	headers   http.Header
+	encoding  elemental.EncodingType
+	pongWait  time.Duration
+	readChan  chan []byte
+	writeChan chan []byte
+	errorChan chan error
+	doneChan  chan error
+	ctx       context.Context
+	cancel    context.CancelFunc
+	timedOut  int32
+}
+
+// connectSSE connects to the given url using server-sent events,
+// with the given client so its transport, TLS and proxy settings
+// are used. If client is nil, a client using the TLS config of the
+// given config is created. Like wsc.Connect, it returns the response
+// when the server answered.
+func connectSSE(ctx context.Context, url string, config wsc.Config, encoding elemental.EncodingType, client *http.Client) (wsc.Websocket, *http.Response, error) {
+
+	subctx, cancel := context.WithCancel(ctx)
+
+	ownClient := client == nil
+
+	if ownClient {
+		client = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: config.TLSConfig,
+			},
+		}
+	} else {
+		// The stream is long lived, so it
+		// cannot be bound by the client timeout.
+		c := *client
+		c.Timeout = 0
+		client = &c
+	}
+
+	headers := config.Headers.Clone()
+
+	req, err := http.NewRequest(http.MethodGet, sseURL(url), nil)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	req = req.WithContext(subctx)
+	req.Header = headers.Clone()
+	req.Header.Set("Accept", sseContentType)
+	req.Header.Set("Cache-Control", "no-cache")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		detachBody(resp, cancel)
+		return nil, resp, fmt.Errorf("unable to connect using server-sent events: %s", resp.Status)
+	}
+
+	if ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); ct != sseContentType {
+		detachBody(resp, cancel)
+		return nil, resp, fmt.Errorf("unable to connect using server-sent events: unexpected content type '%s'", ct)
+	}
+
+	c := &sseConn{
+		client:    client,
+		ownClient: ownClient,
+		url:       sseURL(url),
+		session:   resp.Header.Get(sessionHeader),
+		headers:   headers,
+		encoding:  encoding,
+		pongWait:  config.PongWait,
+		readChan:  make(chan []byte, config.ReadChanSize),
+		writeChan: make(chan []byte, sseWriteChSize),
+		errorChan: make(chan error, 64),
+		doneChan:  make(chan error, 1),
+		ctx:       subctx,
+		cancel:    cancel,
+	}
+
+	go c.readLoop(resp.Body)
+	go c.writeLoop()
+
+	// The body is consumed by the read loop, so we
+	// return a copy of the response without it.
+	r := *resp
+	r.Body = http.NoBody
+
+	return c, &r, nil
+}
+
+func (c *sseConn) Read() chan []byte { return c.readChan }
+func (c *sseConn) Error() chan error { return c.errorChan }
+func (c *sseConn) Done() chan error  { return c.doneChan }
+
+func (c *sseConn) Write(data []byte) {
+
+	select {
+	case c.writeChan <- data:
+	case <-c.ctx.Done():
+	}
+}
+
+func (c *sseConn) Close(code int) {
+
+	c.cancel()
+
+	// The connections of a given client are shared
+	// with its other users, so we leave them alone.
+	if c.ownClient {
+		c.client.CloseIdleConnections()
+	}
+}
+
+// readLoop reads the events of the given stream until
+// it ends, or no data has been received for pongWait.
+func (c *sseConn) readLoop(body io.ReadCloser) {
+
+	defer body.Close() // nolint
+
+	var timer *time.Timer
+	if c.pongWait > 0 {
+		timer = time.AfterFunc(c.pongWait, func() {
+			atomic.StoreInt32(&c.timedOut, 1)
+			c.cancel()
+		})
+		defer timer.Stop()
+	}
+
+	r := bufio.NewReader(body)
+	data := &bytes.Buffer{}
+
+	for {
+
+		line, err := r.ReadBytes('\n')
+
+		if timer != nil {
+			timer.Reset(c.pongWait)
+		}
+
+		if err != nil {
+
+			// If we canceled the request ourselves, it was closed
+			// or it timed out. Only the latter is an error.
+			if c.ctx.Err() != nil {
+				if atomic.LoadInt32(&c.timedOut) == 1 {
+					c.doneChan <- fmt.Errorf("no data received for %s", c.pongWait)
+				} else {
+					c.doneChan <- nil
+				}
+				return
+			}
+
+			if err == io.EOF {
+				c.doneChan <- nil
+			} else {
+				c.doneChan <- err
+			}
+
+			c.cancel()
+			return
+		}
+
+		line = bytes.TrimRight(line, "\r\n")
+
+		switch {
+
+		// An empty line dispatches the event.
+		case len(line) == 0:
+
+			if data.Len() == 0 {
+				continue
+			}
+
+			select {
+			case c.readChan <- append([]byte{}, data.Bytes()...):
+			case <-c.ctx.Done():
+			}
+
+			data.Reset()
+
+		// Comments are used as keepalives.
+		case line[0] == ':':
+
+		case bytes.HasPrefix(line, []byte("data:")):
+
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+
+			data.Write(bytes.TrimPrefix(bytes.TrimPrefix(line, []byte("data:")), []byte(" ")))
+		}
+	}
+}
+
+// writeLoop posts the written data to the server,
+// in order, until the connection is closed.
+func (c *sseConn) writeLoop() {
+
+	for {
+		select {
+
+		case data := <-c.writeChan:
+			if err := c.post(data); err != nil {
+				select {
+				case c.errorChan <- err:
+				default:
+				}
+			}
+
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *sseConn) post(data []byte) error {
+
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req = req.WithContext(c.ctx)
+	req.Header = c.headers.Clone()
+
+	if c.session != "" {
+		req.Header.Set(sessionHeader, c.session)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close() // nolint
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return decodeErrors(resp.Body, c.encoding)
+	}
+
+	return nil
+}
+
+// detachBody reads the body of the given response
+// in memory so the request can be canceled.
+func detachBody(resp *http.Response, cancel context.CancelFunc) {
+
+	data, _ := ioutil.ReadAll(resp.Body) // nolint
+	_ = resp.Body.Close()                // nolint
+	cancel()
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+}
+
+// sseURL returns the http url corresponding to the given websocket url.
+func sseURL(u string) string {
+
+	switch {
+	case strings.HasPrefix(u, "wss://"):
+		return "https://" + strings.TrimPrefix(u, "wss://")
+	case strings.HasPrefix(u, "ws://"):
+		return "http://" + strings.TrimPrefix(u, "ws://")
+	default:
+		return u
+	}
+}
+
+// upgradeRefused returns true if the given response to a websocket
+// upgrade shows that the upgrade has been refused by something
+// that does not speak websocket, like a proxy stripping the
+// Upgrade headers, rather than by the server itself. Other
+// errors, like a 400, are reported as connection errors.
+func upgradeRefused(resp *http.Response) bool {
+
+	if resp == nil {
+		return false
+	}
+
+	switch resp.StatusCode {
+	case http.StatusUpgradeRequired:
+		return true
+	case http.StatusOK:
+		return resp.Header.Get("Upgrade") == ""
+	default:
+		return false
+	}
+}
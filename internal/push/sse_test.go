@@ -0,0 +1,248 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/wsc"
+)
+
+func Test_connectSSE(t *testing.T) {
+
+	posted := make(chan string, 1)
+	sessions := make(chan string, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method == http.MethodPost {
+			data, _ := ioutil.ReadAll(r.Body)
+			sessions <- r.Header.Get(sessionHeader)
+			posted <- string(data)
+			return
+		}
+
+		if r.Header.Get("Accept") != sseContentType {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", sseContentType)
+		w.Header().Set(sessionHeader, "abc")
+		w.Header().Set(replayHeader, "true")
+
+		fmt.Fprint(w, ": keepalive\n\n")
+		fmt.Fprint(w, "data: {\"type\":\n")
+		fmt.Fprint(w, "data: \"create\"}\n\n")
+		fmt.Fprint(w, "data: {\"type\": \"delete\"}\n\n")
+
+		if r.URL.Query().Get("hold") == "true" {
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}))
+	defer ts.Close()
+
+	cfg := wsc.Config{
+		ReadChanSize: 10,
+		Headers:      http.Header{},
+	}
+
+	conn, resp, err := connectSSE(context.Background(), strings.Replace(ts.URL, "http://", "ws://", 1), cfg, elemental.EncodingTypeJSON, nil)
+	if err != nil {
+		t.Fatalf("connectSSE() error = %s", err)
+	}
+	defer conn.Close(0)
+
+	if resp.Header.Get(replayHeader) != "true" {
+		t.Errorf("response should have the replay header")
+	}
+
+	for _, want := range []string{"{\"type\":\n\"create\"}", "{\"type\": \"delete\"}"} {
+		select {
+		case data := <-conn.Read():
+			if string(data) != want {
+				t.Errorf("Read() = %q, want %q", string(data), want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no data received")
+		}
+	}
+
+	select {
+	case err := <-conn.Done():
+		if err != nil {
+			t.Errorf("Done() = %s, want nil when the stream ends", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("connection should be done")
+	}
+
+	conn, _, err = connectSSE(context.Background(), ts.URL+"?hold=true", cfg, elemental.EncodingTypeJSON, nil)
+	if err != nil {
+		t.Fatalf("connectSSE() error = %s", err)
+	}
+	defer conn.Close(0)
+
+	conn.Write([]byte(`{"filters": {}}`))
+
+	select {
+	case data := <-posted:
+		if data != `{"filters": {}}` {
+			t.Errorf("posted data = %s", data)
+		}
+		if s := <-sessions; s != "abc" {
+			t.Errorf("posted session = %s, want abc", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("nothing posted")
+	}
+}
+
+func Test_connectSSETimeout(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", sseContentType)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	conn, _, err := connectSSE(context.Background(), ts.URL, wsc.Config{PongWait: 50 * time.Millisecond, Headers: http.Header{}}, elemental.EncodingTypeJSON, nil)
+	if err != nil {
+		t.Fatalf("connectSSE() error = %s", err)
+	}
+	defer conn.Close(0)
+
+	select {
+	case err := <-conn.Done():
+		if err == nil {
+			t.Errorf("Done() = nil, want an error when no data is received")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("connection should have timed out")
+	}
+}
+
+func Test_connectSSEFailure(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, "[]")
+	}))
+	defer ts.Close()
+
+	_, resp, err := connectSSE(context.Background(), ts.URL, wsc.Config{Headers: http.Header{}}, elemental.EncodingTypeJSON, nil)
+	if err == nil {
+		t.Fatalf("connectSSE() should fail when the server does not send events")
+	}
+	if resp == nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("connectSSE() should return the response")
+	}
+}
+
+type countingTransport struct {
+	http.RoundTripper
+	count int32
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&t.count, 1)
+	return t.RoundTripper.RoundTrip(req)
+}
+
+func Test_connectSSEWithClient(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", sseContentType)
+		fmt.Fprint(w, "data: {}\n\n")
+	}))
+	defer ts.Close()
+
+	transport := &countingTransport{RoundTripper: http.DefaultTransport}
+	client := &http.Client{Transport: transport, Timeout: time.Nanosecond}
+
+	conn, _, err := connectSSE(context.Background(), ts.URL, wsc.Config{ReadChanSize: 1, Headers: http.Header{}}, elemental.EncodingTypeJSON, client)
+	if err != nil {
+		t.Fatalf("connectSSE() error = %s", err)
+	}
+	defer conn.Close(0)
+
+	select {
+	case <-conn.Read():
+	case <-time.After(time.Second):
+		t.Fatalf("no data received")
+	}
+
+	if n := atomic.LoadInt32(&transport.count); n != 1 {
+		t.Errorf("the given client should have been used once, got %d", n)
+	}
+
+	if client.Timeout != time.Nanosecond {
+		t.Errorf("the given client should not be modified")
+	}
+}
+
+func Test_sseURL(t *testing.T) {
+
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"wss", "wss://a.com/events", "https://a.com/events"},
+		{"ws", "ws://a.com/events", "http://a.com/events"},
+		{"https", "https://a.com/events", "https://a.com/events"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sseURL(tt.url); got != tt.want {
+				t.Errorf("sseURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_upgradeRefused(t *testing.T) {
+
+	tests := []struct {
+		name string
+		resp *http.Response
+		want bool
+	}{
+		{"no response", nil, false},
+		{"ok", &http.Response{StatusCode: http.StatusOK}, true},
+		{"ok with upgrade", &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Upgrade": []string{"websocket"}}}, false},
+		{"bad request", &http.Response{StatusCode: http.StatusBadRequest}, false},
+		{"upgrade required", &http.Response{StatusCode: http.StatusUpgradeRequired}, true},
+		{"forbidden", &http.Response{StatusCode: http.StatusForbidden}, false},
+		{"unavailable", &http.Response{StatusCode: http.StatusServiceUnavailable}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := upgradeRefused(tt.resp); got != tt.want {
+				t.Errorf("upgradeRefused() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"sync"
+	"time"
+
+	"go.aporeto.io/manipulate"
+)
+
+// stats holds the statistics of a subscription.
+type stats struct {
+	connectedSince time.Time
+	reconnections  int
+	lastEventTime  time.Time
+	received       map[string]int
+	dropped        map[string]int
+	backoff        time.Duration
+	lock           sync.Mutex
+}
+
+func newStats() *stats {
+
+	return &stats{
+		received: map[string]int{},
+		dropped:  map[string]int{},
+	}
+}
+
+func (s *stats) connected(initial bool) {
+
+	s.lock.Lock()
+	s.connectedSince = time.Now()
+	s.backoff = 0
+	if !initial {
+		s.reconnections++
+	}
+	s.lock.Unlock()
+}
+
+func (s *stats) disconnected() {
+
+	s.lock.Lock()
+	s.connectedSince = time.Time{}
+	s.lock.Unlock()
+}
+
+func (s *stats) waiting(backoff time.Duration) {
+
+	s.lock.Lock()
+	s.backoff = backoff
+	s.lock.Unlock()
+}
+
+func (s *stats) eventReceived(identity string) {
+
+	s.lock.Lock()
+	s.lastEventTime = time.Now()
+	s.received[identity]++
+	s.lock.Unlock()
+}
+
+func (s *stats) eventDropped(identity string) {
+
+	s.lock.Lock()
+	s.dropped[identity]++
+	s.lock.Unlock()
+}
+
+// snapshot returns a copy of the statistics.
+func (s *stats) snapshot() manipulate.SubscriberStats {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	out := manipulate.SubscriberStats{
+		ConnectedSince: s.connectedSince,
+		Reconnections:  s.reconnections,
+		LastEventTime:  s.lastEventTime,
+		EventsReceived: make(map[string]int, len(s.received)),
+		EventsDropped:  make(map[string]int, len(s.dropped)),
+		Backoff:        s.backoff,
+	}
+
+	for k, v := range s.received {
+		out.EventsReceived[k] = v
+	}
+
+	for k, v := range s.dropped {
+		out.EventsDropped[k] = v
+	}
+
+	return out
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"testing"
+	"time"
+
+	"go.aporeto.io/elemental"
+)
+
+func Test_stats(t *testing.T) {
+
+	s := newStats()
+
+	s.waiting(time.Second)
+	if st := s.snapshot(); st.Backoff != time.Second || !st.ConnectedSince.IsZero() {
+		t.Errorf("snapshot() = %+v, want a backoff and no connection", st)
+	}
+
+	s.connected(true)
+	s.connected(false)
+	s.eventReceived("list")
+	s.eventReceived("list")
+	s.eventDropped("task")
+
+	st := s.snapshot()
+
+	if st.ConnectedSince.IsZero() || st.Backoff != 0 {
+		t.Errorf("snapshot() = %+v, want a connection and no backoff", st)
+	}
+
+	if st.Reconnections != 1 {
+		t.Errorf("Reconnections = %d, want 1", st.Reconnections)
+	}
+
+	if st.LastEventTime.IsZero() {
+		t.Errorf("LastEventTime should be set")
+	}
+
+	if st.EventsReceived["list"] != 2 || st.EventsDropped["task"] != 1 {
+		t.Errorf("EventsReceived = %v, EventsDropped = %v", st.EventsReceived, st.EventsDropped)
+	}
+
+	st.EventsReceived["list"] = 42
+	if s.snapshot().EventsReceived["list"] != 2 {
+		t.Errorf("snapshot() should return a copy")
+	}
+
+	s.disconnected()
+	if !s.snapshot().ConnectedSince.IsZero() {
+		t.Errorf("ConnectedSince should be zero once disconnected")
+	}
+}
+
+func Test_subscriptionStats(t *testing.T) {
+
+	filter := elemental.NewPushConfig()
+
+	s := &subscription{stats: newStats()}
+	s.setCurrentFilter(filter)
+
+	if st := s.Stats(); st.Filter != filter {
+		t.Errorf("Stats() should contain the current filter")
+	}
+}
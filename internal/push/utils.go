@@ -16,6 +16,7 @@ import (
 	"io"
 	"io/ioutil"
 	"math"
+	"net/http"
 	"net/url"
 	"strings"
 	"time"
@@ -45,7 +46,7 @@ func decodeErrors(r io.Reader, encoding elemental.EncodingType) error {
 	return errs
 }
 
-func makeURL(u string, namespace string, password string, recursive, supportErrorEvents bool) string {
+func makeURL(u string, namespace string, password string, recursive, supportErrorEvents bool, since string) string {
 
 	u = strings.Replace(u, "https://", "wss://", 1)
 
@@ -65,6 +66,10 @@ func makeURL(u string, namespace string, password string, recursive, supportErro
 		args = append(args, "enableErrors=true")
 	}
 
+	if since != "" {
+		args = append(args, fmt.Sprintf("%s=%s", sinceParameter, url.QueryEscape(since)))
+	}
+
 	return fmt.Sprintf("%s?%s", u, strings.Join(args, "&"))
 }
 
@@ -74,3 +79,40 @@ func nextBackoff(try int) time.Duration {
 
 	return time.Duration(math.Min(math.Pow(4.0, float64(try))-1, maxBackoff)) * time.Millisecond
 }
+
+// resumeFilter returns a copy of the given push config, or a new one
+// if it is nil, with the given since parameter set.
+func resumeFilter(filter *elemental.PushConfig, since string) (*elemental.PushConfig, error) {
+
+	out := elemental.NewPushConfig()
+
+	if filter != nil {
+
+		data, err := elemental.Encode(elemental.EncodingTypeJSON, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := elemental.Decode(elemental.EncodingTypeJSON, data, out); err != nil {
+			return nil, err
+		}
+	}
+
+	out.SetParameter(sinceParameter, since)
+
+	return out, nil
+}
+
+// serverTime returns the time at which the server sent the given
+// response, from its Date header, or a zero time if it is unknown.
+// As the header has a one second precision, the time is rounded
+// down, so resuming from it may replay a few events again.
+func serverTime(resp *http.Response) time.Time {
+
+	t, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
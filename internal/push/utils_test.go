@@ -15,11 +15,13 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"net/http"
 	"strings"
 	"testing"
 	"time"
 
 	"go.aporeto.io/elemental"
+	"go.aporeto.io/manipulate"
 )
 
 func Test_makeURL(t *testing.T) {
@@ -29,6 +31,7 @@ func Test_makeURL(t *testing.T) {
 		password      string
 		recursive     bool
 		supportErrors bool
+		since         string
 	}
 	tests := []struct {
 		name string
@@ -43,6 +46,7 @@ func Test_makeURL(t *testing.T) {
 				"password",
 				true,
 				false,
+				"",
 			},
 			"wss://toto?namespace=%2Fns&token=password&mode=all",
 		},
@@ -54,6 +58,7 @@ func Test_makeURL(t *testing.T) {
 				"password",
 				false,
 				false,
+				"",
 			},
 			"wss://toto?namespace=%2Fns&token=password",
 		},
@@ -65,6 +70,7 @@ func Test_makeURL(t *testing.T) {
 				"",
 				true,
 				false,
+				"",
 			},
 			"wss://toto?namespace=%2Fns&mode=all",
 		},
@@ -76,6 +82,7 @@ func Test_makeURL(t *testing.T) {
 				"",
 				false,
 				false,
+				"",
 			},
 			"wss://toto?namespace=%2Fns",
 		},
@@ -87,13 +94,26 @@ func Test_makeURL(t *testing.T) {
 				"",
 				false,
 				true,
+				"",
 			},
 			"wss://toto?namespace=%2Fns&enableErrors=true",
 		},
+		{
+			"with since",
+			args{
+				"https://toto",
+				"/ns",
+				"",
+				false,
+				false,
+				"2020-01-01T00:00:00.5Z",
+			},
+			"wss://toto?namespace=%2Fns&since=2020-01-01T00%3A00%3A00.5Z",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := makeURL(tt.args.u, tt.args.namespace, tt.args.password, tt.args.recursive, tt.args.supportErrors); got != tt.want {
+			if got := makeURL(tt.args.u, tt.args.namespace, tt.args.password, tt.args.recursive, tt.args.supportErrors, tt.args.since); got != tt.want {
 				t.Errorf("makeURL() = %v, want %v", got, tt.want)
 			}
 		})
@@ -240,3 +260,105 @@ func Test_decodeErrors(t *testing.T) {
 		})
 	}
 }
+
+func Test_resumeFilter(t *testing.T) {
+
+	filter := elemental.NewPushConfig()
+	filter.FilterIdentity("list")
+
+	out, err := resumeFilter(filter, "2020-01-01T00:00:00Z")
+	if err != nil {
+		t.Fatalf("resumeFilter() error = %v", err)
+	}
+
+	if out == filter {
+		t.Errorf("resumeFilter() returned the given filter")
+	}
+
+	data, _ := elemental.Encode(elemental.EncodingTypeJSON, out)
+	if !strings.Contains(string(data), `"list"`) || !strings.Contains(string(data), `"since"`) {
+		t.Errorf("resumeFilter() = %s, want the identity and since parameter", string(data))
+	}
+
+	data, _ = elemental.Encode(elemental.EncodingTypeJSON, filter)
+	if strings.Contains(string(data), `"since"`) {
+		t.Errorf("resumeFilter() modified the given filter: %s", string(data))
+	}
+
+	out, err = resumeFilter(nil, "2020-01-01T00:00:00Z")
+	if err != nil {
+		t.Fatalf("resumeFilter() error = %v", err)
+	}
+
+	data, _ = elemental.Encode(elemental.EncodingTypeJSON, out)
+	if !strings.Contains(string(data), `"since"`) {
+		t.Errorf("resumeFilter() = %s, want the since parameter", string(data))
+	}
+}
+
+func Test_setCurrentTokenWithHeader(t *testing.T) {
+
+	s := &subscription{
+		queueConfig: Config{AuthorizationScheme: "Bearer"},
+		filters:     make(chan *elemental.PushConfig, filterChSize),
+		tokens:      make(chan string, tokenChSize),
+		status:      make(chan manipulate.SubscriberStatus, statusChSize),
+	}
+
+	s.setCurrentToken("a")
+	s.setCurrentToken("b")
+
+	if s.getCurrentToken() != "b" {
+		t.Errorf("getCurrentToken() = %s, want b", s.getCurrentToken())
+	}
+
+	if token := <-s.tokens; token != "b" {
+		t.Errorf("token = %s, want only the latest token", token)
+	}
+
+	if len(s.filters) != 0 || s.getCurrentFilter() != nil {
+		t.Errorf("the token should not be sent in the filter")
+	}
+
+	if st := <-s.status; st != manipulate.SubscriberStatusTokenRenewal {
+		t.Errorf("status = %v, want SubscriberStatusTokenRenewal", st)
+	}
+
+	data, err := elemental.Encode(elemental.EncodingTypeJSON, controlMessage{Control: controlTokenRenewal, Token: "b"})
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	if !strings.Contains(string(data), `"control":"renewToken"`) || !strings.Contains(string(data), `"token":"b"`) {
+		t.Errorf("control message = %s", string(data))
+	}
+}
+
+func Test_serverTime(t *testing.T) {
+
+	date := time.Date(2019, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		header string
+		want   time.Time
+	}{
+		{"date", date.Format(http.TimeFormat), date},
+		{"no date", "", time.Time{}},
+		{"invalid date", "yesterday", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			resp := &http.Response{Header: http.Header{}}
+			if tt.header != "" {
+				resp.Header.Set("Date", tt.header)
+			}
+
+			if got := serverTime(resp); !got.Equal(tt.want) {
+				t.Errorf("serverTime() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
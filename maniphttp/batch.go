@@ -0,0 +1,272 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maniphttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/opentracing/opentracing-go/log"
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/manipulate"
+	"go.aporeto.io/manipulate/internal/idempotency"
+	"go.aporeto.io/manipulate/internal/tracing"
+)
+
+const defaultBulkEndpoint = "_bulk"
+
+// bulkUnsupportedTTL is the time during which Batch runs the operations
+// sequentially after the API answered that it does not support bulk operations.
+const bulkUnsupportedTTL = 5 * time.Minute
+
+// opaqueKeyResponseStatus is the key of the opaque value of a manipulate.Context
+// holding a *int32 set to the status code of the responses to its requests.
+const opaqueKeyResponseStatus = "maniphttp.response.status"
+
+// opaquer is implemented by the manipulate.Context
+// that can hold opaque values.
+type opaquer interface {
+	Opaque() map[string]interface{}
+}
+
+// A BatchOperation represents a single operation to run in a batch.
+// Operation must be elemental.OperationCreate, elemental.OperationUpdate
+// or elemental.OperationDelete.
+type BatchOperation struct {
+	Operation elemental.Operation
+	Object    elemental.Identifiable
+}
+
+type bulkRequestItem struct {
+	Operation      elemental.Operation    `json:"operation" msgpack:"operation"`
+	Identity       string                 `json:"identity" msgpack:"identity"`
+	ID             string                 `json:"ID,omitempty" msgpack:"ID,omitempty"`
+	ParentIdentity string                 `json:"parentIdentity,omitempty" msgpack:"parentIdentity,omitempty"`
+	ParentID       string                 `json:"parentID,omitempty" msgpack:"parentID,omitempty"`
+	Object         elemental.Identifiable `json:"object,omitempty" msgpack:"object,omitempty"`
+}
+
+type bulkResponseItem struct {
+	Object interface{}      `json:"object,omitempty" msgpack:"object,omitempty"`
+	Errors elemental.Errors `json:"errors,omitempty" msgpack:"errors,omitempty"`
+}
+
+// Batch runs the given operations using the given manipulator.
+//
+// The operations are packed into a single request sent to the bulk endpoint
+// of the API (see OptionBulkEndpoint), encoded using the encoding of the
+// manipulator. The API must return one result per operation, in the same order.
+// Objects are updated with the result of their operation.
+//
+// If the API does not support bulk operations, meaning it answers with
+// a 405 or 501 status, the operations are run sequentially using Create,
+// Update and Delete. The manipulator remembers it for a few minutes, after
+// which the bulk endpoint is tried again. A 404 is returned as an error, as
+// it cannot be told apart from an operation on an object that does not exist.
+// If the API does not have the bulk endpoint, use OptionBulkEndpoint to disable it.
+//
+// Batch returns one error per operation, which is nil if the operation
+// succeeded, and an error if the batch itself could not be run.
+// Note: the given manipulator must be an HTTP Manipulator or it will panic.
+func Batch(manipulator manipulate.Manipulator, mctx manipulate.Context, operations []BatchOperation) ([]error, error) {
+
+	m, ok := manipulator.(*httpManipulator)
+	if !ok {
+		panic("You can only pass a HTTP Manipulator to Batch")
+	}
+
+	if mctx == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultGlobalContextTimeout)
+		defer cancel()
+		mctx = manipulate.NewContext(ctx)
+	}
+
+	for i, op := range operations {
+
+		if op.Object == nil {
+			return nil, manipulate.NewErrCannotBuildQuery(fmt.Sprintf("nil object in operation %d", i))
+		}
+
+		switch op.Operation {
+		case elemental.OperationCreate:
+		case elemental.OperationUpdate, elemental.OperationDelete:
+			if op.Object.Identifier() == "" {
+				return nil, manipulate.NewErrCannotBuildQuery(fmt.Sprintf("object with no ID in %s operation %d", op.Operation, i))
+			}
+		default:
+			return nil, manipulate.NewErrCannotBuildQuery(fmt.Sprintf("unsupported operation '%s' in operation %d", op.Operation, i))
+		}
+	}
+
+	if len(operations) == 0 {
+		return nil, nil
+	}
+
+	if m.bulkEndpoint == "" || time.Now().UnixNano() < atomic.LoadInt64(&m.bulkUnsupportedUntil) {
+		return m.sequentialBatch(mctx, operations), nil
+	}
+
+	errs, status, err := m.bulkBatch(mctx, operations)
+	if err != nil && isBulkUnsupportedError(err, status) {
+		atomic.StoreInt64(&m.bulkUnsupportedUntil, time.Now().Add(bulkUnsupportedTTL).UnixNano())
+		return m.sequentialBatch(mctx, operations), nil
+	}
+
+	return errs, err
+}
+
+// bulkBatch runs the given operations using the bulk endpoint. It also
+// returns the status code of the last response, which is 0 if none
+// has been received.
+func (s *httpManipulator) bulkBatch(mctx manipulate.Context, operations []BatchOperation) ([]error, int, error) {
+
+	sp := tracing.StartTrace(mctx, "maniphttp.batch")
+	sp.LogFields(log.Int("operations", len(operations)))
+	defer sp.Finish()
+
+	var parentIdentity, parentID string
+	if p := mctx.Parent(); p != nil {
+		parentIdentity, parentID = p.Identity().Name, p.Identifier()
+	}
+
+	items := make([]bulkRequestItem, len(operations))
+	for i, op := range operations {
+
+		items[i] = bulkRequestItem{
+			Operation: op.Operation,
+			Identity:  op.Object.Identity().Name,
+			ID:        op.Object.Identifier(),
+		}
+
+		switch op.Operation {
+		case elemental.OperationCreate:
+			items[i].ParentIdentity = parentIdentity
+			items[i].ParentID = parentID
+			items[i].Object = op.Object
+		case elemental.OperationUpdate:
+			items[i].Object = op.Object
+		}
+	}
+
+	data, err := elemental.Encode(s.encoding, items)
+	if err != nil {
+		sp.SetTag("error", true)
+		sp.LogFields(log.Error(err))
+		return nil, 0, manipulate.NewErrCannotMarshal(err.Error())
+	}
+
+	// The batch is sent as a whole, so we make it idempotent
+	// using a key dedicated to this batch.
+	bmctx := mctx.Derive()
+	if k, ok := bmctx.(idempotency.Keyer); ok {
+		k.SetIdempotencyKey(uuid.Must(uuid.NewV4()).String())
+	}
+
+	// Proxies and APIs not supporting bulk operations may answer with
+	// errors that cannot be decoded, so we keep the raw status code.
+	var status int32
+	if o, ok := bmctx.(opaquer); ok {
+		o.Opaque()[opaqueKeyResponseStatus] = &status
+	}
+
+	url := s.url + "/" + s.computeVersion(0, mctx.Version()) + strings.TrimLeft(s.bulkEndpoint, "/")
+
+	var results []bulkResponseItem
+	if _, err = s.send(bmctx, elemental.Identity{}, http.MethodPost, url, data, &results, sp); err != nil {
+		sp.SetTag("error", true)
+		sp.LogFields(log.Error(err))
+		return nil, int(atomic.LoadInt32(&status)), err
+	}
+
+	if len(results) != len(operations) {
+		return nil, int(status), manipulate.NewErrCannotUnmarshal(fmt.Sprintf("invalid number of results in batch response: expected %d, got %d", len(operations), len(results)))
+	}
+
+	errs := make([]error, len(operations))
+	for i, r := range results {
+
+		if len(r.Errors) > 0 {
+			errs[i] = r.Errors
+			continue
+		}
+
+		if r.Object == nil {
+			continue
+		}
+
+		// The object has been decoded generically, so we
+		// reencode it to decode it into the actual object.
+		odata, err := elemental.Encode(s.encoding, r.Object)
+		if err != nil {
+			errs[i] = manipulate.NewErrCannotUnmarshal(err.Error())
+			continue
+		}
+
+		if err := elemental.Decode(s.encoding, odata, operations[i].Object); err != nil {
+			errs[i] = manipulate.NewErrCannotUnmarshal(err.Error())
+			continue
+		}
+
+		// backport all default values that are empty.
+		if a, ok := operations[i].Object.(elemental.AttributeSpecifiable); ok {
+			elemental.ResetDefaultForZeroValues(a)
+		}
+	}
+
+	return errs, int(status), nil
+}
+
+func (s *httpManipulator) sequentialBatch(mctx manipulate.Context, operations []BatchOperation) []error {
+
+	errs := make([]error, len(operations))
+
+	for i, op := range operations {
+
+		// We derive the context for each operation so
+		// they all get their own idempotency key.
+		omctx := mctx.Derive()
+
+		switch op.Operation {
+		case elemental.OperationCreate:
+			errs[i] = s.Create(omctx, op.Object)
+		case elemental.OperationUpdate:
+			errs[i] = s.Update(omctx, op.Object)
+		case elemental.OperationDelete:
+			errs[i] = s.Delete(omctx, op.Object)
+		}
+	}
+
+	return errs
+}
+
+// isBulkUnsupportedError returns true if the given error, or the given
+// status code of the response, means that the API does not support
+// bulk operations.
+func isBulkUnsupportedError(err error, status int) bool {
+
+	code := status
+	if errs, ok := err.(elemental.Errors); ok && code == 0 {
+		code = errs.Code()
+	}
+
+	switch code {
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,327 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maniphttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	testmodel "go.aporeto.io/elemental/test/model"
+	"go.aporeto.io/manipulate"
+	"go.aporeto.io/manipulate/maniptest"
+)
+
+func TestBatch(t *testing.T) {
+
+	Convey("Calling Batch with a non http manipulator should panic", t, func() {
+		So(
+			func() { _, _ = Batch(maniptest.NewTestManipulator(), nil, nil) },
+			ShouldPanicWith,
+			"You can only pass a HTTP Manipulator to Batch",
+		)
+	})
+
+	Convey("Given I have a manipulator", t, func() {
+
+		mm, _ := New(context.Background(), "https://fake.com")
+
+		Convey("When I call Batch with a nil object", func() {
+
+			_, err := Batch(mm, nil, []BatchOperation{{Operation: elemental.OperationCreate}})
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Unable to build query: nil object in operation 0")
+			})
+		})
+
+		Convey("When I call Batch with an update on an object with no ID", func() {
+
+			_, err := Batch(mm, nil, []BatchOperation{{Operation: elemental.OperationUpdate, Object: testmodel.NewList()}})
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Unable to build query: object with no ID in update operation 0")
+			})
+		})
+
+		Convey("When I call Batch with an unsupported operation", func() {
+
+			_, err := Batch(mm, nil, []BatchOperation{{Operation: elemental.OperationRetrieve, Object: testmodel.NewList()}})
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Unable to build query: unsupported operation 'retrieve' in operation 0")
+			})
+		})
+
+		Convey("When I call Batch with no operation", func() {
+
+			errs, err := Batch(mm, nil, nil)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+				So(errs, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given I have a manipulator and a server supporting bulk operations", t, func() {
+
+		var items []map[string]interface{}
+		var idempotencyKey string
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			if r.URL.Path != "/_bulk" || r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusTeapot)
+				return
+			}
+
+			idempotencyKey = r.Header.Get("Idempotency-Key")
+
+			data, _ := ioutil.ReadAll(r.Body)
+			_ = json.Unmarshal(data, &items)
+
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `[
+				{"object": {"ID": "a", "name": "created"}},
+				{"errors": [{"code": 422, "title": "nope", "description": "boom"}]},
+				{}
+			]`)
+		}))
+		defer ts.Close()
+
+		mm, _ := New(context.Background(), ts.URL)
+
+		Convey("When I call Batch", func() {
+
+			l1 := testmodel.NewList()
+			l2 := testmodel.NewList()
+			l2.ID = "b"
+			l3 := testmodel.NewList()
+			l3.ID = "c"
+
+			errs, err := Batch(mm, nil, []BatchOperation{
+				{Operation: elemental.OperationCreate, Object: l1},
+				{Operation: elemental.OperationUpdate, Object: l2},
+				{Operation: elemental.OperationDelete, Object: l3},
+			})
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the request should be correct", func() {
+				So(idempotencyKey, ShouldNotBeEmpty)
+				So(len(items), ShouldEqual, 3)
+				So(items[0]["operation"], ShouldEqual, "create")
+				So(items[0]["identity"], ShouldEqual, "list")
+				So(items[0]["object"], ShouldNotBeNil)
+				So(items[1]["operation"], ShouldEqual, "update")
+				So(items[1]["ID"], ShouldEqual, "b")
+				So(items[2]["operation"], ShouldEqual, "delete")
+				So(items[2]["ID"], ShouldEqual, "c")
+				So(items[2]["object"], ShouldBeNil)
+			})
+
+			Convey("Then the results should be correct", func() {
+				So(len(errs), ShouldEqual, 3)
+				So(errs[0], ShouldBeNil)
+				So(l1.ID, ShouldEqual, "a")
+				So(l1.Name, ShouldEqual, "created")
+				So(errs[1], ShouldNotBeNil)
+				So(errs[1].(elemental.Errors).Code(), ShouldEqual, 422)
+				So(errs[2], ShouldBeNil)
+			})
+		})
+
+		Convey("When I call Batch and the server returns the wrong number of results", func() {
+
+			_, err := Batch(mm, nil, []BatchOperation{
+				{Operation: elemental.OperationCreate, Object: testmodel.NewList()},
+			})
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Unable to unmarshal data: invalid number of results in batch response: expected 1, got 3")
+			})
+		})
+	})
+
+	Convey("Given I have a manipulator and a server not supporting bulk operations", t, func() {
+
+		var lock sync.Mutex
+		var calls []string
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			lock.Lock()
+			calls = append(calls, r.Method+" "+r.URL.Path)
+			lock.Unlock()
+
+			w.Header().Set("Content-Type", "application/json")
+
+			if r.URL.Path == "/_bulk" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				fmt.Fprint(w, `[{"code": 405, "title": "Method Not Allowed", "description": "nope"}]`)
+				return
+			}
+
+			fmt.Fprint(w, `{"ID": "a"}`)
+		}))
+		defer ts.Close()
+
+		mm, _ := New(context.Background(), ts.URL)
+
+		Convey("When I call Batch twice", func() {
+
+			l2 := testmodel.NewList()
+			l2.ID = "b"
+
+			ops := []BatchOperation{
+				{Operation: elemental.OperationCreate, Object: testmodel.NewList()},
+				{Operation: elemental.OperationDelete, Object: l2},
+			}
+
+			errs1, err1 := Batch(mm, manipulate.NewContext(context.Background()), ops)
+			errs2, err2 := Batch(mm, manipulate.NewContext(context.Background()), ops)
+
+			Convey("Then err should be nil", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+				So(errs1, ShouldResemble, []error{nil, nil})
+				So(errs2, ShouldResemble, []error{nil, nil})
+			})
+
+			Convey("Then the operations should have been run sequentially after the first bulk attempt", func() {
+				So(calls, ShouldResemble, []string{
+					"POST /_bulk",
+					"POST /lists",
+					"DELETE /lists/b",
+					"POST /lists",
+					"DELETE /lists/a",
+				})
+			})
+		})
+
+		Convey("When I call Batch again once the bulk endpoint is known to be unsupported for too long", func() {
+
+			ops := []BatchOperation{
+				{Operation: elemental.OperationCreate, Object: testmodel.NewList()},
+			}
+
+			_, err1 := Batch(mm, manipulate.NewContext(context.Background()), ops)
+			atomic.StoreInt64(&mm.(*httpManipulator).bulkUnsupportedUntil, time.Now().Add(-time.Second).UnixNano())
+			_, err2 := Batch(mm, manipulate.NewContext(context.Background()), ops)
+
+			Convey("Then the bulk endpoint should have been tried again", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+				So(calls, ShouldResemble, []string{
+					"POST /_bulk",
+					"POST /lists",
+					"POST /_bulk",
+					"POST /lists",
+				})
+			})
+		})
+	})
+
+	Convey("Given I have a manipulator and a proxy answering 501 for bulk operations", t, func() {
+
+		var lock sync.Mutex
+		var calls []string
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			lock.Lock()
+			calls = append(calls, r.Method+" "+r.URL.Path)
+			lock.Unlock()
+
+			if r.URL.Path == "/_bulk" {
+				w.Header().Set("Content-Type", "text/html")
+				w.WriteHeader(http.StatusNotImplemented)
+				fmt.Fprint(w, `<html><body>Not Implemented</body></html>`)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"ID": "a"}`)
+		}))
+		defer ts.Close()
+
+		mm, _ := New(context.Background(), ts.URL)
+
+		Convey("When I call Batch", func() {
+
+			errs, err := Batch(mm, manipulate.NewContext(context.Background()), []BatchOperation{
+				{Operation: elemental.OperationCreate, Object: testmodel.NewList()},
+			})
+
+			Convey("Then the operations should have been run sequentially", func() {
+				So(err, ShouldBeNil)
+				So(errs, ShouldResemble, []error{nil})
+				So(calls, ShouldResemble, []string{
+					"POST /_bulk",
+					"POST /lists",
+				})
+			})
+		})
+	})
+
+	Convey("Given I have a manipulator and a server answering 404 for bulk operations", t, func() {
+
+		var lock sync.Mutex
+		var calls []string
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			lock.Lock()
+			calls = append(calls, r.Method+" "+r.URL.Path)
+			lock.Unlock()
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `[{"code": 404, "title": "Not Found", "description": "nope"}]`)
+		}))
+		defer ts.Close()
+
+		mm, _ := New(context.Background(), ts.URL)
+
+		Convey("When I call Batch", func() {
+
+			l := testmodel.NewList()
+			l.ID = "b"
+
+			_, err := Batch(mm, manipulate.NewContext(context.Background()), []BatchOperation{
+				{Operation: elemental.OperationDelete, Object: l},
+			})
+
+			Convey("Then the error should be returned without running the operations sequentially", func() {
+				So(err, ShouldNotBeNil)
+				So(calls, ShouldResemble, []string{"POST /_bulk"})
+				So(atomic.LoadInt64(&mm.(*httpManipulator).bulkUnsupportedUntil), ShouldEqual, 0)
+			})
+		})
+	})
+}
@@ -0,0 +1,141 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maniphttp
+
+import (
+	"sync"
+	"time"
+)
+
+// A CircuitBreakerState represents the state of a circuit breaker.
+type CircuitBreakerState int
+
+// Various values of CircuitBreakerState.
+const (
+	// CircuitBreakerClosed means requests are sent.
+	CircuitBreakerClosed CircuitBreakerState = iota
+
+	// CircuitBreakerOpen means requests fail immediately.
+	CircuitBreakerOpen
+
+	// CircuitBreakerHalfOpen means a single request is
+	// sent to probe if the API is back.
+	CircuitBreakerHalfOpen
+)
+
+func (s CircuitBreakerState) String() string {
+
+	switch s {
+	case CircuitBreakerClosed:
+		return "closed"
+	case CircuitBreakerOpen:
+		return "open"
+	case CircuitBreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// circuitBreaker opens after a number of consecutive communication
+// failures. Once the cooldown has elapsed, it half-opens to let a
+// single request probe the API. It closes if the probe succeeds, and
+// opens again if it fails. If the outcome of the probe is never
+// reported, another probe is allowed after another cooldown.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	state     CircuitBreakerState
+	failures  int
+	openedAt  time.Time
+	probedAt  time.Time
+	now       func() time.Time
+	lock      sync.Mutex
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// allow returns true if a request can be sent.
+func (b *circuitBreaker) allow() bool {
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := b.now()
+
+	switch b.state {
+
+	case CircuitBreakerOpen:
+		if now.Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = CircuitBreakerHalfOpen
+		b.probedAt = now
+		return true
+
+	case CircuitBreakerHalfOpen:
+		if now.Sub(b.probedAt) < b.cooldown {
+			return false
+		}
+		b.probedAt = now
+		return true
+
+	default:
+		return true
+	}
+}
+
+// success reports that the API could be reached.
+func (b *circuitBreaker) success() {
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.failures = 0
+	b.state = CircuitBreakerClosed
+}
+
+// failure reports that the API could not be reached.
+func (b *circuitBreaker) failure() {
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.state {
+
+	case CircuitBreakerHalfOpen:
+		b.state = CircuitBreakerOpen
+		b.openedAt = b.now()
+
+	case CircuitBreakerClosed:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.state = CircuitBreakerOpen
+			b.openedAt = b.now()
+		}
+	}
+}
+
+func (b *circuitBreaker) currentState() CircuitBreakerState {
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.state
+}
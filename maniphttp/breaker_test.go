@@ -0,0 +1,153 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maniphttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	testmodel "go.aporeto.io/elemental/test/model"
+	"go.aporeto.io/manipulate"
+)
+
+func TestCircuitBreakerState_String(t *testing.T) {
+
+	Convey("Given I have some states", t, func() {
+		So(CircuitBreakerClosed.String(), ShouldEqual, "closed")
+		So(CircuitBreakerOpen.String(), ShouldEqual, "open")
+		So(CircuitBreakerHalfOpen.String(), ShouldEqual, "half-open")
+		So(CircuitBreakerState(42).String(), ShouldEqual, "unknown")
+	})
+}
+
+func TestCircuitBreaker(t *testing.T) {
+
+	Convey("Given I have a circuit breaker with a fake clock", t, func() {
+
+		now := time.Unix(1000, 0)
+		b := newCircuitBreaker(2, time.Second)
+		b.now = func() time.Time { return now }
+
+		Convey("Then it should be closed", func() {
+			So(b.currentState(), ShouldEqual, CircuitBreakerClosed)
+			So(b.allow(), ShouldBeTrue)
+		})
+
+		Convey("When I report failures under the threshold", func() {
+
+			b.failure()
+
+			Convey("Then it should be closed", func() {
+				So(b.currentState(), ShouldEqual, CircuitBreakerClosed)
+			})
+
+			Convey("When I report a success then a failure", func() {
+
+				b.success()
+				b.failure()
+
+				Convey("Then it should still be closed", func() {
+					So(b.currentState(), ShouldEqual, CircuitBreakerClosed)
+				})
+			})
+		})
+
+		Convey("When I report failures up to the threshold", func() {
+
+			b.failure()
+			b.failure()
+
+			Convey("Then it should be open", func() {
+				So(b.currentState(), ShouldEqual, CircuitBreakerOpen)
+				So(b.allow(), ShouldBeFalse)
+			})
+
+			Convey("When the cooldown has elapsed", func() {
+
+				now = now.Add(time.Second)
+
+				Convey("Then it should allow a single probe", func() {
+					So(b.allow(), ShouldBeTrue)
+					So(b.currentState(), ShouldEqual, CircuitBreakerHalfOpen)
+					So(b.allow(), ShouldBeFalse)
+				})
+
+				Convey("When the probe succeeds", func() {
+
+					b.allow()
+					b.success()
+
+					Convey("Then it should be closed", func() {
+						So(b.currentState(), ShouldEqual, CircuitBreakerClosed)
+						So(b.allow(), ShouldBeTrue)
+					})
+				})
+
+				Convey("When the probe fails", func() {
+
+					b.allow()
+					b.failure()
+
+					Convey("Then it should be open", func() {
+						So(b.currentState(), ShouldEqual, CircuitBreakerOpen)
+						So(b.allow(), ShouldBeFalse)
+					})
+				})
+
+				Convey("When the probe never reports", func() {
+
+					b.allow()
+					now = now.Add(time.Second)
+
+					Convey("Then it should allow another probe", func() {
+						So(b.allow(), ShouldBeTrue)
+					})
+				})
+			})
+		})
+	})
+
+	Convey("Given I have a manipulator with a circuit breaker and a failing server", t, func() {
+
+		var calls int32
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer ts.Close()
+
+		m, _ := New(context.Background(), ts.URL, OptionCircuitBreaker(2, time.Minute))
+
+		Convey("When I retrieve an object", func() {
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			list := testmodel.NewList()
+			list.ID = "x"
+			err := m.Retrieve(manipulate.NewContext(ctx), list)
+
+			Convey("Then it should fail fast once the circuit breaker opens", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Cannot communicate: circuit breaker is open")
+				So(atomic.LoadInt32(&calls), ShouldEqual, 2)
+				So(ExtractCircuitBreakerState(m), ShouldEqual, CircuitBreakerOpen)
+			})
+		})
+	})
+}
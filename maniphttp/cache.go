@@ -0,0 +1,220 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maniphttp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// cacheKeyHeaders are the request headers that
+// change the content of a response.
+var cacheKeyHeaders = []string{
+	"Accept",
+	"Authorization",
+	"Cookie",
+	"X-Namespace",
+	"X-Fields",
+	"X-Read-Consistency",
+}
+
+// cachedHeaders are the response headers that
+// are restored when serving a cached response.
+var cachedHeaders = []string{
+	"Content-Type",
+	"X-Count-Total",
+	"X-Next",
+	"X-Messages",
+}
+
+type cachedResponse struct {
+	etag         string
+	lastModified string
+	header       http.Header
+	body         []byte
+}
+
+// defaultResponseCacheMaxBytes is the default maximum
+// size of the bodies held by the response cache.
+const defaultResponseCacheMaxBytes = 32 << 20
+
+// responseCache is an LRU cache of the responses of GET requests
+// that can be revalidated. It holds at most the given number of
+// responses, and at most maxBytes of response bodies.
+type responseCache struct {
+	lru      *lru.Cache
+	maxBytes int64
+	bytes    int64
+	lock     sync.Mutex
+}
+
+func newResponseCache(size int, maxBytes int64) *responseCache {
+
+	c := &responseCache{
+		maxBytes: maxBytes,
+	}
+
+	l, err := lru.NewWithEvict(size, func(_ interface{}, v interface{}) {
+		atomic.AddInt64(&c.bytes, -int64(len(v.(*cachedResponse).body)))
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	c.lru = l
+
+	return c
+}
+
+// key returns the cache key of the given request. It is
+// a hash so credentials are not kept in memory.
+func (c *responseCache) key(req *http.Request) string {
+
+	h := sha256.New()
+	_, _ = h.Write([]byte(req.URL.String())) // nolint: errcheck
+
+	for _, k := range cacheKeyHeaders {
+		for _, v := range req.Header[k] {
+			_, _ = h.Write([]byte{0})           // nolint: errcheck
+			_, _ = h.Write([]byte(k + ":" + v)) // nolint: errcheck
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// prepare adds the conditional headers to the given request if a
+// response is cached for it, and returns that response, or nil. The
+// returned response must be given to load, as it may be evicted from
+// the cache before the API answers.
+func (c *responseCache) prepare(req *http.Request, key string) *cachedResponse {
+
+	entry, ok := c.get(key)
+	if !ok {
+		return nil
+	}
+
+	if entry.etag != "" {
+		req.Header.Set("If-None-Match", entry.etag)
+	}
+
+	if entry.lastModified != "" {
+		req.Header.Set("If-Modified-Since", entry.lastModified)
+	}
+
+	return entry
+}
+
+// store reads the body of the given response and caches it if the response
+// can be revalidated. The body of the response is replaced so it can be
+// read again. Bodies larger than the maximum size of the cache are not
+// read entirely, and are not cached.
+func (c *responseCache) store(key string, response *http.Response) error {
+
+	etag := response.Header.Get("ETag")
+	lastModified := response.Header.Get("Last-Modified")
+
+	if etag == "" && lastModified == "" {
+		return nil
+	}
+
+	if response.ContentLength > c.maxBytes {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, c.maxBytes+1))
+	if err != nil {
+		return err
+	}
+
+	if int64(len(body)) > c.maxBytes {
+		response.Body = &readCloser{
+			Reader: io.MultiReader(bytes.NewReader(body), response.Body),
+			Closer: response.Body,
+		}
+		return nil
+	}
+
+	response.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	header := http.Header{}
+	for _, k := range cachedHeaders {
+		if v, ok := response.Header[k]; ok {
+			header[k] = v
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// We remove the previous response first, so
+	// it is not accounted for when evicting.
+	c.lru.Remove(key)
+
+	atomic.AddInt64(&c.bytes, int64(len(body)))
+	c.lru.Add(key, &cachedResponse{
+		etag:         etag,
+		lastModified: lastModified,
+		header:       header,
+		body:         body,
+	})
+
+	for atomic.LoadInt64(&c.bytes) > c.maxBytes {
+		c.lru.RemoveOldest()
+	}
+
+	return nil
+}
+
+// readCloser reads from the Reader and closes the Closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// load turns the given 304 response into the given cached
+// response returned by prepare. It returns false if it is nil.
+func (c *responseCache) load(entry *cachedResponse, response *http.Response) bool {
+
+	if entry == nil {
+		return false
+	}
+
+	for k, v := range entry.header {
+		response.Header[k] = v
+	}
+
+	response.StatusCode = http.StatusOK
+	response.Status = "200 OK"
+	response.ContentLength = int64(len(entry.body))
+	response.Body = ioutil.NopCloser(bytes.NewReader(entry.body))
+
+	return true
+}
+
+func (c *responseCache) get(key string) (*cachedResponse, bool) {
+
+	v, ok := c.lru.Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	return v.(*cachedResponse), true
+}
@@ -0,0 +1,238 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maniphttp
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	testmodel "go.aporeto.io/elemental/test/model"
+	"go.aporeto.io/manipulate"
+)
+
+func TestResponseCache(t *testing.T) {
+
+	Convey("Given I have a manipulator with a cache and a server using etags", t, func() {
+
+		var full, notModified int
+		var lastIfNoneMatch string
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			lastIfNoneMatch = r.Header.Get("If-None-Match")
+
+			if lastIfNoneMatch == `"v1"` {
+				notModified++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+
+			full++
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("X-Count-Total", "1")
+			fmt.Fprint(w, `[{"ID": "xxx", "name": "hello"}]`)
+		}))
+		defer ts.Close()
+
+		mm, _ := New(context.Background(), ts.URL, OptionResponseCache(10))
+
+		Convey("When I retrieve the same objects twice", func() {
+
+			l1 := testmodel.ListsList{}
+			err1 := mm.RetrieveMany(nil, &l1)
+
+			mctx := manipulate.NewContext(context.Background())
+			l2 := testmodel.ListsList{}
+			err2 := mm.RetrieveMany(mctx, &l2)
+
+			Convey("Then err should be nil", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+			})
+
+			Convey("Then the second response should have been served from cache", func() {
+				So(full, ShouldEqual, 1)
+				So(notModified, ShouldEqual, 1)
+				So(len(l2), ShouldEqual, 1)
+				So(l2[0].ID, ShouldEqual, "xxx")
+				So(l2[0].Name, ShouldEqual, "hello")
+				So(mctx.Count(), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When I retrieve the same objects in another namespace", func() {
+
+			l1 := testmodel.ListsList{}
+			err1 := mm.RetrieveMany(manipulate.NewContext(context.Background(), manipulate.ContextOptionNamespace("/a")), &l1)
+
+			l2 := testmodel.ListsList{}
+			err2 := mm.RetrieveMany(manipulate.NewContext(context.Background(), manipulate.ContextOptionNamespace("/b")), &l2)
+
+			Convey("Then err should be nil", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+			})
+
+			Convey("Then the second request should not have been conditional", func() {
+				So(full, ShouldEqual, 2)
+				So(notModified, ShouldEqual, 0)
+				So(lastIfNoneMatch, ShouldEqual, "")
+			})
+		})
+	})
+
+	Convey("Given I have a cache of size 1", t, func() {
+
+		c := newResponseCache(1, defaultResponseCacheMaxBytes)
+
+		r1 := httptest.NewRequest(http.MethodGet, "https://fake.com/lists", nil)
+		r2 := httptest.NewRequest(http.MethodGet, "https://fake.com/tasks", nil)
+		k1, k2 := c.key(r1), c.key(r2)
+
+		store := func(key string) {
+			rec := httptest.NewRecorder()
+			rec.Header().Set("ETag", `"v1"`)
+			fmt.Fprint(rec, "[]")
+			So(c.store(key, rec.Result()), ShouldBeNil)
+		}
+
+		Convey("When I store two responses", func() {
+
+			store(k1)
+			store(k2)
+
+			Convey("Then the first one should have been evicted", func() {
+				_, ok1 := c.get(k1)
+				_, ok2 := c.get(k2)
+				So(ok1, ShouldBeFalse)
+				So(ok2, ShouldBeTrue)
+			})
+
+			Convey("Then the conditional headers should be set for the second one", func() {
+				c.prepare(r2, k2)
+				So(r2.Header.Get("If-None-Match"), ShouldEqual, `"v1"`)
+			})
+		})
+	})
+
+	Convey("Calling New with OptionResponseCacheMaxBytes should set the max size of the cache", t, func() {
+		mm, _ := New(context.Background(), "https://toto.com", OptionResponseCacheMaxBytes(10), OptionResponseCache(10))
+		So(mm.(*httpManipulator).cache.maxBytes, ShouldEqual, 10)
+	})
+
+	Convey("Calling New with OptionResponseCacheMaxBytes without OptionResponseCache should panic", t, func() {
+		So(func() { _, _ = New(context.Background(), "https://toto.com", OptionResponseCacheMaxBytes(10)) }, ShouldPanicWith, "OptionResponseCacheMaxBytes requires OptionResponseCache")
+	})
+
+	Convey("Given I have a manipulator with a cache of size 1 and a server using etags", t, func() {
+
+		var mm manipulate.Manipulator
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				// Another request evicts the response before we answer.
+				mm.(*httpManipulator).cache.lru.Purge()
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("ETag", `"v1"`)
+			fmt.Fprint(w, `[{"ID": "xxx", "name": "hello"}]`)
+		}))
+		defer ts.Close()
+
+		mm, _ = New(context.Background(), ts.URL, OptionResponseCache(1))
+
+		Convey("When the cached response is evicted before the API answers 304", func() {
+
+			So(mm.RetrieveMany(nil, &testmodel.ListsList{}), ShouldBeNil)
+
+			l := testmodel.ListsList{}
+			err := mm.RetrieveMany(nil, &l)
+
+			Convey("Then the response should be served from the pinned entry", func() {
+				So(err, ShouldBeNil)
+				So(len(l), ShouldEqual, 1)
+				So(l[0].Name, ShouldEqual, "hello")
+			})
+		})
+	})
+
+	Convey("Given I have a cache of 10 bytes", t, func() {
+
+		c := newResponseCache(10, 10)
+
+		r1 := httptest.NewRequest(http.MethodGet, "https://fake.com/lists", nil)
+		r2 := httptest.NewRequest(http.MethodGet, "https://fake.com/tasks", nil)
+		k1, k2 := c.key(r1), c.key(r2)
+
+		store := func(key string, body string) *http.Response {
+			rec := httptest.NewRecorder()
+			rec.Header().Set("ETag", `"v1"`)
+			fmt.Fprint(rec, body)
+			resp := rec.Result()
+			resp.ContentLength = -1
+			So(c.store(key, resp), ShouldBeNil)
+			return resp
+		}
+
+		Convey("When I store a response that is too large", func() {
+
+			resp := store(k1, "[1,2,3,4,5,6]")
+
+			Convey("Then it should not be cached", func() {
+				_, ok := c.get(k1)
+				So(ok, ShouldBeFalse)
+			})
+
+			Convey("Then its body should be intact", func() {
+				data, err := ioutil.ReadAll(resp.Body)
+				So(err, ShouldBeNil)
+				So(string(data), ShouldEqual, "[1,2,3,4,5,6]")
+			})
+		})
+
+		Convey("When I store two responses exceeding the size together", func() {
+
+			store(k1, "[1,2,3]")
+			store(k2, "[4,5,6]")
+
+			Convey("Then the first one should have been evicted", func() {
+				_, ok1 := c.get(k1)
+				_, ok2 := c.get(k2)
+				So(ok1, ShouldBeFalse)
+				So(ok2, ShouldBeTrue)
+				So(c.bytes, ShouldEqual, 7)
+			})
+		})
+
+		Convey("When I store the same response twice", func() {
+
+			store(k1, "[1,2,3]")
+			store(k1, "[1,2,3]")
+
+			Convey("Then it should be accounted for once", func() {
+				_, ok := c.get(k1)
+				So(ok, ShouldBeTrue)
+				So(c.bytes, ShouldEqual, 7)
+			})
+		})
+	})
+}
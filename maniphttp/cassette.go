@@ -0,0 +1,275 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maniphttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
+
+	"go.aporeto.io/manipulate"
+)
+
+// defaultRecordedHeaders are the headers recorded when
+// no header is given to OptionRecorder.
+var defaultRecordedHeaders = []string{
+	"Content-Type",
+	"Accept",
+	"X-Namespace",
+	"X-Fields",
+	"X-Count-Total",
+	"X-Next",
+	"X-Messages",
+	"ETag",
+	"Last-Modified",
+}
+
+// An Exchange represents a request sent to the API
+// and the response it returned.
+type Exchange struct {
+	Method          string      `json:"method"`
+	URL             string      `json:"URL"`
+	RequestHeaders  http.Header `json:"requestHeaders,omitempty"`
+	RequestBody     []byte      `json:"requestBody,omitempty"`
+	Status          int         `json:"status"`
+	ResponseHeaders http.Header `json:"responseHeaders,omitempty"`
+	ResponseBody    []byte      `json:"responseBody,omitempty"`
+}
+
+// The recorded exchanges are appended to the cassette file right
+// before its tail, which is written again after each of them.
+const (
+	cassetteHead      = "{\n  \"exchanges\": [\n    "
+	cassetteSeparator = ",\n    "
+	cassetteTail      = "\n  ]\n}\n"
+)
+
+// A Cassette contains recorded exchanges.
+type Cassette struct {
+	Exchanges []*Exchange `json:"exchanges"`
+
+	path    string
+	headers []string
+	played  map[*Exchange]bool
+	end     int64
+	lock    sync.Mutex
+}
+
+// LoadCassette loads the cassette at the given path.
+func LoadCassette(path string) (*Cassette, error) {
+
+	data, err := ioutil.ReadFile(path) // #nosec
+	if err != nil {
+		return nil, fmt.Errorf("unable to read cassette: %s", err)
+	}
+
+	c := &Cassette{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("unable to decode cassette: %s", err)
+	}
+
+	return c, nil
+}
+
+// record sends the request and writes the exchange to the cassette file.
+func (c *Cassette) record(mctx manipulate.Context, req *http.Request, next RoundTripFunc) (*http.Response, error) {
+
+	if isStreaming(req) {
+		return next(req)
+	}
+
+	var reqBody []byte
+	if req.Body != nil {
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		_ = req.Body.Close() // nolint
+		req.Body = ioutil.NopCloser(bytes.NewReader(data))
+		reqBody = data
+	}
+
+	resp, err := next(req)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close() // nolint
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	if err := c.write(&Exchange{
+		Method:          req.Method,
+		URL:             req.URL.String(),
+		RequestHeaders:  selectHeaders(req.Header, c.headers),
+		RequestBody:     reqBody,
+		Status:          resp.StatusCode,
+		ResponseHeaders: selectHeaders(resp.Header, c.headers),
+		ResponseBody:    respBody,
+	}); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// write appends the given exchange to the cassette file, so the
+// file is a valid cassette after each exchange without having
+// to write the previous ones again.
+func (c *Cassette) write(e *Exchange) error {
+
+	data, err := json.MarshalIndent(e, "    ", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode exchange: %s", err)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	prefix := cassetteSeparator
+	if c.end == 0 {
+		flags |= os.O_TRUNC
+		prefix = cassetteHead
+	}
+
+	f, err := os.OpenFile(c.path, flags, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to write cassette: %s", err)
+	}
+
+	buf := make([]byte, 0, len(prefix)+len(data)+len(cassetteTail))
+	buf = append(append(append(buf, prefix...), data...), cassetteTail...)
+
+	if _, err = f.WriteAt(buf, c.end); err != nil {
+		_ = f.Close() // nolint
+		return fmt.Errorf("unable to write cassette: %s", err)
+	}
+
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("unable to write cassette: %s", err)
+	}
+
+	c.end += int64(len(buf) - len(cassetteTail))
+
+	return nil
+}
+
+// replay serves the request from the recorded exchanges. Exchanges
+// match on method, path, query and body. Matching exchanges are served
+// in the order they have been recorded, and the last one is served
+// again once they all have been played.
+func (c *Cassette) replay(mctx manipulate.Context, req *http.Request, next RoundTripFunc) (*http.Response, error) {
+
+	var body []byte
+	if req.Body != nil {
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		_ = req.Body.Close() // nolint
+		body = data
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.played == nil {
+		c.played = map[*Exchange]bool{}
+	}
+
+	var found *Exchange
+	for _, e := range c.Exchanges {
+
+		if !e.matches(req, body) {
+			continue
+		}
+
+		found = e
+
+		if !c.played[e] {
+			break
+		}
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("no recorded exchange for %s %s", req.Method, req.URL)
+	}
+
+	c.played[found] = true
+
+	header := http.Header{}
+	for k, v := range found.ResponseHeaders {
+		header[k] = append([]string(nil), v...)
+	}
+
+	return &http.Response{
+		Status:        strconv.Itoa(found.Status) + " " + http.StatusText(found.Status),
+		StatusCode:    found.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(found.ResponseBody)),
+		ContentLength: int64(len(found.ResponseBody)),
+		Request:       req,
+	}, nil
+}
+
+func (e *Exchange) matches(req *http.Request, body []byte) bool {
+
+	if e.Method != req.Method {
+		return false
+	}
+
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		return false
+	}
+
+	if u.Path != req.URL.Path {
+		return false
+	}
+
+	if !reflect.DeepEqual(u.Query(), req.URL.Query()) {
+		return false
+	}
+
+	return bytes.Equal(e.RequestBody, body)
+}
+
+func selectHeaders(header http.Header, keys []string) http.Header {
+
+	out := http.Header{}
+
+	for _, k := range keys {
+		if v, ok := header[http.CanonicalHeaderKey(k)]; ok {
+			out[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+		}
+	}
+
+	if len(out) == 0 {
+		return nil
+	}
+
+	return out
+}
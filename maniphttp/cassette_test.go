@@ -0,0 +1,185 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maniphttp
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	testmodel "go.aporeto.io/elemental/test/model"
+	"go.aporeto.io/manipulate"
+)
+
+func TestCassette(t *testing.T) {
+
+	Convey("Given I have recorded exchanges with a server", t, func() {
+
+		dir, err := ioutil.TempDir("", "cassette")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		path := filepath.Join(dir, "cassette.json")
+
+		var count int
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			count++
+			w.Header().Set("Content-Type", "application/json")
+
+			switch r.Method {
+			case http.MethodGet:
+				w.Header().Set("X-Count-Total", "1")
+				fmt.Fprintf(w, `[{"ID": "xxx", "name": "hello %d"}]`, count)
+			case http.MethodPost:
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprint(w, `{"ID": "yyy", "name": "created"}`)
+			}
+		}))
+
+		m, _ := New(context.Background(), ts.URL, OptionRecorder(path), OptionToken("secret"))
+
+		So(m.RetrieveMany(nil, &testmodel.ListsList{}), ShouldBeNil)
+		So(m.RetrieveMany(nil, &testmodel.ListsList{}), ShouldBeNil)
+
+		l := testmodel.NewList()
+		l.Name = "created"
+		So(m.Create(nil, l), ShouldBeNil)
+
+		ts.Close()
+
+		Convey("When I load the cassette", func() {
+
+			c, err := LoadCassette(path)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the exchanges should be recorded", func() {
+				So(len(c.Exchanges), ShouldEqual, 3)
+				So(c.Exchanges[0].Method, ShouldEqual, http.MethodGet)
+				So(c.Exchanges[0].Status, ShouldEqual, http.StatusOK)
+				So(c.Exchanges[0].ResponseHeaders.Get("X-Count-Total"), ShouldEqual, "1")
+				So(c.Exchanges[0].RequestHeaders.Get("Authorization"), ShouldEqual, "")
+				So(c.Exchanges[2].Method, ShouldEqual, http.MethodPost)
+				So(c.Exchanges[2].Status, ShouldEqual, http.StatusCreated)
+				So(string(c.Exchanges[2].ResponseBody), ShouldEqual, `{"ID": "yyy", "name": "created"}`)
+			})
+
+			Convey("When I replay them", func() {
+
+				rm, _ := New(context.Background(), ts.URL, OptionReplayer(c))
+
+				mctx := manipulate.NewContext(context.Background())
+				l1 := testmodel.ListsList{}
+				err1 := rm.RetrieveMany(mctx, &l1)
+
+				l2 := testmodel.ListsList{}
+				err2 := rm.RetrieveMany(nil, &l2)
+
+				l3 := testmodel.ListsList{}
+				err3 := rm.RetrieveMany(nil, &l3)
+
+				o := testmodel.NewList()
+				o.Name = "created"
+				err4 := rm.Create(nil, o)
+
+				Convey("Then err should be nil", func() {
+					So(err1, ShouldBeNil)
+					So(err2, ShouldBeNil)
+					So(err3, ShouldBeNil)
+					So(err4, ShouldBeNil)
+				})
+
+				Convey("Then the responses should be replayed in order", func() {
+					So(l1[0].Name, ShouldEqual, "hello 1")
+					So(mctx.Count(), ShouldEqual, 1)
+					So(l2[0].Name, ShouldEqual, "hello 2")
+					So(l3[0].Name, ShouldEqual, "hello 2")
+					So(o.ID, ShouldEqual, "yyy")
+				})
+			})
+
+			Convey("When I replay an exchange that has not been recorded", func() {
+
+				rm, _ := New(context.Background(), ts.URL, OptionReplayer(c))
+
+				o := testmodel.NewList()
+				o.Name = "other"
+				err := rm.Create(nil, o)
+
+				Convey("Then err should be correct", func() {
+					So(err, ShouldHaveSameTypeAs, manipulate.ErrCannotExecuteQuery{})
+				})
+			})
+		})
+	})
+
+	Convey("Given I have a cassette file with previous content", t, func() {
+
+		dir, err := ioutil.TempDir("", "cassette")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		path := filepath.Join(dir, "cassette.json")
+		So(ioutil.WriteFile(path, []byte(strings.Repeat("previous content ", 100)), 0600), ShouldBeNil)
+
+		c := &Cassette{path: path}
+
+		Convey("When I write exchanges", func() {
+
+			var counts []int
+			for i := 0; i < 3; i++ {
+
+				So(c.write(&Exchange{Method: http.MethodGet, URL: fmt.Sprintf("/lists/%d", i), Status: http.StatusOK}), ShouldBeNil)
+
+				loaded, err := LoadCassette(path)
+				So(err, ShouldBeNil)
+				counts = append(counts, len(loaded.Exchanges))
+			}
+
+			Convey("Then the file should be a valid cassette after each of them", func() {
+				So(counts, ShouldResemble, []int{1, 2, 3})
+			})
+
+			Convey("Then the exchanges should be in order", func() {
+				loaded, _ := LoadCassette(path)
+				So(loaded.Exchanges[0].URL, ShouldEqual, "/lists/0")
+				So(loaded.Exchanges[2].URL, ShouldEqual, "/lists/2")
+			})
+
+			Convey("Then they should not be held in memory", func() {
+				So(c.Exchanges, ShouldBeEmpty)
+			})
+		})
+	})
+
+	Convey("Given I have no cassette", t, func() {
+
+		Convey("When I load it", func() {
+
+			_, err := LoadCassette("/not/here.json")
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
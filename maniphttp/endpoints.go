@@ -0,0 +1,277 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maniphttp
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// endpointRecoveryDelay is the time during which an endpoint
+	// that could not be reached is considered unhealthy.
+	endpointRecoveryDelay = 10 * time.Second
+
+	// latencySmoothing is the weight of a new latency sample
+	// in the moving average of the latency of an endpoint.
+	latencySmoothing = 0.2
+)
+
+// An EndpointSelection represents the way the manipulator
+// selects the endpoint to send a request to.
+type EndpointSelection int
+
+// Various values of EndpointSelection.
+const (
+	// EndpointSelectionRoundRobin sends the requests to each
+	// healthy endpoint in turn.
+	EndpointSelectionRoundRobin EndpointSelection = iota
+
+	// EndpointSelectionLeastLatency sends the requests to the
+	// healthy endpoint with the lowest average latency.
+	EndpointSelectionLeastLatency
+)
+
+// EndpointHealth contains health information about an endpoint
+// of a manipulator.
+type EndpointHealth struct {
+
+	// URL is the url of the endpoint.
+	URL string
+
+	// Healthy is true if the endpoint is considered healthy.
+	Healthy bool
+
+	// Failures is the number of consecutive failures to reach the endpoint.
+	Failures int
+
+	// Latency is the average latency of the endpoint.
+	Latency time.Duration
+
+	// CircuitBreakerState is the state of the circuit breaker of the endpoint.
+	CircuitBreakerState CircuitBreakerState
+}
+
+type endpoint struct {
+	url      string
+	breaker  *circuitBreaker
+	failures int
+	failedAt time.Time
+	latency  time.Duration
+	now      func() time.Time
+	lock     sync.Mutex
+}
+
+// rebase returns the given url, which has been built using the
+// given base url, with the base url replaced by the url of the endpoint.
+func (e *endpoint) rebase(u string, base string) string {
+
+	if e.url == base || !strings.HasPrefix(u, base) {
+		return u
+	}
+
+	return e.url + strings.TrimPrefix(u, base)
+}
+
+// healthy returns true if the endpoint has been reached recently
+// and its circuit breaker, if any, is closed.
+func (e *endpoint) healthy() bool {
+
+	if e.breaker != nil && e.breaker.currentState() != CircuitBreakerClosed {
+		return false
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.failures == 0 || e.now().Sub(e.failedAt) >= endpointRecoveryDelay
+}
+
+// allow returns true if a request can be sent to the endpoint.
+func (e *endpoint) allow() bool {
+	return e.breaker == nil || e.breaker.allow()
+}
+
+// success reports that the endpoint could be reached
+// with the given latency.
+func (e *endpoint) success(latency time.Duration) {
+
+	if e.breaker != nil {
+		e.breaker.success()
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.failures = 0
+
+	if e.latency == 0 {
+		e.latency = latency
+	} else {
+		e.latency += time.Duration(latencySmoothing * float64(latency-e.latency))
+	}
+}
+
+// failure reports that the endpoint could not be reached.
+func (e *endpoint) failure() {
+
+	if e.breaker != nil {
+		e.breaker.failure()
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.failures++
+	e.failedAt = e.now()
+}
+
+func (e *endpoint) health() EndpointHealth {
+
+	h := EndpointHealth{
+		URL:     e.url,
+		Healthy: e.healthy(),
+	}
+
+	if e.breaker != nil {
+		h.CircuitBreakerState = e.breaker.currentState()
+	}
+
+	e.lock.Lock()
+	h.Failures = e.failures
+	h.Latency = e.latency
+	e.lock.Unlock()
+
+	return h
+}
+
+// endpointPool selects the endpoints to send the requests to.
+type endpointPool struct {
+	endpoints []*endpoint
+	selection EndpointSelection
+	next      uint32
+}
+
+func newEndpointPool(urls []string, selection EndpointSelection, breakerThreshold int, breakerCooldown time.Duration) *endpointPool {
+
+	p := &endpointPool{
+		endpoints: make([]*endpoint, len(urls)),
+		selection: selection,
+	}
+
+	for i, u := range urls {
+
+		p.endpoints[i] = &endpoint{
+			url: strings.TrimRight(u, "/"),
+			now: time.Now,
+		}
+
+		if breakerThreshold > 0 {
+			p.endpoints[i].breaker = newCircuitBreaker(breakerThreshold, breakerCooldown)
+		}
+	}
+
+	return p
+}
+
+// pick returns the endpoint to send the next try of a request to.
+// If the previous try failed to reach an endpoint, it must be given
+// as failed so another endpoint is preferred. Unhealthy endpoints are
+// only picked when there is no healthy one. If no endpoint allows
+// a request, pick returns nil.
+func (p *endpointPool) pick(failed *endpoint) *endpoint {
+
+	ordered := p.ordered(failed)
+
+	for _, e := range ordered {
+		if e.healthy() && e.allow() {
+			return e
+		}
+	}
+
+	for _, e := range ordered {
+		if !e.healthy() && e.allow() {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// ordered returns the endpoints in the order of preference,
+// according to the selection of the pool, and with the given failed
+// endpoint last.
+func (p *endpointPool) ordered(failed *endpoint) []*endpoint {
+
+	n := len(p.endpoints)
+	out := make([]*endpoint, 0, n)
+
+	switch p.selection {
+
+	case EndpointSelectionLeastLatency:
+		out = append(out, p.endpoints...)
+		latencies := make(map[*endpoint]time.Duration, n)
+		for _, e := range out {
+			latencies[e] = e.health().Latency
+		}
+		sort.SliceStable(out, func(i, j int) bool { return latencies[out[i]] < latencies[out[j]] })
+
+	default:
+		start := int(atomic.AddUint32(&p.next, 1)-1) % n
+		for i := 0; i < n; i++ {
+			out = append(out, p.endpoints[(start+i)%n])
+		}
+	}
+
+	if failed == nil || n == 1 {
+		return out
+	}
+
+	for i, e := range out {
+		if e == failed {
+			out = append(append(out[:i:i], out[i+1:]...), failed)
+			break
+		}
+	}
+
+	return out
+}
+
+// urls returns the urls of the endpoints, healthy ones first.
+func (p *endpointPool) urls() []string {
+
+	healthy := make([]string, 0, len(p.endpoints))
+	unhealthy := make([]string, 0, len(p.endpoints))
+
+	for _, e := range p.ordered(nil) {
+		if e.healthy() {
+			healthy = append(healthy, e.url)
+		} else {
+			unhealthy = append(unhealthy, e.url)
+		}
+	}
+
+	return append(healthy, unhealthy...)
+}
+
+func (p *endpointPool) health() []EndpointHealth {
+
+	out := make([]EndpointHealth, len(p.endpoints))
+	for i, e := range p.endpoints {
+		out[i] = e.health()
+	}
+
+	return out
+}
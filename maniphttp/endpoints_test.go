@@ -0,0 +1,209 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maniphttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	testmodel "go.aporeto.io/elemental/test/model"
+	"go.aporeto.io/manipulate"
+)
+
+func TestEndpointPool(t *testing.T) {
+
+	Convey("Given I have a round robin pool with a fake clock", t, func() {
+
+		now := time.Unix(1000, 0)
+		p := newEndpointPool([]string{"https://a.com", "https://b.com", "https://c.com"}, EndpointSelectionRoundRobin, 0, 0)
+		for _, e := range p.endpoints {
+			e.now = func() time.Time { return now }
+		}
+		a, b, c := p.endpoints[0], p.endpoints[1], p.endpoints[2]
+
+		Convey("When I pick endpoints", func() {
+
+			e1, e2, e3, e4 := p.pick(nil), p.pick(nil), p.pick(nil), p.pick(nil)
+
+			Convey("Then they should be picked in turn", func() {
+				So(e1, ShouldEqual, a)
+				So(e2, ShouldEqual, b)
+				So(e3, ShouldEqual, c)
+				So(e4, ShouldEqual, a)
+			})
+		})
+
+		Convey("When an endpoint fails", func() {
+
+			b.failure()
+
+			e1, e2, e3 := p.pick(nil), p.pick(nil), p.pick(nil)
+
+			Convey("Then it should be skipped", func() {
+				So(b.healthy(), ShouldBeFalse)
+				So(e1, ShouldEqual, a)
+				So(e2, ShouldEqual, c)
+				So(e3, ShouldEqual, c)
+			})
+
+			Convey("Then the urls should list it last", func() {
+				So(p.urls()[2], ShouldEqual, "https://b.com")
+			})
+
+			Convey("When the recovery delay has passed", func() {
+
+				now = now.Add(endpointRecoveryDelay)
+
+				Convey("Then it should be healthy again", func() {
+					So(b.healthy(), ShouldBeTrue)
+				})
+			})
+		})
+
+		Convey("When I pick an endpoint after a failed one", func() {
+
+			p.pick(nil)
+			a.failure()
+			e := p.pick(a)
+
+			Convey("Then another endpoint should be picked", func() {
+				So(e, ShouldNotEqual, a)
+			})
+		})
+
+		Convey("When all endpoints fail", func() {
+
+			a.failure()
+			b.failure()
+			c.failure()
+
+			Convey("Then an unhealthy endpoint should still be picked", func() {
+				So(p.pick(nil), ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given I have a least latency pool", t, func() {
+
+		p := newEndpointPool([]string{"https://a.com", "https://b.com"}, EndpointSelectionLeastLatency, 0, 0)
+		a, b := p.endpoints[0], p.endpoints[1]
+
+		a.success(200 * time.Millisecond)
+		b.success(100 * time.Millisecond)
+
+		Convey("When I pick endpoints", func() {
+
+			e1, e2 := p.pick(nil), p.pick(nil)
+
+			Convey("Then the fastest one should be picked", func() {
+				So(e1, ShouldEqual, b)
+				So(e2, ShouldEqual, b)
+			})
+		})
+
+		Convey("When the fastest one gets slower", func() {
+
+			for i := 0; i < 10; i++ {
+				b.success(time.Second)
+			}
+
+			Convey("Then the other one should be picked", func() {
+				So(p.pick(nil), ShouldEqual, a)
+			})
+		})
+
+		Convey("When the fastest one failed", func() {
+
+			Convey("Then the other one should be picked", func() {
+				So(p.pick(b), ShouldEqual, a)
+			})
+		})
+	})
+
+	Convey("Given I have a pool with circuit breakers", t, func() {
+
+		p := newEndpointPool([]string{"https://a.com", "https://b.com"}, EndpointSelectionRoundRobin, 1, time.Minute)
+		a, b := p.endpoints[0], p.endpoints[1]
+
+		Convey("When all endpoints fail", func() {
+
+			a.failure()
+			b.failure()
+
+			Convey("Then no endpoint should be picked", func() {
+				So(p.pick(nil), ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given I have an endpoint", t, func() {
+
+		e := &endpoint{url: "https://b.com"}
+
+		Convey("Then rebase should work", func() {
+			So(e.rebase("https://a.com/v/1/lists", "https://a.com"), ShouldEqual, "https://b.com/v/1/lists")
+			So(e.rebase("https://c.com/lists", "https://a.com"), ShouldEqual, "https://c.com/lists")
+			So(e.rebase("https://b.com/lists", "https://b.com"), ShouldEqual, "https://b.com/lists")
+		})
+	})
+}
+
+func TestHTTP_endpointsFailover(t *testing.T) {
+
+	Convey("Given I have a manipulator with a dead endpoint and a live one", t, func() {
+
+		var calls int32
+
+		dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		dead.Close()
+
+		live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"ID": "xxx", "name": "hello"}`)
+		}))
+		defer live.Close()
+
+		m, _ := New(context.Background(), dead.URL, OptionEndpoints(live.URL))
+
+		Convey("When I retrieve an object", func() {
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			list := testmodel.NewList()
+			list.ID = "xxx"
+			err := m.Retrieve(manipulate.NewContext(ctx), list)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+				So(list.Name, ShouldEqual, "hello")
+				So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+			})
+
+			Convey("Then the dead endpoint should be unhealthy", func() {
+				h := ExtractEndpointsHealth(m)
+				So(len(h), ShouldEqual, 2)
+				So(h[0].URL, ShouldEqual, dead.URL)
+				So(h[0].Healthy, ShouldBeFalse)
+				So(h[1].URL, ShouldEqual, live.URL)
+				So(h[1].Healthy, ShouldBeTrue)
+			})
+		})
+	})
+}
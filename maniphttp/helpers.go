@@ -128,5 +128,51 @@ func DirectSend(manipulator manipulate.Manipulator, mctx manipulate.Context, end
 	sp := tracing.StartTrace(mctx, fmt.Sprintf("maniphttp.directsend"))
 	defer sp.Finish()
 
-	return m.send(mctx, method, url, body, nil, sp)
+	return m.send(mctx, elemental.Identity{}, method, url, body, nil, sp)
+}
+
+// ExtractCircuitBreakerState returns the state of the circuit breakers of the given
+// manipulator. It returns CircuitBreakerClosed if the circuit breaker of at least one
+// endpoint is closed, or if the manipulator has no circuit breaker. Otherwise, it returns
+// CircuitBreakerHalfOpen if at least one is half open, and CircuitBreakerOpen if all are open.
+// Note: the given manipulator must be an HTTP Manipulator or it will panic.
+func ExtractCircuitBreakerState(manipulator manipulate.Manipulator) CircuitBreakerState {
+
+	m, ok := manipulator.(*httpManipulator)
+	if !ok {
+		panic("You can only pass a HTTP Manipulator to ExtractCircuitBreakerState")
+	}
+
+	if m.endpoints == nil {
+		return CircuitBreakerClosed
+	}
+
+	state := CircuitBreakerOpen
+	for _, h := range m.endpoints.health() {
+		switch h.CircuitBreakerState {
+		case CircuitBreakerClosed:
+			return CircuitBreakerClosed
+		case CircuitBreakerHalfOpen:
+			state = CircuitBreakerHalfOpen
+		}
+	}
+
+	return state
+}
+
+// ExtractEndpointsHealth returns the health of the endpoints of the given
+// manipulator, in the order they have been given.
+// Note: the given manipulator must be an HTTP Manipulator or it will panic.
+func ExtractEndpointsHealth(manipulator manipulate.Manipulator) []EndpointHealth {
+
+	m, ok := manipulator.(*httpManipulator)
+	if !ok {
+		panic("You can only pass a HTTP Manipulator to ExtractEndpointsHealth")
+	}
+
+	if m.endpoints == nil {
+		return nil
+	}
+
+	return m.endpoints.health()
 }
@@ -21,6 +21,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 	"go.aporeto.io/manipulate/maniptest"
@@ -246,3 +247,105 @@ func TestManiphttp_DirectSend(t *testing.T) {
 		})
 	})
 }
+
+func TestManiphttp_ExtractCircuitBreakerState(t *testing.T) {
+
+	Convey("Given I have an httpmanipulator without circuit breaker", t, func() {
+
+		m := &httpManipulator{}
+
+		Convey("When I call ExtractCircuitBreakerState", func() {
+
+			s := ExtractCircuitBreakerState(m)
+
+			Convey("Then the state should be closed", func() {
+				So(s, ShouldEqual, CircuitBreakerClosed)
+			})
+		})
+	})
+
+	Convey("Given I have an httpmanipulator with an open circuit breaker", t, func() {
+
+		m := &httpManipulator{
+			endpoints: newEndpointPool([]string{"https://a.com"}, EndpointSelectionRoundRobin, 1, time.Minute),
+		}
+		m.endpoints.endpoints[0].failure()
+
+		Convey("When I call ExtractCircuitBreakerState", func() {
+
+			s := ExtractCircuitBreakerState(m)
+
+			Convey("Then the state should be open", func() {
+				So(s, ShouldEqual, CircuitBreakerOpen)
+			})
+		})
+	})
+
+	Convey("Given I have an httpmanipulator with an open and a closed circuit breaker", t, func() {
+
+		m := &httpManipulator{
+			endpoints: newEndpointPool([]string{"https://a.com", "https://b.com"}, EndpointSelectionRoundRobin, 1, time.Minute),
+		}
+		m.endpoints.endpoints[0].failure()
+
+		Convey("When I call ExtractCircuitBreakerState", func() {
+
+			s := ExtractCircuitBreakerState(m)
+
+			Convey("Then the state should be closed", func() {
+				So(s, ShouldEqual, CircuitBreakerClosed)
+			})
+		})
+	})
+
+	Convey("Given I have a non http manipulator", t, func() {
+
+		m := maniptest.NewTestManipulator()
+
+		Convey("When I call ExtractCircuitBreakerState", func() {
+
+			Convey("Then it should panic", func() {
+				So(func() { ExtractCircuitBreakerState(m) }, ShouldPanicWith, "You can only pass a HTTP Manipulator to ExtractCircuitBreakerState")
+			})
+		})
+	})
+}
+
+func TestManiphttp_ExtractEndpointsHealth(t *testing.T) {
+
+	Convey("Given I have an httpmanipulator with two endpoints", t, func() {
+
+		m := &httpManipulator{
+			endpoints: newEndpointPool([]string{"https://a.com/", "https://b.com"}, EndpointSelectionRoundRobin, 0, 0),
+		}
+		m.endpoints.endpoints[0].success(time.Second)
+		m.endpoints.endpoints[1].failure()
+
+		Convey("When I call ExtractEndpointsHealth", func() {
+
+			h := ExtractEndpointsHealth(m)
+
+			Convey("Then the health should be correct", func() {
+				So(len(h), ShouldEqual, 2)
+				So(h[0].URL, ShouldEqual, "https://a.com")
+				So(h[0].Healthy, ShouldBeTrue)
+				So(h[0].Latency, ShouldEqual, time.Second)
+				So(h[1].URL, ShouldEqual, "https://b.com")
+				So(h[1].Healthy, ShouldBeFalse)
+				So(h[1].Failures, ShouldEqual, 1)
+			})
+		})
+	})
+
+	Convey("Given I have a non http manipulator", t, func() {
+
+		m := maniptest.NewTestManipulator()
+
+		Convey("When I call ExtractEndpointsHealth", func() {
+
+			Convey("Then it should panic", func() {
+				So(func() { ExtractEndpointsHealth(m) }, ShouldPanicWith, "You can only pass a HTTP Manipulator to ExtractEndpointsHealth")
+			})
+		})
+	})
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maniphttp
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.aporeto.io/manipulate"
+)
+
+// A RoundTripFunc sends the given request and returns the response.
+type RoundTripFunc func(*http.Request) (*http.Response, error)
+
+// An Interceptor intercepts the requests sent by the manipulator.
+//
+// It receives the outgoing request, once all headers have been set, with the
+// manipulate.Context of the operation. It must call next to send the request,
+// or return its own response or error, and it receives the response or the
+// error returned by next. It can modify the request before calling next, and
+// the response before returning it.
+//
+// Interceptors are called for every try of a request, so they may be
+// called multiple times per operation.
+type Interceptor func(mctx manipulate.Context, req *http.Request, next RoundTripFunc) (*http.Response, error)
+
+// do sends the given request through the interceptors
+// of the manipulator, then using its http client.
+func (s *httpManipulator) do(mctx manipulate.Context, req *http.Request) (*http.Response, error) {
+
+	next := s.client.Do
+
+	for i := len(s.interceptors) - 1; i >= 0; i-- {
+		interceptor, n := s.interceptors[i], next
+		next = func(r *http.Request) (*http.Response, error) { return interceptor(mctx, r, n) }
+	}
+
+	resp, err := next(req)
+	if err == nil && resp == nil {
+		err = errors.New("no response returned by interceptor")
+	}
+
+	if err == nil {
+		return resp, nil
+	}
+
+	if resp != nil {
+		_ = resp.Body.Close() // nolint
+	}
+
+	// The retry loop relies on the client always returning
+	// an *url.Error, so we wrap errors from interceptors.
+	if _, ok := err.(*url.Error); !ok {
+
+		method := req.Method
+		if method == "" {
+			method = http.MethodGet
+		}
+
+		err = &url.Error{
+			Op:  method[:1] + strings.ToLower(method[1:]),
+			URL: req.URL.String(),
+			Err: err,
+		}
+	}
+
+	return nil, err
+}
@@ -0,0 +1,116 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maniphttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	testmodel "go.aporeto.io/elemental/test/model"
+	"go.aporeto.io/manipulate"
+)
+
+func TestHTTP_interceptors(t *testing.T) {
+
+	Convey("Given I have a manipulator with interceptors", t, func() {
+
+		var receivedHeader string
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			receivedHeader = r.Header.Get("X-Signature")
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"ID": "xxx", "name": "hello"}`)
+		}))
+		defer ts.Close()
+
+		var calls []string
+		var seenMctx manipulate.Context
+		var seenStatus int
+
+		first := func(mctx manipulate.Context, req *http.Request, next RoundTripFunc) (*http.Response, error) {
+			calls = append(calls, "first-in")
+			seenMctx = mctx
+			req.Header.Set("X-Signature", "signed:"+req.Header.Get("X-Namespace"))
+			resp, err := next(req)
+			calls = append(calls, "first-out")
+			return resp, err
+		}
+
+		second := func(mctx manipulate.Context, req *http.Request, next RoundTripFunc) (*http.Response, error) {
+			calls = append(calls, "second-in")
+			resp, err := next(req)
+			if resp != nil {
+				seenStatus = resp.StatusCode
+			}
+			calls = append(calls, "second-out")
+			return resp, err
+		}
+
+		m, _ := New(context.Background(), ts.URL, OptionNamespace("/ns"), OptionInterceptors(first, second))
+
+		Convey("When I retrieve an object", func() {
+
+			mctx := manipulate.NewContext(context.Background())
+			list := testmodel.NewList()
+			list.ID = "xxx"
+			err := m.Retrieve(mctx, list)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+				So(list.Name, ShouldEqual, "hello")
+			})
+
+			Convey("Then the interceptors should have been called in order", func() {
+				So(calls, ShouldResemble, []string{"first-in", "second-in", "second-out", "first-out"})
+				So(seenMctx, ShouldEqual, mctx)
+				So(seenStatus, ShouldEqual, http.StatusOK)
+			})
+
+			Convey("Then the request should have been modified", func() {
+				So(receivedHeader, ShouldEqual, "signed:/ns")
+			})
+		})
+	})
+
+	Convey("Given I have a manipulator with an interceptor returning an error", t, func() {
+
+		var called bool
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		}))
+		defer ts.Close()
+
+		failing := func(mctx manipulate.Context, req *http.Request, next RoundTripFunc) (*http.Response, error) {
+			return nil, fmt.Errorf("not allowed")
+		}
+
+		m, _ := New(context.Background(), ts.URL, OptionInterceptors(failing))
+
+		Convey("When I retrieve an object", func() {
+
+			list := testmodel.NewList()
+			list.ID = "xxx"
+			err := m.Retrieve(nil, list)
+
+			Convey("Then err should be correct", func() {
+				So(err, ShouldHaveSameTypeAs, manipulate.ErrCannotExecuteQuery{})
+				So(err.Error(), ShouldEqual, fmt.Sprintf(`Unable to execute query: Get "%s/v/1/lists/xxx": not allowed`, ts.URL))
+				So(called, ShouldBeFalse)
+			})
+		})
+	})
+}
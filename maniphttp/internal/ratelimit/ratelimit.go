@@ -0,0 +1,182 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// throttleThreshold is the number of consecutive throttled
+	// requests after which the limit is shrunk.
+	throttleThreshold = 3
+
+	// shrinkRatio is the ratio applied to the limit when it shrinks.
+	shrinkRatio = 0.5
+
+	// growRatio is the ratio of the initial limit that is given
+	// back after each successful request.
+	growRatio = 0.05
+
+	// minLimitRatio is the ratio of the initial limit under
+	// which the limit never shrinks.
+	minLimitRatio = 0.01
+)
+
+// A Limiter is a token bucket rate limiter. Its limit shrinks
+// after sustained throttling and grows back to the initial
+// limit after successful requests.
+type Limiter struct {
+	maxLimit  float64
+	minLimit  float64
+	limit     float64
+	burst     float64
+	tokens    float64
+	last      time.Time
+	pausedTo  time.Time
+	throttled int
+	now       func() time.Time
+	lock      sync.Mutex
+}
+
+// New returns a new Limiter allowing the given number
+// of events per second, with the given burst.
+func New(limit float64, burst int) *Limiter {
+
+	if limit <= 0 {
+		panic("limit must be greater than 0")
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Limiter{
+		maxLimit: limit,
+		minLimit: limit * minLimitRatio,
+		limit:    limit,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		now:      time.Now,
+	}
+}
+
+// Wait blocks until an event is allowed or the given context is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+
+	wait := l.reserve()
+	if wait <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		l.lock.Lock()
+		l.tokens++
+		l.lock.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Pause prevents any event to be allowed for the given duration.
+func (l *Limiter) Pause(d time.Duration) {
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if until := l.now().Add(d); until.After(l.pausedTo) {
+		l.pausedTo = until
+	}
+}
+
+// Throttled must be called when an event has been throttled
+// by the remote side. After sustained throttling, the limit shrinks.
+func (l *Limiter) Throttled() {
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.throttled++
+
+	if l.throttled < throttleThreshold {
+		return
+	}
+
+	l.throttled = 0
+	l.refill(l.now())
+	l.limit = math.Max(l.minLimit, l.limit*shrinkRatio)
+}
+
+// Succeeded must be called when an event has not been throttled
+// by the remote side. The limit grows back to its initial value.
+func (l *Limiter) Succeeded() {
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.throttled = 0
+
+	if l.limit < l.maxLimit {
+		l.refill(l.now())
+		l.limit = math.Min(l.maxLimit, l.limit+l.maxLimit*growRatio)
+	}
+}
+
+// Limit returns the current limit.
+func (l *Limiter) Limit() float64 {
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.limit
+}
+
+func (l *Limiter) reserve() time.Duration {
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.now()
+	l.refill(now)
+	l.tokens--
+
+	var wait time.Duration
+
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.limit * float64(time.Second))
+	}
+
+	if pause := l.pausedTo.Sub(now); pause > wait {
+		wait = pause
+	}
+
+	return wait
+}
+
+func (l *Limiter) refill(now time.Time) {
+
+	if !l.last.IsZero() {
+		if elapsed := now.Sub(l.last); elapsed > 0 {
+			l.tokens = math.Min(l.burst, l.tokens+elapsed.Seconds()*l.limit)
+		}
+	}
+
+	l.last = now
+}
@@ -0,0 +1,144 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLimiter(t *testing.T) {
+
+	Convey("Calling New with an invalid limit should panic", t, func() {
+		So(func() { New(0, 1) }, ShouldPanicWith, "limit must be greater than 0")
+	})
+
+	Convey("Given I have a limiter with a fake clock", t, func() {
+
+		now := time.Unix(1000, 0)
+		l := New(10, 2)
+		l.now = func() time.Time { return now }
+
+		Convey("When I reserve up to the burst", func() {
+
+			w1 := l.reserve()
+			w2 := l.reserve()
+
+			Convey("Then I should not wait", func() {
+				So(w1, ShouldEqual, 0)
+				So(w2, ShouldEqual, 0)
+			})
+
+			Convey("When I reserve more", func() {
+
+				w3 := l.reserve()
+				w4 := l.reserve()
+
+				Convey("Then I should wait according to the limit", func() {
+					So(w3, ShouldEqual, 100*time.Millisecond)
+					So(w4, ShouldEqual, 200*time.Millisecond)
+				})
+			})
+
+			Convey("When time passes and I reserve again", func() {
+
+				now = now.Add(100 * time.Millisecond)
+				w := l.reserve()
+
+				Convey("Then I should not wait", func() {
+					So(w, ShouldEqual, 0)
+				})
+			})
+		})
+
+		Convey("When I pause the limiter", func() {
+
+			l.Pause(time.Second)
+			w := l.reserve()
+
+			Convey("Then I should wait for the pause", func() {
+				So(w, ShouldEqual, time.Second)
+			})
+		})
+
+		Convey("When I get throttled less than the threshold", func() {
+
+			l.Throttled()
+			l.Throttled()
+
+			Convey("Then the limit should not change", func() {
+				So(l.Limit(), ShouldEqual, 10)
+			})
+		})
+
+		Convey("When I get throttled up to the threshold", func() {
+
+			l.Throttled()
+			l.Throttled()
+			l.Throttled()
+
+			Convey("Then the limit should shrink", func() {
+				So(l.Limit(), ShouldEqual, 5)
+			})
+
+			Convey("When I succeed", func() {
+
+				l.Succeeded()
+
+				Convey("Then the limit should grow", func() {
+					So(l.Limit(), ShouldAlmostEqual, 5.5, 0.0001)
+				})
+			})
+		})
+
+		Convey("When I get throttled a lot", func() {
+
+			for i := 0; i < 1000; i++ {
+				l.Throttled()
+			}
+
+			Convey("Then the limit should not go under the minimum", func() {
+				So(l.Limit(), ShouldAlmostEqual, 0.1, 0.0001)
+			})
+		})
+
+		Convey("When I succeed without being throttled", func() {
+
+			l.Succeeded()
+
+			Convey("Then the limit should not grow over the initial limit", func() {
+				So(l.Limit(), ShouldEqual, 10)
+			})
+		})
+	})
+
+	Convey("Given I have an exhausted limiter", t, func() {
+
+		l := New(0.001, 1)
+		l.reserve()
+
+		Convey("When I wait with a canceled context", func() {
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			err := l.Wait(ctx)
+
+			Convey("Then err should be context.Canceled", func() {
+				So(err, ShouldEqual, context.Canceled)
+			})
+		})
+	})
+}
@@ -26,6 +26,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofrs/uuid"
@@ -37,13 +38,18 @@ import (
 	"go.aporeto.io/manipulate/internal/idempotency"
 	"go.aporeto.io/manipulate/internal/snip"
 	"go.aporeto.io/manipulate/internal/tracing"
+	"go.aporeto.io/manipulate/maniphttp/internal/ratelimit"
 )
 
 const (
 	defaultGlobalContextTimeout = 2 * time.Minute
-	minContextTimeout           = 20 * time.Second
+	maxRetryAfter               = time.Minute
 )
 
+// minContextTimeout is the minimum timeout of each try.
+// It is a variable so it can be changed in tests.
+var minContextTimeout = 20 * time.Second
+
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
@@ -62,6 +68,20 @@ type httpManipulator struct {
 	atomicRenewTokenFunc func(context.Context) error
 	failureSimulations   map[float64]error
 	tokenCookieKey       string
+	bulkEndpoint         string
+	bulkUnsupportedUntil int64
+	cache                *responseCache
+	cacheMaxBytes        int64
+	rateLimiter          *ratelimit.Limiter
+	identityRateLimiters map[string]*ratelimit.Limiter
+	breakerThreshold     int
+	breakerCooldown      time.Duration
+	extraEndpoints       []string
+	endpointSelection    EndpointSelection
+	endpoints            *endpointPool
+	interceptors         []Interceptor
+	recorder             *Cassette
+	replayer             *Cassette
 
 	// optionnable
 	ctx            context.Context
@@ -91,6 +111,7 @@ func New(ctx context.Context, url string, options ...Option) (manipulate.Manipul
 		ctx:                ctx,
 		url:                url,
 		encoding:           elemental.EncodingTypeJSON,
+		bulkEndpoint:       defaultBulkEndpoint,
 	}
 
 	// Apply the options.
@@ -116,6 +137,29 @@ func New(ctx context.Context, url string, options ...Option) (manipulate.Manipul
 		m.client.Transport = m.transport
 	}
 
+	if m.cacheMaxBytes > 0 {
+		if m.cache == nil {
+			panic("OptionResponseCacheMaxBytes requires OptionResponseCache")
+		}
+		m.cache.maxBytes = m.cacheMaxBytes
+	}
+
+	// The recorder and the replayer must see the
+	// requests as they are sent, so they come last.
+	switch {
+	case m.replayer != nil:
+		m.interceptors = append(m.interceptors, m.replayer.replay)
+	case m.recorder != nil:
+		m.interceptors = append(m.interceptors, m.recorder.record)
+	}
+
+	m.endpoints = newEndpointPool(
+		append([]string{m.url}, m.extraEndpoints...),
+		m.endpointSelection,
+		m.breakerThreshold,
+		m.breakerCooldown,
+	)
+
 	// if we don't have a internal tls config, we sync with the current client.
 	if m.tlsConfig == nil {
 		m.tlsConfig = m.client.Transport.(*http.Transport).TLSClientConfig
@@ -176,7 +220,7 @@ func (s *httpManipulator) RetrieveMany(mctx manipulate.Context, dest elemental.I
 		return manipulate.NewErrCannotBuildQuery(err.Error())
 	}
 
-	response, err := s.send(mctx, http.MethodGet, url, nil, dest, sp)
+	response, err := s.send(mctx, dest.Identity(), http.MethodGet, url, nil, dest, sp)
 	if err != nil {
 		sp.SetTag("error", true)
 		sp.LogFields(log.Error(err))
@@ -219,7 +263,7 @@ func (s *httpManipulator) Retrieve(mctx manipulate.Context, object elemental.Ide
 		return manipulate.NewErrCannotBuildQuery(err.Error())
 	}
 
-	response, err := s.send(mctx, http.MethodGet, url, nil, object, sp)
+	response, err := s.send(mctx, object.Identity(), http.MethodGet, url, nil, object, sp)
 	if err != nil {
 		sp.SetTag("error", true)
 		sp.LogFields(log.Error(err))
@@ -273,7 +317,7 @@ func (s *httpManipulator) Create(mctx manipulate.Context, object elemental.Ident
 		return manipulate.NewErrCannotMarshal(err.Error())
 	}
 
-	response, err := s.send(mctx, http.MethodPost, url, data, object, sp)
+	response, err := s.send(mctx, object.Identity(), http.MethodPost, url, data, object, sp)
 	if err != nil {
 		sp.SetTag("error", true)
 		sp.LogFields(log.Error(err))
@@ -336,7 +380,7 @@ func (s *httpManipulator) Update(mctx manipulate.Context, object elemental.Ident
 		return manipulate.NewErrCannotMarshal(err.Error())
 	}
 
-	response, err := s.send(mctx, method, url, data, object, sp)
+	response, err := s.send(mctx, object.Identity(), method, url, data, object, sp)
 	if err != nil {
 		sp.SetTag("error", true)
 		sp.LogFields(log.Error(err))
@@ -382,7 +426,7 @@ func (s *httpManipulator) Delete(mctx manipulate.Context, object elemental.Ident
 		return manipulate.NewErrCannotBuildQuery(err.Error())
 	}
 
-	response, err := s.send(mctx, http.MethodDelete, url, nil, object, sp)
+	response, err := s.send(mctx, object.Identity(), http.MethodDelete, url, nil, object, sp)
 	if err != nil {
 		sp.SetTag("error", true)
 		sp.LogFields(log.Error(err))
@@ -402,7 +446,30 @@ func (s *httpManipulator) Delete(mctx manipulate.Context, object elemental.Ident
 }
 
 func (s *httpManipulator) DeleteMany(mctx manipulate.Context, identity elemental.Identity) error {
-	return manipulate.NewErrNotImplemented("DeleteMany not implemented in maniphttp")
+
+	if mctx == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultGlobalContextTimeout)
+		defer cancel()
+		mctx = manipulate.NewContext(ctx)
+	}
+
+	sp := tracing.StartTrace(mctx, fmt.Sprintf("maniphttp.delete_many.%s", identity.Category))
+	defer sp.Finish()
+
+	url, err := s.getURLForChildrenIdentity(mctx.Parent(), identity, 0, mctx.Version())
+	if err != nil {
+		sp.SetTag("error", true)
+		sp.LogFields(log.Error(err))
+		return manipulate.NewErrCannotBuildQuery(err.Error())
+	}
+
+	if _, err = s.send(mctx, identity, http.MethodDelete, url, nil, nil, sp); err != nil {
+		sp.SetTag("error", true)
+		sp.LogFields(log.Error(err))
+		return err
+	}
+
+	return nil
 }
 
 func (s *httpManipulator) Count(mctx manipulate.Context, identity elemental.Identity) (int, error) {
@@ -423,7 +490,7 @@ func (s *httpManipulator) Count(mctx manipulate.Context, identity elemental.Iden
 		return 0, manipulate.NewErrCannotBuildQuery(err.Error())
 	}
 
-	if _, err = s.send(mctx, http.MethodHead, url, nil, nil, sp); err != nil {
+	if _, err = s.send(mctx, identity, http.MethodHead, url, nil, nil, sp); err != nil {
 		sp.SetTag("error", true)
 		sp.LogFields(log.Error(err))
 		return 0, err
@@ -563,6 +630,7 @@ func (s *httpManipulator) getURLForChildrenIdentity(
 
 func (s *httpManipulator) send(
 	mctx manipulate.Context,
+	identity elemental.Identity,
 	method string,
 	requrl string,
 	body []byte,
@@ -578,9 +646,18 @@ func (s *httpManipulator) send(
 		}
 	}
 
-	var try int               // try number. Starts at 0
-	var lastError error       // last error before retry.
-	var tokenRenewedOnce bool // after an authorization failures token is renewed at most once.
+	var try int                  // try number. Starts at 0
+	var lastError error          // last error before retry.
+	var tokenRenewedOnce bool    // after an authorization failures token is renewed at most once.
+	var retryAfter time.Duration // retry delay requested by the server.
+	var unreachable bool         // the api could not be reached during the current try.
+	var ep *endpoint             // endpoint of the current try.
+	var failed *endpoint         // endpoint that could not be reached during the previous try.
+	var tryURL string            // url of the current try.
+	var latency time.Duration    // latency of the current try.
+
+	// We get the rate limiters that apply to the request.
+	limiters := s.rateLimitersFor(identity)
 
 	// We get the context deadline.
 	deadline, ok := mctx.Context().Deadline()
@@ -597,6 +674,10 @@ func (s *httpManipulator) send(
 
 	// Helpers to deal with current request canceling
 	var cancelReq context.CancelFunc
+	var tryTimer *time.Timer
+	stopTryTimer := func() bool {
+		return tryTimer == nil || tryTimer.Stop()
+	}
 	cancelCurrentRequest := func() {
 		if cancelReq != nil {
 			cancelReq()
@@ -614,11 +695,20 @@ func (s *httpManipulator) send(
 	}
 	defer closeCurrentBody()
 
+	// The cache key of the current request, if it can be cached,
+	// and the cached response it is conditional on, if any.
+	var cacheKey string
+	var cached *cachedResponse
+
+	// Streamed responses are decoded while they are read, so
+	// they are neither cached nor recorded.
+	_, streaming := dest.(responseDecoder)
+
 	// Function that creates a new request to avoid reusing some buffers.
 	// It also sets the current request cancel function.
 	newRequest := func() (*http.Request, error) {
 
-		req, err := http.NewRequest(method, requrl, bytes.NewBuffer(body))
+		req, err := http.NewRequest(method, tryURL, bytes.NewBuffer(body))
 		if err != nil {
 			return nil, manipulate.NewErrCannotBuildQuery(err.Error())
 		}
@@ -634,8 +724,28 @@ func (s *httpManipulator) send(
 		// We injects the header from mctx.
 		s.prepareHeaders(req, mctx)
 
-		ctx, cancel := context.WithTimeout(mctx.Context(), subContextTimeout)
-		cancelReq = cancel
+		// If we have a cache, we make the request conditional.
+		if s.cache != nil && method == http.MethodGet && !streaming {
+			cacheKey = s.cache.key(req)
+			cached = s.cache.prepare(req, cacheKey)
+		}
+
+		if !streaming {
+			ctx, cancel := context.WithTimeout(mctx.Context(), subContextTimeout)
+			cancelReq = cancel
+			return req.WithContext(ctx), nil
+		}
+
+		// The body of a streamed response is read by the decoder for as
+		// long as it takes, so the try timeout only applies until the
+		// headers of the response are received. See stopTryTimer.
+		ctx, cancel := context.WithCancel(context.WithValue(mctx.Context(), streamingKey{}, true))
+		timer := time.AfterFunc(subContextTimeout, cancel)
+		tryTimer = timer
+		cancelReq = func() {
+			timer.Stop()
+			cancel()
+		}
 
 		return req.WithContext(ctx), nil
 	}
@@ -643,6 +753,30 @@ func (s *httpManipulator) send(
 	// Main retry loop
 	for {
 
+		// We pick the endpoint to send the request to, moving
+		// to another one if the previous one could not be reached.
+		// We fail fast if the circuit breakers of all endpoints are open.
+		tryURL = requrl
+		if s.endpoints != nil {
+			if ep = s.endpoints.pick(failed); ep == nil {
+				return nil, manipulate.NewErrCannotCommunicate("circuit breaker is open")
+			}
+			tryURL = ep.rebase(requrl, s.url)
+		}
+
+		// We wait for the rate limiters to allow the request.
+		for _, l := range limiters {
+			if err := l.Wait(mctx.Context()); err != nil {
+				if lastError != nil {
+					return nil, lastError
+				}
+				return nil, manipulate.NewErrCannotCommunicate(fmt.Sprintf("rate limited: %s", err))
+			}
+		}
+
+		retryAfter = 0
+		unreachable = false
+
 		// We spawn a new request
 		request, err := newRequest()
 		if err != nil {
@@ -650,13 +784,27 @@ func (s *httpManipulator) send(
 		}
 
 		// We launch the request
-		response, err := s.client.Do(request)
+		start := time.Now()
+		response, err := s.do(mctx, request)
+		latency = time.Since(start)
+
+		// We got the headers of the response in time, or the try timer
+		// of the streamed request has canceled it, which is a timeout.
+		if !stopTryTimer() && err == nil {
+			_ = response.Body.Close() // nolint
+			response, err = nil, &url.Error{Op: request.Method, URL: tryURL, Err: context.DeadlineExceeded}
+		}
 
 		if err != nil {
 
 			// Per doc, client.Do always returns an *url.Error.
 			uerr := err.(*url.Error)
 
+			// The try timer of a streamed request cancels its context.
+			if streaming && uerr.Err == context.Canceled && mctx.Context().Err() == nil {
+				uerr.Err = context.DeadlineExceeded
+			}
+
 			// We check for constant errors.
 			switch uerr.Err {
 
@@ -664,12 +812,14 @@ func (s *httpManipulator) send(
 				if lastError == nil {
 					lastError = manipulate.NewErrCannotCommunicate(snip.Snip(err, s.currentPassword()).Error())
 				}
+				unreachable = true
 				goto RETRY
 
 			case io.ErrUnexpectedEOF, io.EOF:
 				if lastError == nil {
 					lastError = manipulate.NewErrCannotCommunicate(snip.Snip(err, s.currentPassword()).Error())
 				}
+				unreachable = true
 				goto RETRY
 			}
 
@@ -680,6 +830,7 @@ func (s *httpManipulator) send(
 				if lastError == nil {
 					lastError = manipulate.NewErrCannotCommunicate(snip.Snip(err, s.currentPassword()).Error())
 				}
+				unreachable = true
 				goto RETRY
 
 			case x509.UnknownAuthorityError, x509.CertificateInvalidError, x509.HostnameError:
@@ -694,19 +845,42 @@ func (s *httpManipulator) send(
 		// We register it so next loop will be clean.
 		bodyCloser = response.Body
 
+		// We report the status code if it has been asked for.
+		if o, ok := mctx.(opaquer); ok {
+			if status, ok := o.Opaque()[opaqueKeyResponseStatus].(*int32); ok {
+				atomic.StoreInt32(status, int32(response.StatusCode))
+			}
+		}
+
+		// If the response has not been modified, we serve it from the cache.
+		if cacheKey != "" && response.StatusCode == http.StatusNotModified {
+			closeCurrentBody()
+			if !s.cache.load(cached, response) {
+				return nil, manipulate.NewErrCannotExecuteQuery("received not modified status for a response not in cache")
+			}
+			bodyCloser = response.Body
+		}
+
 		// We check for http status codes that triggers a retry
 		switch response.StatusCode {
 
 		case http.StatusBadGateway:
 			lastError = manipulate.NewErrCannotCommunicate("Bad gateway")
+			unreachable = true
 			goto RETRY
 
 		case http.StatusServiceUnavailable:
 			lastError = manipulate.NewErrCannotCommunicate("Service unavailable")
+			unreachable = true
+			retryAfter = parseRetryAfter(response.Header.Get("Retry-After"), time.Now())
+			for _, l := range limiters {
+				l.Pause(retryAfter)
+			}
 			goto RETRY
 
 		case http.StatusGatewayTimeout:
 			lastError = manipulate.NewErrCannotCommunicate("Gateway timeout")
+			unreachable = true
 			goto RETRY
 
 		case http.StatusLocked:
@@ -715,13 +889,29 @@ func (s *httpManipulator) send(
 
 		case http.StatusRequestTimeout:
 			lastError = manipulate.NewErrCannotCommunicate("Request Timeout")
+			unreachable = true
 			goto RETRY
 
 		case http.StatusTooManyRequests:
 			lastError = manipulate.NewErrTooManyRequests("Too Many Requests")
+			retryAfter = parseRetryAfter(response.Header.Get("Retry-After"), time.Now())
+			for _, l := range limiters {
+				l.Throttled()
+				l.Pause(retryAfter)
+			}
 			goto RETRY
 		}
 
+		// We have not been throttled.
+		for _, l := range limiters {
+			l.Succeeded()
+		}
+
+		// We could reach the api.
+		if ep != nil {
+			ep.success(latency)
+		}
+
 		// We backport header info into mctx
 		s.readHeaders(response, mctx)
 
@@ -765,10 +955,26 @@ func (s *httpManipulator) send(
 			return response, nil
 		}
 
+		// If we have a cache, we store the response.
+		if cacheKey != "" && response.StatusCode == http.StatusOK {
+			if err := s.cache.store(cacheKey, response); err != nil {
+				return nil, manipulate.NewErrCannotUnmarshal(fmt.Sprintf("unable to read data: %s", err))
+			}
+			bodyCloser = response.Body
+		}
+
 		if dest == nil {
 			return response, nil
 		}
 
+		// If the dest decodes the response by itself, we let it do so.
+		if decode, ok := dest.(responseDecoder); ok {
+			if err := decode(response); err != nil {
+				return nil, err
+			}
+			return response, nil
+		}
+
 		// If we have a given dest to decode, we decode it now.
 		if err := decodeData(response, dest); err != nil {
 			return nil, err
@@ -786,13 +992,24 @@ func (s *httpManipulator) send(
 		closeCurrentBody()
 		cancelCurrentRequest()
 
+		// We report the outcome of the try to the endpoint.
+		failed = nil
+		if ep != nil {
+			if unreachable {
+				ep.failure()
+				failed = ep
+			} else {
+				ep.success(latency)
+			}
+		}
+
 		// If the manipulator has auto retry disabled we return the last error
 		if s.disableAutoRetry {
 			return nil, lastError
 		}
 
 		info := RetryInfo{
-			URL:    requrl,
+			URL:    tryURL,
 			Method: method,
 			try:    try,
 			mctx:   mctx,
@@ -810,19 +1027,35 @@ func (s *httpManipulator) send(
 			}
 		}
 
-		// We check is the main context expired.
+		// We wait for the backoff, or the delay requested by the
+		// server, and we restart the retry loop. If the main
+		// context expires in the meantime, we return the last error.
+		timer := time.NewTimer(retryDelay(backoff.Next(try, deadline), retryAfter, deadline))
 		select {
 		case <-mctx.Context().Done():
-			// If so, we return the last error
+			timer.Stop()
 			return nil, lastError
-		default:
-			// Otherwise we sleep backoff and we restart the retry loop.
-			time.Sleep(backoff.Next(try, deadline))
+		case <-timer.C:
 			try++
 		}
 	}
 }
 
+func (s *httpManipulator) rateLimitersFor(identity elemental.Identity) []*ratelimit.Limiter {
+
+	var limiters []*ratelimit.Limiter
+
+	if l, ok := s.identityRateLimiters[identity.Name]; ok {
+		limiters = append(limiters, l)
+	}
+
+	if s.rateLimiter != nil {
+		limiters = append(limiters, s.rateLimiter)
+	}
+
+	return limiters
+}
+
 func (s *httpManipulator) registerRenewNotifier(id string, f func(string)) {
 
 	s.renewNotifiersLock.Lock()
@@ -884,15 +884,89 @@ func TestHTTP_Delete(t *testing.T) {
 
 func TestHTTP_DeleteMany(t *testing.T) {
 
-	Convey("Given I have a manipulator", t, func() {
+	Convey("Given I have a manipulator and a working server", t, func() {
+
+		var method, path, query string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			method = r.Method
+			path = r.URL.Path
+			query = r.URL.RawQuery
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer ts.Close()
 
-		mm, _ := New(context.Background(), "https://fake.com")
+		mm, _ := New(context.Background(), ts.URL)
 		m := mm.(*httpManipulator)
 
-		err := m.DeleteMany(nil, testmodel.TaskIdentity)
+		Convey("When I call DeleteMany", func() {
 
-		Convey("Then err should not be nil", func() {
-			So(err, ShouldNotBeNil)
+			mctx := manipulate.NewContext(
+				context.Background(),
+				manipulate.ContextOptionFilter(elemental.NewFilterComposer().WithKey("name").Equals("a").Done()),
+				manipulate.ContextOptionRecursive(true),
+			)
+
+			err := m.DeleteMany(mctx, testmodel.TaskIdentity)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the request should be correct", func() {
+				So(method, ShouldEqual, http.MethodDelete)
+				So(path, ShouldEqual, "/tasks")
+				So(query, ShouldEqual, "q=name+%3D%3D+%22a%22&recursive=true")
+			})
+		})
+
+		Convey("When I call DeleteMany with a parent", func() {
+
+			list := testmodel.NewList()
+			list.ID = "xxx"
+
+			err := m.DeleteMany(manipulate.NewContext(context.Background(), manipulate.ContextOptionParent(list)), testmodel.TaskIdentity)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the request should be correct", func() {
+				So(method, ShouldEqual, http.MethodDelete)
+				So(path, ShouldEqual, "/lists/xxx/tasks")
+			})
+		})
+
+		Convey("When I call DeleteMany with a parent with no ID", func() {
+
+			err := m.DeleteMany(manipulate.NewContext(context.Background(), manipulate.ContextOptionParent(testmodel.NewList())), testmodel.TaskIdentity)
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err, ShouldHaveSameTypeAs, manipulate.ErrCannotBuildQuery{})
+			})
+		})
+	})
+
+	Convey("Given I have a manipulator and the server returns an error", t, func() {
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `[{"code": 403, "title": "nope", "description": "boom"}]`)
+		}))
+		defer ts.Close()
+
+		mm, _ := New(context.Background(), ts.URL)
+		m := mm.(*httpManipulator)
+
+		Convey("When I call DeleteMany", func() {
+
+			err := m.DeleteMany(nil, testmodel.TaskIdentity)
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.(elemental.Errors).Code(), ShouldEqual, http.StatusForbidden)
+			})
 		})
 	})
 }
@@ -980,7 +1054,7 @@ func TestHTTP_send(t *testing.T) {
 
 		Convey("When I call send", func() {
 
-			resp, err := m.(*httpManipulator).send(manipulate.NewContext(context.Background()), http.MethodPost, "nop", nil, nil, sp)
+			resp, err := m.(*httpManipulator).send(manipulate.NewContext(context.Background()), elemental.Identity{}, http.MethodPost, "nop", nil, nil, sp)
 
 			Convey("Then err should not be nil", func() {
 				So(err, ShouldNotBeNil)
@@ -1001,7 +1075,7 @@ func TestHTTP_send(t *testing.T) {
 			ctx, cancel := context.WithTimeout(context.Background(), 0)
 			cancel()
 
-			resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), http.MethodPost, "https://google.com", nil, nil, sp)
+			resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), elemental.Identity{}, http.MethodPost, "https://google.com", nil, nil, sp)
 
 			Convey("Then err should not be nil", func() {
 				So(err, ShouldNotBeNil)
@@ -1022,7 +1096,7 @@ func TestHTTP_send(t *testing.T) {
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 			defer cancel()
 
-			resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), http.MethodPost, "https://NANANAN", nil, nil, sp)
+			resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), elemental.Identity{}, http.MethodPost, "https://NANANAN", nil, nil, sp)
 
 			Convey("Then err should not be nil", func() {
 				So(err, ShouldNotBeNil)
@@ -1048,7 +1122,7 @@ func TestHTTP_send(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 		defer cancel()
 
-		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), http.MethodPost, ts.URL, nil, nil, sp)
+		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), elemental.Identity{}, http.MethodPost, ts.URL, nil, nil, sp)
 
 		Convey("Then err should not be nil", func() {
 			So(err, ShouldNotBeNil)
@@ -1073,7 +1147,7 @@ func TestHTTP_send(t *testing.T) {
 			ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 			defer cancel()
 
-			resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), http.MethodPost, ts.URL, nil, nil, sp)
+			resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), elemental.Identity{}, http.MethodPost, ts.URL, nil, nil, sp)
 
 			Convey("Then err should not be nil", func() {
 				So(err, ShouldNotBeNil)
@@ -1110,6 +1184,7 @@ func TestHTTP_send(t *testing.T) {
 					return nil
 				}),
 			),
+			elemental.Identity{},
 			http.MethodPost,
 			ts.URL,
 			nil,
@@ -1154,6 +1229,7 @@ func TestHTTP_send(t *testing.T) {
 					return nil
 				}),
 			),
+			elemental.Identity{},
 			http.MethodPost,
 			ts.URL,
 			nil,
@@ -1196,6 +1272,7 @@ func TestHTTP_send(t *testing.T) {
 
 		resp, err := m.(*httpManipulator).send(
 			manipulate.NewContext(ctx),
+			elemental.Identity{},
 			http.MethodPost,
 			ts.URL,
 			nil,
@@ -1241,6 +1318,7 @@ func TestHTTP_send(t *testing.T) {
 
 		resp, err := m.(*httpManipulator).send(
 			manipulate.NewContext(ctx),
+			elemental.Identity{},
 			http.MethodPost,
 			ts.URL,
 			nil,
@@ -1270,7 +1348,7 @@ func TestHTTP_send(t *testing.T) {
 		defer cancel()
 
 		resp, err := m.(*httpManipulator).send(
-			manipulate.NewContext(ctx), http.MethodPost, ts.URL, nil, nil, sp)
+			manipulate.NewContext(ctx), elemental.Identity{}, http.MethodPost, ts.URL, nil, nil, sp)
 
 		Convey("Then err should not be nil", func() {
 			So(err, ShouldNotBeNil)
@@ -1295,7 +1373,7 @@ func TestHTTP_send(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 		defer cancel()
 
-		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), http.MethodPost, ts.URL, nil, nil, sp)
+		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), elemental.Identity{}, http.MethodPost, ts.URL, nil, nil, sp)
 
 		Convey("Then err should not be nil", func() {
 			So(err, ShouldNotBeNil)
@@ -1320,7 +1398,7 @@ func TestHTTP_send(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 		defer cancel()
 
-		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), http.MethodPost, ts.URL, nil, nil, sp)
+		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), elemental.Identity{}, http.MethodPost, ts.URL, nil, nil, sp)
 
 		Convey("Then err should not be nil", func() {
 			So(err, ShouldNotBeNil)
@@ -1345,7 +1423,7 @@ func TestHTTP_send(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 		defer cancel()
 
-		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), http.MethodPost, ts.URL, nil, nil, sp)
+		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), elemental.Identity{}, http.MethodPost, ts.URL, nil, nil, sp)
 
 		Convey("Then err should not be nil", func() {
 			So(err, ShouldNotBeNil)
@@ -1370,7 +1448,7 @@ func TestHTTP_send(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 		defer cancel()
 
-		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), http.MethodPost, ts.URL, nil, nil, sp)
+		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), elemental.Identity{}, http.MethodPost, ts.URL, nil, nil, sp)
 
 		Convey("Then err should not be nil", func() {
 			So(err, ShouldNotBeNil)
@@ -1395,7 +1473,7 @@ func TestHTTP_send(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 		defer cancel()
 
-		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), http.MethodPost, ts.URL, nil, nil, sp)
+		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), elemental.Identity{}, http.MethodPost, ts.URL, nil, nil, sp)
 
 		Convey("Then err should not be nil", func() {
 			So(err, ShouldNotBeNil)
@@ -1420,7 +1498,7 @@ func TestHTTP_send(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
 
-		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), http.MethodPost, ts.URL, nil, nil, sp)
+		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), elemental.Identity{}, http.MethodPost, ts.URL, nil, nil, sp)
 
 		Convey("Then err should not be nil", func() {
 			So(err, ShouldNotBeNil)
@@ -1464,19 +1542,19 @@ func TestHTTP_send(t *testing.T) {
 		var eg errgroup.Group
 
 		eg.Go(func() error {
-			_, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), http.MethodPost, ts.URL, nil, nil, sp)
+			_, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), elemental.Identity{}, http.MethodPost, ts.URL, nil, nil, sp)
 			return err
 		})
 		eg.Go(func() error {
-			_, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), http.MethodPost, ts.URL, nil, nil, sp)
+			_, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), elemental.Identity{}, http.MethodPost, ts.URL, nil, nil, sp)
 			return err
 		})
 		eg.Go(func() error {
-			_, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), http.MethodPost, ts.URL, nil, nil, sp)
+			_, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), elemental.Identity{}, http.MethodPost, ts.URL, nil, nil, sp)
 			return err
 		})
 		eg.Go(func() error {
-			_, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), http.MethodPost, ts.URL, nil, nil, sp)
+			_, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), elemental.Identity{}, http.MethodPost, ts.URL, nil, nil, sp)
 			return err
 		})
 
@@ -1515,7 +1593,7 @@ func TestHTTP_send(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 		defer cancel()
 
-		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), http.MethodPost, ts.URL, nil, nil, sp)
+		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), elemental.Identity{}, http.MethodPost, ts.URL, nil, nil, sp)
 
 		Convey("Then err should be nil", func() {
 			So(err, ShouldNotBeNil)
@@ -1550,7 +1628,7 @@ func TestHTTP_send(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
 
-		_, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), http.MethodPost, ts.URL, nil, nil, sp)
+		_, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), elemental.Identity{}, http.MethodPost, ts.URL, nil, nil, sp)
 
 		Convey("Then err should be not nil", func() {
 			So(err, ShouldNotBeNil)
@@ -1575,7 +1653,7 @@ func TestHTTP_send(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 		defer cancel()
 
-		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), http.MethodPost, ts.URL, nil, nil, sp)
+		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), elemental.Identity{}, http.MethodPost, ts.URL, nil, nil, sp)
 
 		Convey("Then err should not be nil", func() {
 			So(err, ShouldNotBeNil)
@@ -1600,7 +1678,7 @@ func TestHTTP_send(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
 
-		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), http.MethodPost, ts.URL, nil, nil, sp)
+		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), elemental.Identity{}, http.MethodPost, ts.URL, nil, nil, sp)
 
 		Convey("Then err should not be nil", func() {
 			So(err, ShouldNotBeNil)
@@ -1625,7 +1703,7 @@ func TestHTTP_send(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
 
-		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), http.MethodPost, ts.URL, nil, nil, sp)
+		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), elemental.Identity{}, http.MethodPost, ts.URL, nil, nil, sp)
 
 		Convey("Then err should not be nil", func() {
 			So(err, ShouldNotBeNil)
@@ -1652,7 +1730,7 @@ func TestHTTP_send(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
 
-		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), http.MethodPost, ts.URL, nil, nil, sp)
+		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), elemental.Identity{}, http.MethodPost, ts.URL, nil, nil, sp)
 
 		Convey("Then err should not be nil", func() {
 			So(err, ShouldNotBeNil)
@@ -1677,7 +1755,7 @@ func TestHTTP_send(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
 
-		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), http.MethodPost, ts.URL, nil, nil, sp)
+		resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), elemental.Identity{}, http.MethodPost, ts.URL, nil, nil, sp)
 
 		Convey("Then err should be nil", func() {
 			So(err, ShouldBeNil)
@@ -1686,6 +1764,79 @@ func TestHTTP_send(t *testing.T) {
 	})
 }
 
+func TestHTTP_sendRateLimit(t *testing.T) {
+
+	sp := tracing.StartTrace(nil, "test")
+	defer sp.Finish()
+
+	Convey("Given I have a manipulator with a rate limiter and a server asking to retry later", t, func() {
+
+		var calls int32
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer ts.Close()
+
+		m, _ := New(context.Background(), ts.URL, OptionRateLimit(100, 10))
+
+		Convey("When I send a request", func() {
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			start := time.Now()
+			resp, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), testmodel.ListIdentity, http.MethodGet, ts.URL, nil, nil, sp)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusNoContent)
+			})
+
+			Convey("Then the retry should have honored Retry-After", func() {
+				So(atomic.LoadInt32(&calls), ShouldEqual, 2)
+				So(time.Since(start), ShouldBeGreaterThanOrEqualTo, time.Second)
+			})
+		})
+	})
+}
+
+func TestHTTP_sendRetryAfterCanceled(t *testing.T) {
+
+	sp := tracing.StartTrace(nil, "test")
+	defer sp.Finish()
+
+	Convey("Given I have a server asking to retry in an hour", t, func() {
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer ts.Close()
+
+		m, _ := New(context.Background(), ts.URL)
+
+		Convey("When I send a request and cancel its context", func() {
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(200*time.Millisecond, cancel)
+
+			start := time.Now()
+			_, err := m.(*httpManipulator).send(manipulate.NewContext(ctx), testmodel.ListIdentity, http.MethodGet, ts.URL, nil, nil, sp)
+
+			Convey("Then the wait should have been interrupted", func() {
+				So(err, ShouldNotBeNil)
+				So(time.Since(start), ShouldBeLessThan, 5*time.Second)
+			})
+		})
+	})
+}
+
 func TestHTTP_makeAuthorizationHeaders(t *testing.T) {
 
 	Convey("Given I create a new HTTP manipulator", t, func() {
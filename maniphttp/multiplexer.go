@@ -0,0 +1,616 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maniphttp
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/manipulate"
+)
+
+const (
+	sharedEventsChSize = 2048
+	sharedErrorsChSize = 64
+	sharedStatusChSize = 8
+)
+
+// A SubscriberManager creates subscribers sharing one connection
+// per endpoint and namespace. The union of the push configs of the
+// subscribers is sent upstream, and each event is forwarded to the
+// subscribers whose push config matches its identity and type.
+//
+// The connection is opened when the first subscriber starts, using
+// the options of the first subscriber created for this endpoint and
+// namespace, and closed when the context of the last one is done. The
+// next subscriber created for them then opens a new connection.
+// Only the identities and event types of the push configs are sent
+// upstream, with the parameters if all the subscribers use the same.
+// The per-identity filters are evaluated locally for each subscriber.
+type SubscriberManager struct {
+	manipulator manipulate.Manipulator
+	connections map[string]*sharedConnection
+	lock        sync.Mutex
+}
+
+// NewSubscriberManager returns a new SubscriberManager
+// creating subscribers for the given manipulator.
+// Note: the given manipulator must be an HTTP Manipulator or it will panic.
+func NewSubscriberManager(manipulator manipulate.Manipulator) *SubscriberManager {
+
+	if _, ok := manipulator.(*httpManipulator); !ok {
+		panic("You can only pass a HTTP Manipulator to NewSubscriberManager")
+	}
+
+	return &SubscriberManager{
+		manipulator: manipulator,
+		connections: map[string]*sharedConnection{},
+	}
+}
+
+// NewSubscriber returns a new subscriber sharing its connection
+// with the other subscribers of the same endpoint and namespace.
+// The channel sizes of the returned subscriber are set by
+// SubscriberOptionEventsChannelSize and SubscriberOptionErrorsChannelSize.
+func (m *SubscriberManager) NewSubscriber(options ...SubscriberOption) manipulate.Subscriber {
+
+	cfg := newSubscribeConfig(m.manipulator.(*httpManipulator))
+	for _, opt := range options {
+		if opt == nil {
+			panic("nil passed as subscriber option")
+		}
+		opt(&cfg)
+	}
+
+	key := fmt.Sprintf("%s|%s|%t|%t", cfg.endpoint, cfg.namespace, cfg.recursive, cfg.supportErrorEvents)
+
+	m.lock.Lock()
+	conn, ok := m.connections[key]
+	if !ok {
+		conn = newSharedConnection(func() manipulate.Subscriber { return NewSubscriber(m.manipulator, options...) })
+		conn.onOpen = func() { m.register(key, conn) }
+		conn.onClose = func() { m.unregister(key, conn) }
+		m.connections[key] = conn
+	}
+	m.lock.Unlock()
+
+	eventsChSize := cfg.queueConfig.EventsChSize
+	if eventsChSize <= 0 {
+		eventsChSize = sharedEventsChSize
+	}
+
+	errorsChSize := cfg.queueConfig.ErrorsChSize
+	if errorsChSize <= 0 {
+		errorsChSize = sharedErrorsChSize
+	}
+
+	return &sharedSubscriber{
+		conn:   conn,
+		events: make(chan *elemental.Event, eventsChSize),
+		errors: make(chan error, errorsChSize),
+		status: make(chan manipulate.SubscriberStatus, sharedStatusChSize),
+	}
+}
+
+// register adds the given connection for the given key,
+// unless another one has been added since it was removed.
+func (m *SubscriberManager) register(key string, conn *sharedConnection) {
+
+	m.lock.Lock()
+	if _, ok := m.connections[key]; !ok {
+		m.connections[key] = conn
+	}
+	m.lock.Unlock()
+}
+
+// unregister removes the given connection for the given key.
+func (m *SubscriberManager) unregister(key string, conn *sharedConnection) {
+
+	m.lock.Lock()
+	if m.connections[key] == conn {
+		delete(m.connections, key)
+	}
+	m.lock.Unlock()
+}
+
+// sharedConnection is an upstream subscriber
+// shared by several sharedSubscribers.
+type sharedConnection struct {
+	newUpstream func() manipulate.Subscriber
+	upstream    manipulate.Subscriber
+	next        manipulate.Subscriber
+	gaps        bool
+	onOpen      func()
+	onClose     func()
+	cancel      context.CancelFunc
+	connected   bool
+	subscribers map[*sharedSubscriber]struct{}
+	lock        sync.RWMutex
+}
+
+// newSharedConnection returns a new sharedConnection using upstream
+// subscribers created by the given function. The first one is created
+// right away, without being started, to know if it detects gaps.
+func newSharedConnection(newUpstream func() manipulate.Subscriber) *sharedConnection {
+
+	c := &sharedConnection{
+		newUpstream: newUpstream,
+		subscribers: map[*sharedSubscriber]struct{}{},
+	}
+
+	c.prepare()
+
+	return c
+}
+
+// prepare creates the next upstream subscriber, and
+// records if it detects gaps. The lock must be held.
+func (c *sharedConnection) prepare() {
+
+	c.next = c.newUpstream()
+
+	s, ok := c.next.(manipulate.GapDetectingSubscriber)
+	c.gaps = ok && s.DetectsGaps()
+}
+
+// join adds the given subscriber, and starts
+// the upstream subscriber if needed.
+func (c *sharedConnection) join(s *sharedSubscriber) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.subscribers[s] = struct{}{}
+
+	if c.upstream != nil {
+		c.upstream.UpdateFilter(c.filter())
+		if c.connected {
+			s.publishStatus(manipulate.SubscriberStatusInitialConnection)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if c.next == nil {
+		c.prepare()
+	}
+
+	c.upstream = c.next
+	c.next = nil
+	c.cancel = cancel
+	c.connected = false
+
+	if c.onOpen != nil {
+		c.onOpen()
+	}
+
+	go c.listen(ctx, c.upstream)
+
+	c.upstream.Start(ctx, c.filter())
+}
+
+// leave removes the given subscriber, and stops
+// the upstream subscriber if it was the last one.
+func (c *sharedConnection) leave(s *sharedSubscriber) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.subscribers[s]; !ok {
+		return
+	}
+
+	delete(c.subscribers, s)
+
+	if len(c.subscribers) > 0 {
+		c.upstream.UpdateFilter(c.filter())
+		return
+	}
+
+	c.cancel()
+	c.upstream = nil
+	c.cancel = nil
+	c.connected = false
+
+	if c.onClose != nil {
+		c.onClose()
+	}
+}
+
+// detectsGaps returns true if the upstream subscriber detects gaps.
+func (c *sharedConnection) detectsGaps() bool {
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.gaps
+}
+
+// update sends the union of the push configs upstream.
+func (c *sharedConnection) update() {
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if c.upstream != nil {
+		c.upstream.UpdateFilter(c.filter())
+	}
+}
+
+// filter returns the union of the push configs of the subscribers.
+// The lock must be held.
+func (c *sharedConnection) filter() *elemental.PushConfig {
+
+	filters := make([]*elemental.PushConfig, 0, len(c.subscribers))
+	for s := range c.subscribers {
+		filters = append(filters, s.getFilter())
+	}
+
+	return unionPushConfigs(filters)
+}
+
+// listen forwards what the given upstream subscriber
+// publishes to the subscribers until the given context is done.
+func (c *sharedConnection) listen(ctx context.Context, upstream manipulate.Subscriber) {
+
+	for {
+		select {
+
+		case evt := <-upstream.Events():
+
+			c.lock.RLock()
+			for s := range c.subscribers {
+				s.publishEvent(evt)
+			}
+			c.lock.RUnlock()
+
+		case err := <-upstream.Errors():
+
+			c.lock.RLock()
+			for s := range c.subscribers {
+				s.publishError(err)
+			}
+			c.lock.RUnlock()
+
+		case st := <-upstream.Status():
+
+			// The subscribers have their own final disconnection
+			// when their context is done.
+			if st == manipulate.SubscriberStatusFinalDisconnection {
+				continue
+			}
+
+			c.lock.Lock()
+			switch st {
+			case manipulate.SubscriberStatusInitialConnection, manipulate.SubscriberStatusReconnection:
+				c.connected = true
+			case manipulate.SubscriberStatusDisconnection:
+				c.connected = false
+			}
+			for s := range c.subscribers {
+				s.publishStatus(st)
+			}
+			c.lock.Unlock()
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sharedSubscriber is a manipulate.Subscriber
+// using a sharedConnection.
+type sharedSubscriber struct {
+	conn            *sharedConnection
+	filter          *elemental.PushConfig
+	identityFilters map[string]*elemental.Filter
+	started         bool
+	dropped         map[string]int
+	events          chan *elemental.Event
+	errors          chan error
+	status          chan manipulate.SubscriberStatus
+	lock            sync.RWMutex
+}
+
+func (s *sharedSubscriber) Events() chan *elemental.Event            { return s.events }
+func (s *sharedSubscriber) Errors() chan error                       { return s.errors }
+func (s *sharedSubscriber) Status() chan manipulate.SubscriberStatus { return s.status }
+
+// DetectsGaps returns true if the shared connection detects gaps.
+func (s *sharedSubscriber) DetectsGaps() bool {
+	return s.conn.detectsGaps()
+}
+
+func (s *sharedSubscriber) Start(ctx context.Context, filter *elemental.PushConfig) {
+
+	s.lock.Lock()
+	if filter != nil {
+		s.setFilter(filter)
+	}
+	s.started = true
+	s.lock.Unlock()
+
+	s.conn.join(s)
+
+	go func() {
+		<-ctx.Done()
+		s.conn.leave(s)
+		s.publishStatus(manipulate.SubscriberStatusFinalDisconnection)
+	}()
+}
+
+func (s *sharedSubscriber) UpdateFilter(filter *elemental.PushConfig) {
+
+	s.lock.Lock()
+	s.setFilter(filter)
+	started := s.started
+	s.lock.Unlock()
+
+	if started {
+		s.conn.update()
+	}
+}
+
+// Stats returns the statistics of the shared connection, with the
+// push config of the subscriber. The events dropped because the
+// events channel of the subscriber was full are added to the ones
+// dropped by the shared connection.
+func (s *sharedSubscriber) Stats() manipulate.SubscriberStats {
+
+	var out manipulate.SubscriberStats
+
+	s.conn.lock.RLock()
+	if upstream, ok := s.conn.upstream.(manipulate.StatsReportingSubscriber); ok {
+		out = upstream.Stats()
+	}
+	s.conn.lock.RUnlock()
+
+	dropped := make(map[string]int, len(out.EventsDropped))
+	for identity, n := range out.EventsDropped {
+		dropped[identity] = n
+	}
+
+	s.lock.RLock()
+	for identity, n := range s.dropped {
+		dropped[identity] += n
+	}
+	out.Filter = s.filter
+	s.lock.RUnlock()
+
+	out.EventsDropped = dropped
+
+	return out
+}
+
+// setFilter sets the push config of the subscriber,
+// and parses its per-identity filters. The lock must be held.
+func (s *sharedSubscriber) setFilter(filter *elemental.PushConfig) {
+
+	s.filter = filter
+	s.identityFilters = nil
+
+	if filter == nil {
+		return
+	}
+
+	for identity, expr := range filter.IdentityFilters {
+
+		if expr == "" {
+			continue
+		}
+
+		f, err := elemental.NewFilterFromString(expr)
+		if err != nil {
+			s.publishError(fmt.Errorf("invalid filter for identity '%s': %s", identity, err))
+			continue
+		}
+
+		if s.identityFilters == nil {
+			s.identityFilters = map[string]*elemental.Filter{}
+		}
+		s.identityFilters[identity] = f
+	}
+}
+
+func (s *sharedSubscriber) getFilter() *elemental.PushConfig {
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.filter
+}
+
+// accepts returns true if the given event matches
+// the push config of the subscriber.
+func (s *sharedSubscriber) accepts(evt *elemental.Event) bool {
+
+	s.lock.RLock()
+	filter := s.filter
+	identityFilter := s.identityFilters[evt.Identity]
+	s.lock.RUnlock()
+
+	if filter == nil {
+		return true
+	}
+
+	if filter.IsFilteredOut(evt.Identity, evt.Type) {
+		return false
+	}
+
+	if identityFilter == nil {
+		return true
+	}
+
+	obj := map[string]interface{}{}
+	if err := evt.Decode(&obj); err != nil {
+		return false
+	}
+
+	return matchesFilter(obj, identityFilter)
+}
+
+func (s *sharedSubscriber) publishEvent(evt *elemental.Event) {
+
+	if !s.accepts(evt) {
+		return
+	}
+
+	select {
+	case s.events <- evt.Duplicate():
+	default:
+		s.lock.Lock()
+		if s.dropped == nil {
+			s.dropped = map[string]int{}
+		}
+		s.dropped[evt.Identity]++
+		s.lock.Unlock()
+		s.publishError(fmt.Errorf("unable to forward event: channel full"))
+	}
+}
+
+func (s *sharedSubscriber) publishError(err error) {
+	select {
+	case s.errors <- err:
+	default:
+	}
+}
+
+func (s *sharedSubscriber) publishStatus(st manipulate.SubscriberStatus) {
+
+	for {
+		select {
+		case s.status <- st:
+			return
+		default:
+		}
+
+		// The shared connection cannot wait for a subscriber, but
+		// a gap must not be dropped, so we drop the oldest status.
+		if st != manipulate.SubscriberStatusGapDetected {
+			return
+		}
+
+		select {
+		case <-s.status:
+		default:
+		}
+	}
+}
+
+// unionPushConfigs returns a push config matching the events
+// matched by any of the given ones. A nil push config, or one
+// without identities, matches all the events. The per-identity
+// filters are not part of the union, and the parameters are only
+// kept if all the given push configs have the same.
+func unionPushConfigs(filters []*elemental.PushConfig) *elemental.PushConfig {
+
+	out := unionIdentities(filters)
+
+	if params, ok := commonParameters(filters); ok {
+		for k, vs := range params {
+			for _, v := range vs {
+				out.SetParameter(k, v)
+			}
+		}
+	}
+
+	return out
+}
+
+// commonParameters returns the parameters of the
+// given push configs if they all have the same.
+func commonParameters(filters []*elemental.PushConfig) (url.Values, bool) {
+
+	var params url.Values
+
+	for i, f := range filters {
+
+		var p url.Values
+		if f != nil {
+			p = f.Parameters()
+		}
+
+		if i == 0 {
+			params = p
+			continue
+		}
+
+		if p.Encode() != params.Encode() {
+			return nil, false
+		}
+	}
+
+	return params, len(params) > 0
+}
+
+// unionIdentities returns a push config matching the identities
+// and event types matched by any of the given ones.
+func unionIdentities(filters []*elemental.PushConfig) *elemental.PushConfig {
+
+	out := elemental.NewPushConfig()
+
+	if len(filters) == 0 {
+		return out
+	}
+
+	identities := map[string]map[elemental.EventType]struct{}{}
+
+	for _, f := range filters {
+
+		if f == nil || len(f.Identities) == 0 {
+			return elemental.NewPushConfig()
+		}
+
+		for identity, types := range f.Identities {
+
+			current, ok := identities[identity]
+
+			// An empty list of types means all of them.
+			if len(types) == 0 {
+				identities[identity] = nil
+				continue
+			}
+
+			if ok && current == nil {
+				continue
+			}
+
+			if current == nil {
+				current = map[elemental.EventType]struct{}{}
+				identities[identity] = current
+			}
+
+			for _, t := range types {
+				current[t] = struct{}{}
+			}
+		}
+	}
+
+	for identity, types := range identities {
+
+		sorted := make([]elemental.EventType, 0, len(types))
+		for t := range types {
+			sorted = append(sorted, t)
+		}
+
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+		out.FilterIdentity(identity, sorted...)
+	}
+
+	return out
+}
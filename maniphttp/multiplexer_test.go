@@ -0,0 +1,436 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maniphttp
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	testmodel "go.aporeto.io/elemental/test/model"
+	"go.aporeto.io/manipulate"
+	"go.aporeto.io/manipulate/maniptest"
+)
+
+func TestSubscriberManager_New(t *testing.T) {
+
+	Convey("Given I have a non http manipulator", t, func() {
+		So(func() { NewSubscriberManager(maniptest.NewTestManipulator()) }, ShouldPanicWith, "You can only pass a HTTP Manipulator to NewSubscriberManager")
+	})
+
+	Convey("Given I have a subscriber manager", t, func() {
+
+		sm := NewSubscriberManager(&httpManipulator{url: "https://toto.com", namespace: "/ns"})
+
+		Convey("When I create subscribers for the same endpoint and namespace", func() {
+
+			s1 := sm.NewSubscriber().(*sharedSubscriber)
+			s2 := sm.NewSubscriber(SubscriberOptionEventsChannelSize(10)).(*sharedSubscriber)
+			s3 := sm.NewSubscriber(SubscriberOptionNamespace("/other")).(*sharedSubscriber)
+
+			Convey("Then they should share the same connection", func() {
+				So(s1.conn, ShouldEqual, s2.conn)
+				So(s1.conn, ShouldNotEqual, s3.conn)
+				So(cap(s2.events), ShouldEqual, 10)
+			})
+		})
+
+		Convey("When the last subscriber of a connection stops", func() {
+
+			s1 := sm.NewSubscriber().(*sharedSubscriber)
+			s1.conn.next = maniptest.NewTestSubscriber()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			s1.Start(ctx, nil)
+
+			So(len(sm.connections), ShouldEqual, 1)
+
+			cancel()
+			time.Sleep(50 * time.Millisecond)
+
+			Convey("Then the connection should be removed", func() {
+				sm.lock.Lock()
+				defer sm.lock.Unlock()
+				So(len(sm.connections), ShouldEqual, 0)
+			})
+
+			Convey("Then a new subscriber should use a new connection", func() {
+				s2 := sm.NewSubscriber().(*sharedSubscriber)
+				So(s2.conn, ShouldNotEqual, s1.conn)
+			})
+		})
+
+		Convey("Then passing a nil option should panic", func() {
+			So(func() { sm.NewSubscriber(nil) }, ShouldPanicWith, "nil passed as subscriber option")
+		})
+	})
+}
+
+func TestSubscriberManager_Subscribers(t *testing.T) {
+
+	Convey("Given I have a connection shared by two subscribers", t, func() {
+
+		events := make(chan *elemental.Event, 10)
+		status := make(chan manipulate.SubscriberStatus, 10)
+
+		var lock sync.Mutex
+		var upstreamFilters []*elemental.PushConfig
+		var upstreamCtx context.Context
+		var upstreams int
+
+		upstream := maniptest.NewTestSubscriber()
+		upstream.MockEvents(t, func() chan *elemental.Event { return events })
+		upstream.MockErrors(t, func() chan error { return nil })
+		upstream.MockStatus(t, func() chan manipulate.SubscriberStatus { return status })
+		upstream.MockStart(t, func(ctx context.Context, filter *elemental.PushConfig) {
+			lock.Lock()
+			upstreamCtx = ctx
+			upstreams++
+			upstreamFilters = append(upstreamFilters, filter)
+			lock.Unlock()
+		})
+		upstream.MockUpdateFilter(t, func(filter *elemental.PushConfig) {
+			lock.Lock()
+			upstreamFilters = append(upstreamFilters, filter)
+			lock.Unlock()
+		})
+
+		conn := &sharedConnection{
+			newUpstream: func() manipulate.Subscriber { return upstream },
+			subscribers: map[*sharedSubscriber]struct{}{},
+		}
+
+		newSub := func() *sharedSubscriber {
+			return &sharedSubscriber{
+				conn:   conn,
+				events: make(chan *elemental.Event, 10),
+				errors: make(chan error, 10),
+				status: make(chan manipulate.SubscriberStatus, 10),
+			}
+		}
+
+		lastFilter := func() *elemental.PushConfig {
+			lock.Lock()
+			defer lock.Unlock()
+			return upstreamFilters[len(upstreamFilters)-1]
+		}
+
+		ctx1, cancel1 := context.WithCancel(context.Background())
+		defer cancel1()
+
+		ctx2, cancel2 := context.WithCancel(context.Background())
+		defer cancel2()
+
+		f1 := elemental.NewPushConfig()
+		f1.FilterIdentity(testmodel.ListIdentity.Name, elemental.EventCreate)
+
+		f2 := elemental.NewPushConfig()
+		f2.FilterIdentity(testmodel.ListIdentity.Name, elemental.EventDelete)
+		f2.FilterIdentity(testmodel.TaskIdentity.Name)
+
+		s1 := newSub()
+		s2 := newSub()
+
+		s1.Start(ctx1, f1)
+		status <- manipulate.SubscriberStatusInitialConnection
+		time.Sleep(50 * time.Millisecond)
+		s2.Start(ctx2, f2)
+
+		Convey("Then the upstream should have been started once with the union of the filters", func() {
+
+			So(upstreams, ShouldEqual, 1)
+			So(len(lastFilter().Identities), ShouldEqual, 2)
+			So(lastFilter().Identities[testmodel.ListIdentity.Name], ShouldResemble, []elemental.EventType{elemental.EventCreate, elemental.EventDelete})
+			So(len(lastFilter().Identities[testmodel.TaskIdentity.Name]), ShouldEqual, 0)
+		})
+
+		Convey("Then both subscribers should get the initial connection", func() {
+			So(<-s1.status, ShouldEqual, manipulate.SubscriberStatusInitialConnection)
+			So(<-s2.status, ShouldEqual, manipulate.SubscriberStatusInitialConnection)
+		})
+
+		Convey("When the upstream receives events", func() {
+
+			events <- elemental.NewEvent(elemental.EventCreate, testmodel.NewList())
+			events <- elemental.NewEvent(elemental.EventDelete, testmodel.NewList())
+			events <- elemental.NewEvent(elemental.EventUpdate, testmodel.NewTask())
+
+			time.Sleep(50 * time.Millisecond)
+
+			Convey("Then they should be fanned out to the matching subscribers", func() {
+				So(len(s1.events), ShouldEqual, 1)
+				So((<-s1.events).Type, ShouldEqual, elemental.EventCreate)
+				So(len(s2.events), ShouldEqual, 2)
+				So((<-s2.events).Type, ShouldEqual, elemental.EventDelete)
+				So((<-s2.events).Identity, ShouldEqual, testmodel.TaskIdentity.Name)
+			})
+		})
+
+		Convey("When a subscriber updates its filter", func() {
+
+			f := elemental.NewPushConfig()
+			f.FilterIdentity(testmodel.UserIdentity.Name)
+			s1.UpdateFilter(f)
+
+			Convey("Then the upstream filter should be updated", func() {
+				So(len(lastFilter().Identities), ShouldEqual, 3)
+			})
+		})
+
+		Convey("When the first subscriber stops", func() {
+
+			cancel1()
+			time.Sleep(50 * time.Millisecond)
+
+			Convey("Then it should get a final disconnection", func() {
+				<-s1.status
+				So(<-s1.status, ShouldEqual, manipulate.SubscriberStatusFinalDisconnection)
+			})
+
+			Convey("Then the upstream filter should not contain its filter anymore", func() {
+				So(lastFilter().Identities[testmodel.ListIdentity.Name], ShouldResemble, []elemental.EventType{elemental.EventDelete})
+			})
+
+			Convey("Then the upstream should still be running", func() {
+				So(upstreamCtx.Err(), ShouldBeNil)
+			})
+
+			Convey("When the second subscriber stops", func() {
+
+				cancel2()
+				time.Sleep(50 * time.Millisecond)
+
+				Convey("Then the upstream should be stopped", func() {
+					So(upstreamCtx.Err(), ShouldNotBeNil)
+					So(conn.upstream, ShouldBeNil)
+				})
+			})
+		})
+	})
+}
+
+func TestSubscriberManager_IdentityFilters(t *testing.T) {
+
+	Convey("Given I have a shared subscriber with an identity filter", t, func() {
+
+		f := elemental.NewPushConfig()
+		f.FilterIdentity(testmodel.ListIdentity.Name)
+		f.FilterIdentity(testmodel.TaskIdentity.Name)
+		f.IdentityFilters = map[string]string{testmodel.ListIdentity.Name: `name == "a"`}
+
+		s := &sharedSubscriber{
+			conn:   &sharedConnection{subscribers: map[*sharedSubscriber]struct{}{}},
+			events: make(chan *elemental.Event, 10),
+			errors: make(chan error, 10),
+		}
+		s.UpdateFilter(f)
+
+		Convey("When events are published", func() {
+
+			s.publishEvent(elemental.NewEvent(elemental.EventCreate, &testmodel.List{ID: "1", Name: "a"}))
+			s.publishEvent(elemental.NewEvent(elemental.EventCreate, &testmodel.List{ID: "2", Name: "b"}))
+			s.publishEvent(elemental.NewEvent(elemental.EventCreate, &testmodel.Task{ID: "3", Name: "b"}))
+
+			Convey("Then only the matching ones should be delivered", func() {
+				So(len(s.events), ShouldEqual, 2)
+				So((<-s.events).Identity, ShouldEqual, testmodel.ListIdentity.Name)
+				So((<-s.events).Identity, ShouldEqual, testmodel.TaskIdentity.Name)
+			})
+		})
+
+		Convey("Then the union sent upstream should not contain the filter", func() {
+			out := unionPushConfigs([]*elemental.PushConfig{f})
+			So(len(out.IdentityFilters), ShouldEqual, 0)
+			So(len(out.Identities), ShouldEqual, 2)
+		})
+	})
+
+	Convey("Given I have a shared subscriber with an invalid identity filter", t, func() {
+
+		f := elemental.NewPushConfig()
+		f.IdentityFilters = map[string]string{testmodel.ListIdentity.Name: `name ==`}
+
+		s := &sharedSubscriber{
+			conn:   &sharedConnection{subscribers: map[*sharedSubscriber]struct{}{}},
+			events: make(chan *elemental.Event, 10),
+			errors: make(chan error, 10),
+		}
+		s.UpdateFilter(f)
+
+		Convey("Then an error should be published", func() {
+			So(len(s.errors), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestSubscriberManager_DetectsGaps(t *testing.T) {
+
+	Convey("Given I have a shared subscriber on a connection to a push subscriber", t, func() {
+
+		sm := NewSubscriberManager(&httpManipulator{url: "https://toto.com", namespace: "/ns"})
+		s := sm.NewSubscriber()
+
+		Convey("Then it should be a gap detecting subscriber", func() {
+			gs, ok := s.(manipulate.GapDetectingSubscriber)
+			So(ok, ShouldBeTrue)
+			So(gs.DetectsGaps(), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given I have a shared subscriber on a connection to a subscriber that does not detect gaps", t, func() {
+
+		var created int
+
+		s := &sharedSubscriber{
+			conn: newSharedConnection(func() manipulate.Subscriber {
+				created++
+				return maniptest.NewTestSubscriber()
+			}),
+		}
+
+		Convey("Then it should not detect gaps", func() {
+			So(s.DetectsGaps(), ShouldBeFalse)
+			So(s.DetectsGaps(), ShouldBeFalse)
+		})
+
+		Convey("Then only one upstream subscriber should have been created", func() {
+			_ = s.DetectsGaps()
+			So(created, ShouldEqual, 1)
+		})
+	})
+}
+
+func TestSubscriberManager_Status(t *testing.T) {
+
+	Convey("Given I have a shared subscriber with a full status channel", t, func() {
+
+		s := &sharedSubscriber{
+			conn:   &sharedConnection{subscribers: map[*sharedSubscriber]struct{}{}},
+			status: make(chan manipulate.SubscriberStatus, 2),
+		}
+
+		s.publishStatus(manipulate.SubscriberStatusDisconnection)
+		s.publishStatus(manipulate.SubscriberStatusReconnection)
+
+		Convey("When a status is published", func() {
+
+			s.publishStatus(manipulate.SubscriberStatusTokenRenewal)
+
+			Convey("Then it should be dropped", func() {
+				So(<-s.status, ShouldEqual, manipulate.SubscriberStatusDisconnection)
+				So(<-s.status, ShouldEqual, manipulate.SubscriberStatusReconnection)
+			})
+		})
+
+		Convey("When a gap is published", func() {
+
+			s.publishStatus(manipulate.SubscriberStatusGapDetected)
+
+			Convey("Then the oldest status should be dropped instead", func() {
+				So(<-s.status, ShouldEqual, manipulate.SubscriberStatusReconnection)
+				So(<-s.status, ShouldEqual, manipulate.SubscriberStatusGapDetected)
+			})
+		})
+	})
+}
+
+func TestSubscriberManager_Stats(t *testing.T) {
+
+	Convey("Given I have a shared subscriber that is not started", t, func() {
+
+		filter := elemental.NewPushConfig()
+
+		s := &sharedSubscriber{
+			conn:   &sharedConnection{subscribers: map[*sharedSubscriber]struct{}{}},
+			filter: filter,
+		}
+
+		Convey("Then its stats should contain its filter", func() {
+			st := s.Stats()
+			So(st.Filter, ShouldEqual, filter)
+			So(st.ConnectedSince.IsZero(), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given I have a shared subscriber with a full events channel", t, func() {
+
+		s := &sharedSubscriber{
+			conn:   &sharedConnection{subscribers: map[*sharedSubscriber]struct{}{}},
+			events: make(chan *elemental.Event, 1),
+			errors: make(chan error, 10),
+		}
+
+		Convey("When events are published", func() {
+
+			s.publishEvent(elemental.NewEvent(elemental.EventCreate, &testmodel.List{ID: "1"}))
+			s.publishEvent(elemental.NewEvent(elemental.EventCreate, &testmodel.List{ID: "2"}))
+			s.publishEvent(elemental.NewEvent(elemental.EventCreate, &testmodel.Task{ID: "3"}))
+
+			Convey("Then its stats should contain the dropped events", func() {
+				st := s.Stats()
+				So(st.EventsDropped, ShouldResemble, map[string]int{
+					testmodel.ListIdentity.Name: 1,
+					testmodel.TaskIdentity.Name: 1,
+				})
+				So(len(s.errors), ShouldEqual, 2)
+			})
+		})
+	})
+}
+
+func Test_unionPushConfigs(t *testing.T) {
+
+	Convey("Given I have some push configs", t, func() {
+
+		f1 := elemental.NewPushConfig()
+		f1.FilterIdentity("a", elemental.EventCreate)
+		f1.FilterIdentity("b", elemental.EventCreate)
+
+		f2 := elemental.NewPushConfig()
+		f2.FilterIdentity("a", elemental.EventUpdate)
+		f2.FilterIdentity("b")
+
+		Convey("Then the union should be correct", func() {
+			out := unionPushConfigs([]*elemental.PushConfig{f1, f2})
+			So(out.Identities["a"], ShouldResemble, []elemental.EventType{elemental.EventCreate, elemental.EventUpdate})
+			So(len(out.Identities["b"]), ShouldEqual, 0)
+			So(len(out.Identities), ShouldEqual, 2)
+		})
+
+		Convey("Then the union with a nil push config should match everything", func() {
+			out := unionPushConfigs([]*elemental.PushConfig{f1, nil})
+			So(len(out.Identities), ShouldEqual, 0)
+		})
+
+		Convey("Then the union of nothing should match everything", func() {
+			out := unionPushConfigs(nil)
+			So(len(out.Identities), ShouldEqual, 0)
+		})
+
+		Convey("Then the union should keep the parameters they all have", func() {
+			f1.SetParameter("p", "1")
+			f2.SetParameter("p", "1")
+			out := unionPushConfigs([]*elemental.PushConfig{f1, f2})
+			So(out.Parameters().Get("p"), ShouldEqual, "1")
+		})
+
+		Convey("Then the union should drop the parameters they do not all have", func() {
+			f1.SetParameter("p", "1")
+			f2.SetParameter("p", "2")
+			out := unionPushConfigs([]*elemental.PushConfig{f1, f2})
+			So(len(out.Parameters()), ShouldEqual, 0)
+		})
+	})
+}
@@ -18,6 +18,7 @@ import (
 
 	"go.aporeto.io/elemental"
 	"go.aporeto.io/manipulate"
+	"go.aporeto.io/manipulate/maniphttp/internal/ratelimit"
 )
 
 // An Option represents a maniphttp.Manipulator option.
@@ -164,3 +165,180 @@ func OptionTCPUserTimeout(t time.Duration) Option {
 		m.tcpUserTimeout = t
 	}
 }
+
+// OptionBulkEndpoint sets the endpoint of the API used by Batch
+// to send multiple operations in a single request.
+// The default is "_bulk". If empty, Batch will always run the
+// operations sequentially.
+func OptionBulkEndpoint(endpoint string) Option {
+	return func(m *httpManipulator) {
+		m.bulkEndpoint = endpoint
+	}
+}
+
+// OptionResponseCache enables the caching of the responses of
+// Retrieve and RetrieveMany operations, up to the given number of
+// responses. Least recently used responses are evicted first.
+//
+// Responses are cached per URL, namespace and credentials when the API
+// returns an ETag or a Last-Modified header. The cached responses are then
+// revalidated using If-None-Match and If-Modified-Since, and are served
+// from the cache when the API returns 304 Not Modified. The responses
+// of RetrieveManyStream are never cached.
+func OptionResponseCache(size int) Option {
+
+	if size <= 0 {
+		panic("response cache size must be greater than 0")
+	}
+
+	return func(m *httpManipulator) {
+		m.cache = newResponseCache(size, defaultResponseCacheMaxBytes)
+	}
+}
+
+// OptionResponseCacheMaxBytes sets the maximum size of the response
+// bodies held by the cache enabled with OptionResponseCache. Least
+// recently used responses are evicted first, and responses larger
+// than the maximum size are not cached. The default is 32MiB.
+// It requires OptionResponseCache, or New will panic.
+func OptionResponseCacheMaxBytes(maxBytes int64) Option {
+
+	if maxBytes <= 0 {
+		panic("response cache max bytes must be greater than 0")
+	}
+
+	return func(m *httpManipulator) {
+		m.cacheMaxBytes = maxBytes
+	}
+}
+
+// OptionRateLimit limits the number of requests sent by the manipulator
+// to the given number of requests per second, with the given burst.
+// Retries are subject to the limit too.
+//
+// After sustained 429 Too Many Requests responses, the limit is
+// shrunk, then it grows back to its initial value as requests succeed.
+// Requests are also delayed according to the Retry-After header.
+func OptionRateLimit(limit float64, burst int) Option {
+	return func(m *httpManipulator) {
+		m.rateLimiter = ratelimit.New(limit, burst)
+	}
+}
+
+// OptionIdentityRateLimit limits the number of requests sent by the
+// manipulator for the given identity. It works like OptionRateLimit,
+// and applies in addition to it.
+func OptionIdentityRateLimit(identity elemental.Identity, limit float64, burst int) Option {
+	return func(m *httpManipulator) {
+		if m.identityRateLimiters == nil {
+			m.identityRateLimiters = map[string]*ratelimit.Limiter{}
+		}
+		m.identityRateLimiters[identity.Name] = ratelimit.New(limit, burst)
+	}
+}
+
+// OptionCircuitBreaker enables a circuit breaker per endpoint that opens
+// after the given number of consecutive failures to communicate with it.
+// While it is open, no request is sent to the endpoint, and requests fail
+// immediately with a manipulate.ErrCannotCommunicate when the circuit
+// breakers of all endpoints are open. After the given cooldown, it lets
+// a single request probe the endpoint and closes if it succeeds.
+//
+// The state of the circuit breakers can be retrieved using
+// ExtractCircuitBreakerState and ExtractEndpointsHealth.
+func OptionCircuitBreaker(threshold int, cooldown time.Duration) Option {
+
+	if threshold < 1 {
+		panic("circuit breaker threshold must be greater than 0")
+	}
+
+	return func(m *httpManipulator) {
+		m.breakerThreshold = threshold
+		m.breakerCooldown = cooldown
+	}
+}
+
+// OptionEndpoints adds the given urls to the endpoints of the API
+// the manipulator sends the requests to. The url given to New is
+// always the first endpoint. The endpoints are selected according
+// to OptionEndpointSelection, and the requests are retried on another
+// healthy endpoint when an endpoint cannot be reached.
+//
+// The additional endpoints must be http or https urls, and share the
+// tls configuration of the manipulator. The health of the endpoints
+// can be retrieved using ExtractEndpointsHealth.
+func OptionEndpoints(urls ...string) Option {
+	return func(m *httpManipulator) {
+		for _, u := range urls {
+			if u == "" {
+				panic("empty url")
+			}
+		}
+		m.extraEndpoints = append(m.extraEndpoints, urls...)
+	}
+}
+
+// OptionEndpointSelection sets how the manipulator selects
+// the endpoint to send a request to.
+// The default is EndpointSelectionRoundRobin.
+func OptionEndpointSelection(selection EndpointSelection) Option {
+	return func(m *httpManipulator) {
+		m.endpointSelection = selection
+	}
+}
+
+// OptionInterceptors adds the given interceptors to the manipulator.
+// The interceptors are called in the given order for every request,
+// the first one being the outermost. See Interceptor for details.
+func OptionInterceptors(interceptors ...Interceptor) Option {
+	return func(m *httpManipulator) {
+		for _, i := range interceptors {
+			if i == nil {
+				panic("nil passed as interceptor")
+			}
+		}
+		m.interceptors = append(m.interceptors, interceptors...)
+	}
+}
+
+// OptionRecorder makes the manipulator record every exchange with the API
+// into a cassette at the given path. Each exchange is appended to the
+// file, which is overwritten by the first one. Only the given request
+// and response headers are recorded. If none is given, the headers
+// relevant to the manipulator are recorded, and credentials are not.
+// The cassette can then be replayed using OptionReplayer.
+// The responses of RetrieveManyStream are not recorded, as they
+// would have to be held in memory.
+func OptionRecorder(path string, headers ...string) Option {
+
+	if path == "" {
+		panic("empty cassette path")
+	}
+
+	if len(headers) == 0 {
+		headers = defaultRecordedHeaders
+	}
+
+	return func(m *httpManipulator) {
+		m.recorder = &Cassette{
+			path:    path,
+			headers: headers,
+		}
+	}
+}
+
+// OptionReplayer makes the manipulator serve the exchanges of the
+// given cassette instead of sending the requests to the API. The
+// exchanges match on method, path, query and body. If no exchange
+// matches a request, it fails with a manipulate.ErrCannotExecuteQuery.
+// It takes precedence over OptionRecorder.
+func OptionReplayer(cassette *Cassette) Option {
+
+	if cassette == nil {
+		panic("nil cassette")
+	}
+
+	return func(m *httpManipulator) {
+		m.replayer = cassette
+	}
+}
@@ -20,6 +20,7 @@ import (
 
 	. "github.com/smartystreets/goconvey/convey"
 	"go.aporeto.io/elemental"
+	testmodel "go.aporeto.io/elemental/test/model"
 	"go.aporeto.io/manipulate"
 )
 
@@ -129,4 +130,117 @@ func Test_Options(t *testing.T) {
 		OptionTCPUserTimeout(t)(m)
 		So(m.tcpUserTimeout, ShouldEqual, t)
 	})
+
+	Convey("Calling OptionBulkEndpoint should work", t, func() {
+		m := &httpManipulator{}
+		OptionBulkEndpoint("bulk")(m)
+		So(m.bulkEndpoint, ShouldEqual, "bulk")
+	})
+
+	Convey("Calling OptionResponseCache should work", t, func() {
+		m := &httpManipulator{}
+		OptionResponseCache(10)(m)
+		So(m.cache, ShouldNotBeNil)
+	})
+
+	Convey("Calling OptionResponseCacheMaxBytes should work", t, func() {
+		m := &httpManipulator{}
+		OptionResponseCacheMaxBytes(10)(m)
+		So(m.cacheMaxBytes, ShouldEqual, 10)
+	})
+
+	Convey("Calling OptionRateLimit should work", t, func() {
+		m := &httpManipulator{}
+		OptionRateLimit(10, 2)(m)
+		So(m.rateLimiter, ShouldNotBeNil)
+		So(m.rateLimiter.Limit(), ShouldEqual, 10)
+	})
+
+	Convey("Calling OptionIdentityRateLimit should work", t, func() {
+		m := &httpManipulator{}
+		OptionIdentityRateLimit(testmodel.ListIdentity, 10, 2)(m)
+		So(m.identityRateLimiters[testmodel.ListIdentity.Name], ShouldNotBeNil)
+		So(len(m.rateLimitersFor(testmodel.ListIdentity)), ShouldEqual, 1)
+		So(len(m.rateLimitersFor(testmodel.TaskIdentity)), ShouldEqual, 0)
+	})
+
+	Convey("Calling OptionCircuitBreaker should work", t, func() {
+		m := &httpManipulator{}
+		OptionCircuitBreaker(3, time.Second)(m)
+		So(m.breakerThreshold, ShouldEqual, 3)
+		So(m.breakerCooldown, ShouldEqual, time.Second)
+	})
+
+	Convey("Calling OptionEndpoints should work", t, func() {
+		m := &httpManipulator{}
+		OptionEndpoints("https://a.com", "https://b.com")(m)
+		OptionEndpoints("https://c.com")(m)
+		So(m.extraEndpoints, ShouldResemble, []string{"https://a.com", "https://b.com", "https://c.com"})
+	})
+
+	Convey("Calling OptionEndpoints with an empty url should panic", t, func() {
+		m := &httpManipulator{}
+		So(func() { OptionEndpoints("")(m) }, ShouldPanicWith, "empty url")
+	})
+
+	Convey("Calling OptionEndpointSelection should work", t, func() {
+		m := &httpManipulator{}
+		OptionEndpointSelection(EndpointSelectionLeastLatency)(m)
+		So(m.endpointSelection, ShouldEqual, EndpointSelectionLeastLatency)
+	})
+
+	Convey("Calling OptionCircuitBreaker with an invalid threshold should panic", t, func() {
+		So(func() { OptionCircuitBreaker(0, time.Second) }, ShouldPanicWith, "circuit breaker threshold must be greater than 0")
+	})
+
+	Convey("Calling OptionInterceptors should work", t, func() {
+		m := &httpManipulator{}
+		i := func(mctx manipulate.Context, req *http.Request, next RoundTripFunc) (*http.Response, error) {
+			return next(req)
+		}
+		OptionInterceptors(i, i)(m)
+		OptionInterceptors(i)(m)
+		So(len(m.interceptors), ShouldEqual, 3)
+	})
+
+	Convey("Calling OptionInterceptors with a nil interceptor should panic", t, func() {
+		m := &httpManipulator{}
+		So(func() { OptionInterceptors(nil)(m) }, ShouldPanicWith, "nil passed as interceptor")
+	})
+
+	Convey("Calling OptionRecorder should work", t, func() {
+		m := &httpManipulator{}
+		OptionRecorder("/tmp/cassette.json")(m)
+		So(m.recorder.path, ShouldEqual, "/tmp/cassette.json")
+		So(m.recorder.headers, ShouldResemble, defaultRecordedHeaders)
+	})
+
+	Convey("Calling OptionRecorder with headers should work", t, func() {
+		m := &httpManipulator{}
+		OptionRecorder("/tmp/cassette.json", "X-A")(m)
+		So(m.recorder.headers, ShouldResemble, []string{"X-A"})
+	})
+
+	Convey("Calling OptionRecorder with an empty path should panic", t, func() {
+		So(func() { OptionRecorder("") }, ShouldPanicWith, "empty cassette path")
+	})
+
+	Convey("Calling OptionReplayer should work", t, func() {
+		m := &httpManipulator{}
+		c := &Cassette{}
+		OptionReplayer(c)(m)
+		So(m.replayer, ShouldEqual, c)
+	})
+
+	Convey("Calling OptionReplayer with a nil cassette should panic", t, func() {
+		So(func() { OptionReplayer(nil) }, ShouldPanicWith, "nil cassette")
+	})
+
+	Convey("Calling OptionResponseCache with an invalid size should panic", t, func() {
+		So(func() { OptionResponseCache(0) }, ShouldPanicWith, "response cache size must be greater than 0")
+	})
+
+	Convey("Calling OptionResponseCacheMaxBytes with an invalid size should panic", t, func() {
+		So(func() { OptionResponseCacheMaxBytes(0) }, ShouldPanicWith, "response cache max bytes must be greater than 0")
+	})
 }
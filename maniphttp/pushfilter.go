@@ -0,0 +1,232 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maniphttp
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.aporeto.io/elemental"
+)
+
+// matchesFilter returns true if the given decoded object matches
+// the given filter. Keys are matched case insensitively. Unknown
+// operators and comparators never match, so the events are not
+// delivered to subscribers that should not see them.
+func matchesFilter(obj map[string]interface{}, f *elemental.Filter) bool {
+
+	attrs := make(map[string]interface{}, len(obj))
+	for k, v := range obj {
+		attrs[strings.ToLower(k)] = v
+	}
+
+	return matchesLoweredFilter(attrs, f)
+}
+
+func matchesLoweredFilter(attrs map[string]interface{}, f *elemental.Filter) bool {
+
+	for i, operator := range f.Operators() {
+
+		switch operator {
+
+		case elemental.AndOperator:
+
+			v, exists := attrs[strings.ToLower(f.Keys()[i])]
+
+			if !matchesComparator(v, exists, f.Comparators()[i], f.Values()[i]) {
+				return false
+			}
+
+		case elemental.AndFilterOperator:
+
+			for _, sub := range f.AndFilters()[i] {
+				if !matchesLoweredFilter(attrs, sub) {
+					return false
+				}
+			}
+
+		case elemental.OrFilterOperator:
+
+			matched := false
+			for _, sub := range f.OrFilters()[i] {
+				if matchesLoweredFilter(attrs, sub) {
+					matched = true
+					break
+				}
+			}
+
+			if !matched {
+				return false
+			}
+
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesComparator returns true if the given value
+// matches the given comparator and values.
+func matchesComparator(v interface{}, exists bool, comparator elemental.FilterComparator, values []interface{}) bool {
+
+	switch comparator {
+
+	case elemental.ExistsComparator:
+		return exists
+
+	case elemental.NotExistsComparator:
+		return !exists
+
+	case elemental.EqualComparator:
+		return exists && len(values) > 0 && equalValues(v, values[0])
+
+	case elemental.NotEqualComparator:
+		return !exists || len(values) == 0 || !equalValues(v, values[0])
+
+	case elemental.InComparator, elemental.ContainComparator:
+		return exists && containsAny(v, values)
+
+	case elemental.NotInComparator, elemental.NotContainComparator:
+		return !exists || !containsAny(v, values)
+
+	case elemental.GreaterComparator:
+		return exists && len(values) > 0 && compareValues(v, values[0]) > 0
+
+	case elemental.GreaterOrEqualComparator:
+		return exists && len(values) > 0 && compareValues(v, values[0]) >= 0
+
+	case elemental.LesserComparator:
+		return exists && len(values) > 0 && compareValues(v, values[0]) < 0
+
+	case elemental.LesserOrEqualComparator:
+		return exists && len(values) > 0 && compareValues(v, values[0]) <= 0
+
+	case elemental.MatchComparator:
+
+		if !exists {
+			return false
+		}
+
+		for _, value := range values {
+			re, err := regexp.Compile(fmt.Sprint(value))
+			if err != nil {
+				continue
+			}
+			for _, item := range asSlice(v) {
+				if re.MatchString(fmt.Sprint(item)) {
+					return true
+				}
+			}
+		}
+
+		return false
+
+	default:
+		return false
+	}
+}
+
+// containsAny returns true if the given value, or one of
+// its items if it is a slice, is equal to one of the values.
+func containsAny(v interface{}, values []interface{}) bool {
+
+	for _, item := range asSlice(v) {
+		for _, value := range values {
+			if equalValues(item, value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func equalValues(a interface{}, b interface{}) bool {
+
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			return fa == fb
+		}
+	}
+
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// compareValues compares the given values as numbers, as
+// times or as strings, and returns -1, 0 or 1.
+func compareValues(a interface{}, b interface{}) int {
+
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+
+	if tb, ok := b.(time.Time); ok {
+		if ta, err := time.Parse(time.RFC3339Nano, fmt.Sprint(a)); err == nil {
+			switch {
+			case ta.Before(tb):
+				return -1
+			case ta.After(tb):
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toFloat(v interface{}) (float64, bool) {
+
+	rv := reflect.ValueOf(v)
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+func asSlice(v interface{}) []interface{} {
+
+	rv := reflect.ValueOf(v)
+
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return []interface{}{v}
+	}
+
+	out := make([]interface{}, rv.Len())
+	for i := range out {
+		out[i] = rv.Index(i).Interface()
+	}
+
+	return out
+}
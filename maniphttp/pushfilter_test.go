@@ -0,0 +1,82 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maniphttp
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+)
+
+func Test_matchesFilter(t *testing.T) {
+
+	Convey("Given I have a decoded object", t, func() {
+
+		obj := map[string]interface{}{
+			"name":      "hello",
+			"namespace": "/a/b",
+			"count":     float64(3),
+			"tags":      []interface{}{"x", "y"},
+			"enabled":   true,
+		}
+
+		tests := []struct {
+			filter string
+			want   bool
+		}{
+			{`name == "hello"`, true},
+			{`name == "nope"`, false},
+			{`Name == "hello"`, true},
+			{`name != "hello"`, false},
+			{`count == 3`, true},
+			{`count > 2`, true},
+			{`count >= 4`, false},
+			{`count < 4`, true},
+			{`count <= 2`, false},
+			{`tags contains ["y"]`, true},
+			{`tags contains ["z"]`, false},
+			{`name in ["a", "hello"]`, true},
+			{`name not in ["a", "hello"]`, false},
+			{`namespace matches ["^/a"]`, true},
+			{`namespace matches ["^/c"]`, false},
+			{`description exists`, false},
+			{`description not exists`, true},
+			{`enabled == true`, true},
+			{`name == "hello" and count == 4`, false},
+			{`(name == "nope" or count == 3) and enabled == true`, true},
+		}
+
+		for _, tt := range tests {
+
+			f, err := elemental.NewFilterFromString(tt.filter)
+			So(err, ShouldBeNil)
+
+			Convey("Then "+tt.filter+" should be correctly evaluated", func() {
+				So(matchesFilter(obj, f), ShouldEqual, tt.want)
+			})
+		}
+	})
+}
+
+func Test_matchesComparator(t *testing.T) {
+
+	Convey("Given I have an unknown comparator", t, func() {
+
+		comparator := elemental.FilterComparator(-1)
+
+		Convey("Then it should not match", func() {
+			So(matchesComparator("hello", true, comparator, []interface{}{"hello"}), ShouldBeFalse)
+			So(matchesComparator(nil, false, comparator, nil), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,273 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maniphttp
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/ugorji/go/codec"
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/manipulate"
+	"go.aporeto.io/manipulate/internal/tracing"
+)
+
+// A responseDecoder can be given as dest to send
+// to decode the response by itself.
+type responseDecoder func(*http.Response) error
+
+// streamingKey is the key of the value set in the context of
+// the requests whose response is decoded by a responseDecoder.
+type streamingKey struct{}
+
+// isStreaming returns true if the response of the
+// given request is decoded by a responseDecoder.
+func isStreaming(req *http.Request) bool {
+	v, _ := req.Context().Value(streamingKey{}).(bool)
+	return v
+}
+
+// RetrieveManyStream retrieves the objects like RetrieveMany, but decodes
+// them one by one from the response, and calls the given handler with each
+// of them. The raw response and all the objects are never held in memory
+// at the same time, which makes it suitable for huge collections.
+//
+// The timeout of each try only applies until the headers of the response are
+// received: the body is then read for as long as the handler needs, within the
+// deadline of the given manipulate.Context.
+//
+// The given factory must return a new object of the identity to retrieve.
+// If the handler returns an error, the retrieval stops and the error is returned.
+// If an error occurs while the response is being read, the retrieval stops and
+// the error is returned too, and the handler is not called again. In both cases,
+// the handler has already been called with the objects decoded so far.
+// Note: the given manipulator must be an HTTP Manipulator or it will panic.
+func RetrieveManyStream(
+	manipulator manipulate.Manipulator,
+	mctx manipulate.Context,
+	factory func() elemental.Identifiable,
+	handler func(elemental.Identifiable) error,
+) error {
+
+	m, ok := manipulator.(*httpManipulator)
+	if !ok {
+		panic("You can only pass a HTTP Manipulator to RetrieveManyStream")
+	}
+
+	if factory == nil {
+		return manipulate.NewErrCannotBuildQuery("nil factory")
+	}
+
+	if handler == nil {
+		return manipulate.NewErrCannotBuildQuery("nil handler")
+	}
+
+	if mctx == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultGlobalContextTimeout)
+		defer cancel()
+		mctx = manipulate.NewContext(ctx)
+	}
+
+	sample := factory()
+
+	sp := tracing.StartTrace(mctx, fmt.Sprintf("maniphttp.retrieve_many_stream.%s", sample.Identity().Category))
+	defer sp.Finish()
+
+	url, err := m.getURLForChildrenIdentity(mctx.Parent(), sample.Identity(), sample.Version(), mctx.Version())
+	if err != nil {
+		sp.SetTag("error", true)
+		sp.LogFields(log.Error(err))
+		return manipulate.NewErrCannotBuildQuery(err.Error())
+	}
+
+	decode := responseDecoder(func(r *http.Response) error {
+		return decodeStream(r, factory, handler)
+	})
+
+	if _, err = m.send(mctx, sample.Identity(), http.MethodGet, url, nil, decode, sp); err != nil {
+		sp.SetTag("error", true)
+		sp.LogFields(log.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// decodeStream decodes the elements of the array contained in
+// the body of the given response one by one, and calls the given
+// handler with each of them.
+func decodeStream(r *http.Response, factory func() elemental.Identifiable, handler func(elemental.Identifiable) error) (err error) {
+
+	if r.Body == nil {
+		return manipulate.NewErrCannotUnmarshal("nil reader")
+	}
+
+	encoding := elemental.EncodingTypeJSON
+	if r.Header.Get("Content-Type") != "" {
+		encoding, _, err = elemental.EncodingFromHeaders(r.Header)
+		if err != nil {
+			return elemental.NewErrors(err)
+		}
+	}
+
+	var next func() ([]byte, bool, error)
+
+	switch encoding {
+	case elemental.EncodingTypeMSGPACK:
+		next, err = newMsgpackArrayReader(r.Body)
+	default:
+		next, err = newJSONArrayReader(r.Body)
+	}
+
+	if err != nil {
+		return manipulate.NewErrCannotUnmarshal(err.Error())
+	}
+
+	for {
+
+		data, ok, err := next()
+		if err != nil {
+			return manipulate.NewErrCannotUnmarshal(err.Error())
+		}
+
+		if !ok {
+			return nil
+		}
+
+		o := factory()
+		if err := elemental.Decode(encoding, data, o); err != nil {
+			return manipulate.NewErrCannotUnmarshal(fmt.Sprintf("%s. original data:\n%s", err.Error(), string(data)))
+		}
+
+		// backport all default values that are empty.
+		if a, ok := o.(elemental.AttributeSpecifiable); ok {
+			elemental.ResetDefaultForZeroValues(a)
+		}
+
+		if err := handler(o); err != nil {
+			return err
+		}
+	}
+}
+
+// newJSONArrayReader returns a function returning the
+// raw elements of the JSON array read from the given reader.
+func newJSONArrayReader(r io.Reader) (func() ([]byte, bool, error), error) {
+
+	dec := json.NewDecoder(r)
+
+	t, err := dec.Token()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read data: %s", err)
+	}
+
+	// null is decoded as an empty list.
+	if t == nil {
+		return func() ([]byte, bool, error) { return nil, false, nil }, nil
+	}
+
+	if d, ok := t.(json.Delim); !ok || d != '[' {
+		return nil, fmt.Errorf("expected an array, got '%v'", t)
+	}
+
+	return func() ([]byte, bool, error) {
+
+		if !dec.More() {
+			return nil, false, nil
+		}
+
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, false, fmt.Errorf("unable to read data: %s", err)
+		}
+
+		return raw, true, nil
+	}, nil
+}
+
+// newMsgpackArrayReader returns a function returning the
+// raw elements of the msgpack array read from the given reader.
+func newMsgpackArrayReader(r io.Reader) (func() ([]byte, bool, error), error) {
+
+	br := bufio.NewReader(r)
+
+	n, err := readMsgpackArrayLen(br)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := codec.NewDecoder(br, &codec.MsgpackHandle{})
+
+	var i uint32
+
+	return func() ([]byte, bool, error) {
+
+		if i >= n {
+			return nil, false, nil
+		}
+
+		var raw codec.Raw
+		if err := dec.Decode(&raw); err != nil {
+			return nil, false, fmt.Errorf("unable to read data: %s", err)
+		}
+
+		i++
+
+		return raw, true, nil
+	}, nil
+}
+
+// readMsgpackArrayLen reads the header of a msgpack
+// array and returns the number of elements.
+func readMsgpackArrayLen(br *bufio.Reader) (uint32, error) {
+
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, fmt.Errorf("unable to read data: %s", err)
+	}
+
+	switch {
+
+	// nil is decoded as an empty list.
+	case b == 0xc0:
+		return 0, nil
+
+	// fixarray
+	case b&0xf0 == 0x90:
+		return uint32(b & 0x0f), nil
+
+	// array 16
+	case b == 0xdc:
+		var n uint16
+		if err := binary.Read(br, binary.BigEndian, &n); err != nil {
+			return 0, fmt.Errorf("unable to read data: %s", err)
+		}
+		return uint32(n), nil
+
+	// array 32
+	case b == 0xdd:
+		var n uint32
+		if err := binary.Read(br, binary.BigEndian, &n); err != nil {
+			return 0, fmt.Errorf("unable to read data: %s", err)
+		}
+		return n, nil
+
+	default:
+		return 0, fmt.Errorf("expected an array, got type 0x%x", b)
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maniphttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	testmodel "go.aporeto.io/elemental/test/model"
+	"go.aporeto.io/manipulate"
+	"go.aporeto.io/manipulate/maniptest"
+)
+
+func TestRetrieveManyStream(t *testing.T) {
+
+	factory := func() elemental.Identifiable { return testmodel.NewList() }
+
+	Convey("Given I have a server returning json", t, func() {
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Count-Total", "3")
+			fmt.Fprint(w, `[{"ID": "1", "name": "a"}, {"ID": "2", "name": "b"}, {"ID": "3", "name": "c"}]`)
+		}))
+		defer ts.Close()
+
+		m, _ := New(context.Background(), ts.URL)
+
+		Convey("When I stream the objects", func() {
+
+			var names []string
+			mctx := manipulate.NewContext(context.Background())
+
+			err := RetrieveManyStream(m, mctx, factory, func(o elemental.Identifiable) error {
+				names = append(names, o.(*testmodel.List).Name)
+				return nil
+			})
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the handler should have been called for each object", func() {
+				So(names, ShouldResemble, []string{"a", "b", "c"})
+				So(mctx.Count(), ShouldEqual, 3)
+			})
+		})
+
+		Convey("When the handler returns an error", func() {
+
+			var calls int
+
+			err := RetrieveManyStream(m, nil, factory, func(o elemental.Identifiable) error {
+				calls++
+				return fmt.Errorf("stop")
+			})
+
+			Convey("Then the streaming should stop", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "stop")
+				So(calls, ShouldEqual, 1)
+			})
+		})
+	})
+
+	Convey("Given I have a server streaming slower than the timeout of a try", t, func() {
+
+		defer func(d time.Duration) { minContextTimeout = d }(minContextTimeout)
+		minContextTimeout = 50 * time.Millisecond
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `[{"ID": "1", "name": "a"},`)
+			w.(http.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond)
+			fmt.Fprint(w, `{"ID": "2", "name": "b"}]`)
+		}))
+		defer ts.Close()
+
+		m, _ := New(context.Background(), ts.URL)
+
+		Convey("When I stream the objects", func() {
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			// A try times out after 5s / 50 = 100ms.
+			mctx := manipulate.NewContext(ctx, manipulate.ContextOptionRetryRatio(50))
+
+			var names []string
+			err := RetrieveManyStream(m, mctx, factory, func(o elemental.Identifiable) error {
+				names = append(names, o.(*testmodel.List).Name)
+				return nil
+			})
+
+			Convey("Then the whole stream should have been read", func() {
+				So(err, ShouldBeNil)
+				So(names, ShouldResemble, []string{"a", "b"})
+			})
+		})
+	})
+
+	Convey("Given I have a manipulator with a cache and a recorder and a server using etags", t, func() {
+
+		dir, err := ioutil.TempDir("", "stream")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		path := filepath.Join(dir, "cassette.json")
+
+		var conditional int
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			if r.Header.Get("If-None-Match") != "" {
+				conditional++
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("ETag", `"v1"`)
+			fmt.Fprint(w, `[{"ID": "1", "name": "a"}]`)
+		}))
+		defer ts.Close()
+
+		m, _ := New(context.Background(), ts.URL, OptionResponseCache(10), OptionRecorder(path))
+
+		Convey("When I stream the objects twice", func() {
+
+			var n int
+			handler := func(o elemental.Identifiable) error { n++; return nil }
+
+			So(RetrieveManyStream(m, nil, factory, handler), ShouldBeNil)
+			So(RetrieveManyStream(m, nil, factory, handler), ShouldBeNil)
+
+			Convey("Then the responses should have been neither cached nor recorded", func() {
+				So(n, ShouldEqual, 2)
+				So(conditional, ShouldEqual, 0)
+				_, err := os.Stat(path)
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Given I have a server returning msgpack", t, func() {
+
+		data, err := elemental.Encode(elemental.EncodingTypeMSGPACK, testmodel.ListsList{
+			&testmodel.List{ID: "1", Name: "a"},
+			&testmodel.List{ID: "2", Name: "b"},
+		})
+		So(err, ShouldBeNil)
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/msgpack")
+			_, _ = w.Write(data)
+		}))
+		defer ts.Close()
+
+		m, _ := New(context.Background(), ts.URL, OptionEncoding(elemental.EncodingTypeMSGPACK))
+
+		Convey("When I stream the objects", func() {
+
+			var ids []string
+
+			err := RetrieveManyStream(m, nil, factory, func(o elemental.Identifiable) error {
+				ids = append(ids, o.Identifier())
+				return nil
+			})
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the handler should have been called for each object", func() {
+				So(ids, ShouldResemble, []string{"1", "2"})
+			})
+		})
+	})
+
+	Convey("Given I have a server returning something that is not an array", t, func() {
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"ID": "1"}`)
+		}))
+		defer ts.Close()
+
+		m, _ := New(context.Background(), ts.URL)
+
+		Convey("When I stream the objects", func() {
+
+			err := RetrieveManyStream(m, nil, factory, func(o elemental.Identifiable) error { return nil })
+
+			Convey("Then err should be correct", func() {
+				So(err, ShouldHaveSameTypeAs, manipulate.ErrCannotUnmarshal{})
+			})
+		})
+	})
+
+	Convey("Given I have a non http manipulator", t, func() {
+
+		m := maniptest.NewTestManipulator()
+
+		Convey("Then calling RetrieveManyStream should panic", func() {
+			So(func() { _ = RetrieveManyStream(m, nil, factory, nil) }, ShouldPanicWith, "You can only pass a HTTP Manipulator to RetrieveManyStream")
+		})
+	})
+}
+
+func Test_readMsgpackArrayLen(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    uint32
+		wantErr bool
+	}{
+		{"nil", []byte{0xc0}, 0, false},
+		{"fixarray", []byte{0x93}, 3, false},
+		{"array16", []byte{0xdc, 0x01, 0x00}, 256, false},
+		{"array32", []byte{0xdd, 0x00, 0x01, 0x00, 0x00}, 65536, false},
+		{"map", []byte{0x81}, 0, true},
+		{"truncated", []byte{0xdc, 0x01}, 0, true},
+		{"empty", []byte{}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readMsgpackArrayLen(bufio.NewReader(bytes.NewReader(tt.data)))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("readMsgpackArrayLen() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("readMsgpackArrayLen() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
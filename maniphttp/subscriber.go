@@ -16,18 +16,69 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"go.aporeto.io/manipulate"
 	"go.aporeto.io/manipulate/internal/push"
 )
 
+// A SubscriberOverflowPolicy defines what happens when the events
+// channel of a subscriber is full.
+type SubscriberOverflowPolicy int
+
+// Various values of SubscriberOverflowPolicy.
+const (
+	// SubscriberOverflowDrop drops the new event and reports an
+	// error. This is the default.
+	SubscriberOverflowDrop SubscriberOverflowPolicy = iota
+
+	// SubscriberOverflowBlock blocks until the event can be published.
+	// The subscriber stops reading from the websocket in the meantime,
+	// which backpressures the server.
+	SubscriberOverflowBlock
+
+	// SubscriberOverflowDropOldest drops the oldest event of the
+	// channel to make room for the new one, and reports an error.
+	SubscriberOverflowDropOldest
+
+	// SubscriberOverflowCoalesce keeps the events in memory until they
+	// can be published. The updates of an object waiting in memory are
+	// merged into its pending event, which keeps its type, so a create
+	// followed by updates is still published as a create. Creates and
+	// deletes are never merged.
+	SubscriberOverflowCoalesce
+)
+
+// A SubscriberTransport defines how a subscriber receives the events.
+type SubscriberTransport int
+
+// Various values of SubscriberTransport.
+const (
+	// SubscriberTransportWebsocket only uses a websocket. This is the default.
+	SubscriberTransportWebsocket SubscriberTransport = iota
+
+	// SubscriberTransportSSE only uses server-sent events. The events
+	// are received as JSON, and the filters are posted to the events
+	// endpoint.
+	SubscriberTransportSSE
+
+	// SubscriberTransportAuto uses a websocket, and falls back to
+	// server-sent events when the upgrade is refused by something
+	// that does not speak websocket, for instance a proxy stripping
+	// the Upgrade headers: when the response is a 426, or a 200
+	// without upgrade. Other errors are reported as usual.
+	SubscriberTransportAuto
+)
+
 type subscribeConfig struct {
 	namespace           string
 	credentialCookieKey string
+	credentialsAsHeader bool
 	endpoint            string
 	supportErrorEvents  bool
 	recursive           bool
 	tlsConfig           *tls.Config
+	queueConfig         push.Config
 }
 
 func newSubscribeConfig(m *httpManipulator) subscribeConfig {
@@ -71,6 +122,102 @@ func SubscriberOptionEndpoint(endpoint string) SubscriberOption {
 func SubscriberSendCredentialsAsCookie(key string) SubscriberOption {
 	return func(cfg *subscribeConfig) {
 		cfg.credentialCookieKey = key
+		cfg.credentialsAsHeader = false
+	}
+}
+
+// SubscriberSendCredentialsAsHeader makes the subscriber send the
+// credentials in the Authorization header of the upgrade request,
+// instead of in the url where they could end up in access logs.
+// The renewed tokens are then sent as control messages.
+func SubscriberSendCredentialsAsHeader() SubscriberOption {
+	return func(cfg *subscribeConfig) {
+		cfg.credentialsAsHeader = true
+		cfg.credentialCookieKey = ""
+	}
+}
+
+// SubscriberOptionOverflowPolicy sets what happens when
+// the events channel of the subscriber is full.
+// The default is SubscriberOverflowDrop.
+func SubscriberOptionOverflowPolicy(policy SubscriberOverflowPolicy) SubscriberOption {
+	return func(cfg *subscribeConfig) {
+		switch policy {
+		case SubscriberOverflowBlock:
+			cfg.queueConfig.OverflowPolicy = push.OverflowBlock
+		case SubscriberOverflowDropOldest:
+			cfg.queueConfig.OverflowPolicy = push.OverflowDropOldest
+		case SubscriberOverflowCoalesce:
+			cfg.queueConfig.OverflowPolicy = push.OverflowCoalesce
+		default:
+			cfg.queueConfig.OverflowPolicy = push.OverflowDrop
+		}
+	}
+}
+
+// SubscriberOptionSpillToDisk makes the subscriber keep the events in a
+// file created in the given directory when the events channel is full,
+// until they can be published. The file cannot grow over the given size
+// in bytes, after which the events are dropped. If maxSize is 0, the size
+// is unlimited. If the directory is empty, the default directory for
+// temporary files is used. The file is removed when the subscriber stops.
+func SubscriberOptionSpillToDisk(dir string, maxSize int64) SubscriberOption {
+	return func(cfg *subscribeConfig) {
+		cfg.queueConfig.OverflowPolicy = push.OverflowSpill
+		cfg.queueConfig.SpillDir = dir
+		cfg.queueConfig.SpillMaxSize = maxSize
+	}
+}
+
+// SubscriberOptionEventsChannelSize sets the size of the events channel.
+// The default is 2048.
+func SubscriberOptionEventsChannelSize(size int) SubscriberOption {
+	return func(cfg *subscribeConfig) {
+		cfg.queueConfig.EventsChSize = size
+	}
+}
+
+// SubscriberOptionErrorsChannelSize sets the size of the errors channel.
+// The default is 64.
+func SubscriberOptionErrorsChannelSize(size int) SubscriberOption {
+	return func(cfg *subscribeConfig) {
+		cfg.queueConfig.ErrorsChSize = size
+	}
+}
+
+// SubscriberOptionTransport sets how the subscriber receives the events.
+// The default is SubscriberTransportWebsocket. Server-sent events
+// are received using the HTTP client of the manipulator.
+func SubscriberOptionTransport(transport SubscriberTransport) SubscriberOption {
+	return func(cfg *subscribeConfig) {
+		switch transport {
+		case SubscriberTransportSSE:
+			cfg.queueConfig.Transport = push.TransportSSE
+		case SubscriberTransportAuto:
+			cfg.queueConfig.Transport = push.TransportAuto
+		default:
+			cfg.queueConfig.Transport = push.TransportWebsocket
+		}
+	}
+}
+
+// SubscriberOptionDeduplicate makes the subscriber drop the events
+// already received within the given window, for instance after
+// a reconnection. Events are identified by identity, ID, type and
+// timestamp. Events without timestamp or ID are never dropped.
+func SubscriberOptionDeduplicate(window time.Duration) SubscriberOption {
+	return func(cfg *subscribeConfig) {
+		cfg.queueConfig.DedupWindow = window
+	}
+}
+
+// SubscriberOptionReorder makes the subscriber hold the events
+// of each object for the given delay, and publish them sorted by
+// timestamp. Events of different objects may still be published
+// out of order. Events without ID are published right away.
+func SubscriberOptionReorder(delay time.Duration) SubscriberOption {
+	return func(cfg *subscribeConfig) {
+		cfg.queueConfig.ReorderDelay = delay
 	}
 }
 
@@ -83,6 +230,8 @@ func SubscriberOptionSupportErrorEvents() SubscriberOption {
 }
 
 // NewSubscriber returns a new subscription.
+// If the manipulator has multiple endpoints, the subscriber
+// reconnects to another endpoint when the current one dies.
 func NewSubscriber(manipulator manipulate.Manipulator, options ...SubscriberOption) manipulate.Subscriber {
 
 	m, ok := manipulator.(*httpManipulator)
@@ -98,13 +247,33 @@ func NewSubscriber(manipulator manipulate.Manipulator, options ...SubscriberOpti
 		opt(&cfg)
 	}
 
+	if cfg.credentialsAsHeader {
+		username, _ := ExtractCredentials(m)
+		if username == "" {
+			username = "Bearer"
+		}
+		cfg.queueConfig.AuthorizationScheme = username
+	}
+
+	cfg.queueConfig.HTTPClient = m.client
+
 	if cfg.tlsConfig != nil {
 		cfg.tlsConfig = cfg.tlsConfig.Clone()
 		cfg.tlsConfig.NextProtos = nil
 	}
 
+	// We connect to the healthiest endpoints first.
+	urls := []string{m.url}
+	if m.endpoints != nil {
+		urls = m.endpoints.urls()
+	}
+
+	for i, u := range urls {
+		urls[i] = fmt.Sprintf("%s/%s", u, cfg.endpoint)
+	}
+
 	return push.NewSubscriber(
-		fmt.Sprintf("%s/%s", m.url, cfg.endpoint),
+		urls,
 		cfg.namespace,
 		m.currentPassword(),
 		m.registerRenewNotifier,
@@ -117,6 +286,7 @@ func NewSubscriber(manipulator manipulate.Manipulator, options ...SubscriberOpti
 		cfg.supportErrorEvents,
 		cfg.recursive,
 		cfg.credentialCookieKey,
+		cfg.queueConfig,
 	)
 }
 
@@ -14,8 +14,10 @@ package maniphttp
 import (
 	"crypto/tls"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/manipulate/internal/push"
 	"go.aporeto.io/manipulate/maniptest"
 )
 
@@ -75,6 +77,70 @@ func TestOptions(t *testing.T) {
 		So(cfg.credentialCookieKey, ShouldEqual, "creds")
 	})
 
+	Convey("SubscriberSendCredentialsAsHeader should work", t, func() {
+		cfg := newSubscribeConfig(m)
+		SubscriberSendCredentialsAsCookie("creds")(&cfg)
+		SubscriberSendCredentialsAsHeader()(&cfg)
+		So(cfg.credentialsAsHeader, ShouldBeTrue)
+		So(cfg.credentialCookieKey, ShouldEqual, "")
+	})
+
+	Convey("SubscriberOptionOverflowPolicy should work", t, func() {
+		cfg := newSubscribeConfig(m)
+		So(cfg.queueConfig.OverflowPolicy, ShouldEqual, push.OverflowDrop)
+		SubscriberOptionOverflowPolicy(SubscriberOverflowBlock)(&cfg)
+		So(cfg.queueConfig.OverflowPolicy, ShouldEqual, push.OverflowBlock)
+		SubscriberOptionOverflowPolicy(SubscriberOverflowDropOldest)(&cfg)
+		So(cfg.queueConfig.OverflowPolicy, ShouldEqual, push.OverflowDropOldest)
+		SubscriberOptionOverflowPolicy(SubscriberOverflowCoalesce)(&cfg)
+		So(cfg.queueConfig.OverflowPolicy, ShouldEqual, push.OverflowCoalesce)
+		SubscriberOptionOverflowPolicy(SubscriberOverflowDrop)(&cfg)
+		So(cfg.queueConfig.OverflowPolicy, ShouldEqual, push.OverflowDrop)
+	})
+
+	Convey("SubscriberOptionSpillToDisk should work", t, func() {
+		cfg := newSubscribeConfig(m)
+		SubscriberOptionSpillToDisk("/tmp", 1024)(&cfg)
+		So(cfg.queueConfig.OverflowPolicy, ShouldEqual, push.OverflowSpill)
+		So(cfg.queueConfig.SpillDir, ShouldEqual, "/tmp")
+		So(cfg.queueConfig.SpillMaxSize, ShouldEqual, 1024)
+	})
+
+	Convey("SubscriberOptionEventsChannelSize should work", t, func() {
+		cfg := newSubscribeConfig(m)
+		SubscriberOptionEventsChannelSize(10)(&cfg)
+		So(cfg.queueConfig.EventsChSize, ShouldEqual, 10)
+	})
+
+	Convey("SubscriberOptionErrorsChannelSize should work", t, func() {
+		cfg := newSubscribeConfig(m)
+		SubscriberOptionErrorsChannelSize(5)(&cfg)
+		So(cfg.queueConfig.ErrorsChSize, ShouldEqual, 5)
+	})
+
+	Convey("SubscriberOptionTransport should work", t, func() {
+		cfg := newSubscribeConfig(m)
+		So(cfg.queueConfig.Transport, ShouldEqual, push.TransportWebsocket)
+		SubscriberOptionTransport(SubscriberTransportSSE)(&cfg)
+		So(cfg.queueConfig.Transport, ShouldEqual, push.TransportSSE)
+		SubscriberOptionTransport(SubscriberTransportAuto)(&cfg)
+		So(cfg.queueConfig.Transport, ShouldEqual, push.TransportAuto)
+		SubscriberOptionTransport(SubscriberTransportWebsocket)(&cfg)
+		So(cfg.queueConfig.Transport, ShouldEqual, push.TransportWebsocket)
+	})
+
+	Convey("SubscriberOptionDeduplicate should work", t, func() {
+		cfg := newSubscribeConfig(m)
+		SubscriberOptionDeduplicate(time.Minute)(&cfg)
+		So(cfg.queueConfig.DedupWindow, ShouldEqual, time.Minute)
+	})
+
+	Convey("SubscriberOptionReorder should work", t, func() {
+		cfg := newSubscribeConfig(m)
+		SubscriberOptionReorder(time.Second)(&cfg)
+		So(cfg.queueConfig.ReorderDelay, ShouldEqual, time.Second)
+	})
+
 	Convey("SubscriberOptionSupportErrorEvents should work", t, func() {
 		cfg := newSubscribeConfig(m)
 		SubscriberOptionSupportErrorEvents()(&cfg)
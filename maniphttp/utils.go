@@ -83,6 +83,61 @@ func addQueryParameters(req *http.Request, ctx manipulate.Context) error {
 	return nil
 }
 
+// parseRetryAfter returns the delay requested by the given Retry-After
+// header value, which is either a number of seconds or an HTTP date.
+// It returns 0 if the value is empty or invalid. As the value is chosen
+// by the server, the delay is capped to maxRetryAfter.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+
+	if value == "" {
+		return 0
+	}
+
+	var d time.Duration
+
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs <= 0 {
+			return 0
+		}
+		if secs > int(maxRetryAfter/time.Second) {
+			return maxRetryAfter
+		}
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		d = t.Sub(now)
+	}
+
+	if d < 0 {
+		return 0
+	}
+
+	if d > maxRetryAfter {
+		return maxRetryAfter
+	}
+
+	return d
+}
+
+// retryDelay returns the time to wait before retrying, which is the
+// largest of the given backoff and retryAfter, without going past the
+// given deadline. retryAfter is capped to maxRetryAfter.
+func retryDelay(backoff time.Duration, retryAfter time.Duration, deadline time.Time) time.Duration {
+
+	if retryAfter > maxRetryAfter {
+		retryAfter = maxRetryAfter
+	}
+
+	if retryAfter <= backoff {
+		return backoff
+	}
+
+	if d := time.Until(deadline); d > 0 && retryAfter > d {
+		return d
+	}
+
+	return retryAfter
+}
+
 func decodeData(r *http.Response, dest interface{}) (err error) {
 
 	if r.Body == nil {
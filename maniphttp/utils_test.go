@@ -19,6 +19,7 @@ import (
 	"net/http"
 	"net/url"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 	"go.aporeto.io/elemental"
@@ -280,3 +281,32 @@ func Test_decodeData(t *testing.T) {
 		})
 	})
 }
+
+func Test_parseRetryAfter(t *testing.T) {
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	Convey("Given I have some Retry-After values", t, func() {
+		So(parseRetryAfter("", now), ShouldEqual, 0)
+		So(parseRetryAfter("3", now), ShouldEqual, 3*time.Second)
+		So(parseRetryAfter("-3", now), ShouldEqual, 0)
+		So(parseRetryAfter("Wed, 01 Jan 2020 00:00:10 GMT", now), ShouldEqual, 10*time.Second)
+		So(parseRetryAfter("Tue, 31 Dec 2019 23:59:50 GMT", now), ShouldEqual, 0)
+		So(parseRetryAfter("nope", now), ShouldEqual, 0)
+		So(parseRetryAfter("86400", now), ShouldEqual, maxRetryAfter)
+		So(parseRetryAfter("99999999999999999", now), ShouldEqual, maxRetryAfter)
+		So(parseRetryAfter("Thu, 02 Jan 2020 00:00:00 GMT", now), ShouldEqual, maxRetryAfter)
+	})
+}
+
+func Test_retryDelay(t *testing.T) {
+
+	Convey("Given I have some delays", t, func() {
+		deadline := time.Now().Add(time.Hour)
+		So(retryDelay(time.Second, 0, deadline), ShouldEqual, time.Second)
+		So(retryDelay(time.Second, 3*time.Second, deadline), ShouldEqual, 3*time.Second)
+		So(retryDelay(3*time.Second, time.Second, deadline), ShouldEqual, 3*time.Second)
+		So(retryDelay(time.Second, 2*time.Hour, deadline), ShouldEqual, maxRetryAfter)
+		So(retryDelay(time.Second, 30*time.Second, time.Now().Add(10*time.Second)), ShouldBeLessThanOrEqualTo, 10*time.Second)
+	})
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manipmongo
+
+import (
+	"go.aporeto.io/elemental"
+)
+
+// attributeEncrypterKeyring is an elemental.AttributeEncrypter
+// that holds multiple elemental.AttributeEncrypter.
+type attributeEncrypterKeyring struct {
+	current  elemental.AttributeEncrypter
+	previous []elemental.AttributeEncrypter
+}
+
+// NewAttributeEncrypterKeyring returns an elemental.AttributeEncrypter that
+// always encrypts using the given current elemental.AttributeEncrypter, and
+// decrypts using the first of the current or previous ones that succeeds.
+//
+// This allows to rotate encryption keys without downtime: the new key is
+// set as current while the old ones are kept as previous until every stored
+// object has been rewritten using ReencryptAttributes.
+func NewAttributeEncrypterKeyring(current elemental.AttributeEncrypter, previous ...elemental.AttributeEncrypter) elemental.AttributeEncrypter {
+
+	if current == nil {
+		panic("current attribute encrypter must not be nil")
+	}
+
+	for _, p := range previous {
+		if p == nil {
+			panic("previous attribute encrypters must not be nil")
+		}
+	}
+
+	return &attributeEncrypterKeyring{
+		current:  current,
+		previous: previous,
+	}
+}
+
+// EncryptString encrypts the given string using the current encrypter.
+func (k *attributeEncrypterKeyring) EncryptString(value string) (string, error) {
+
+	return k.current.EncryptString(value)
+}
+
+// DecryptString decrypts the given string using the first encrypter that succeeds.
+// If none does, the error returned by the current encrypter is returned.
+func (k *attributeEncrypterKeyring) DecryptString(value string) (string, error) {
+
+	out, err := k.current.DecryptString(value)
+	if err == nil {
+		return out, nil
+	}
+
+	for _, enc := range k.previous {
+		if pout, perr := enc.DecryptString(value); perr == nil {
+			return pout, nil
+		}
+	}
+
+	return "", err
+}
@@ -0,0 +1,91 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manipmongo
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+)
+
+func TestNewAttributeEncrypterKeyring(t *testing.T) {
+
+	Convey("Calling NewAttributeEncrypterKeyring with a nil current encrypter should panic", t, func() {
+		So(func() { NewAttributeEncrypterKeyring(nil) }, ShouldPanicWith, "current attribute encrypter must not be nil")
+	})
+
+	Convey("Calling NewAttributeEncrypterKeyring with a nil previous encrypter should panic", t, func() {
+		enc, _ := elemental.NewAESAttributeEncrypter("0123456789ABCDEF")
+		So(func() { NewAttributeEncrypterKeyring(enc, nil) }, ShouldPanicWith, "previous attribute encrypters must not be nil")
+	})
+
+	Convey("Given I have a keyring with a new and an old key", t, func() {
+
+		oldEnc, _ := elemental.NewAESAttributeEncrypter("0123456789ABCDEF")
+		newEnc, _ := elemental.NewAESAttributeEncrypter("FEDCBA9876543210")
+		otherEnc, _ := elemental.NewAESAttributeEncrypter("AAAAAAAAAAAAAAAA")
+
+		k := NewAttributeEncrypterKeyring(newEnc, oldEnc)
+
+		Convey("When I encrypt a string", func() {
+
+			encrypted, err := k.EncryptString("hello")
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then it should be encrypted with the new key", func() {
+				decrypted, err := newEnc.DecryptString(encrypted)
+				So(err, ShouldBeNil)
+				So(decrypted, ShouldEqual, "hello")
+
+				_, err = oldEnc.DecryptString(encrypted)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I decrypt a string encrypted with the new key", func() {
+
+			encrypted, _ := newEnc.EncryptString("hello")
+			decrypted, err := k.DecryptString(encrypted)
+
+			Convey("Then it should be decrypted", func() {
+				So(err, ShouldBeNil)
+				So(decrypted, ShouldEqual, "hello")
+			})
+		})
+
+		Convey("When I decrypt a string encrypted with the old key", func() {
+
+			encrypted, _ := oldEnc.EncryptString("hello")
+			decrypted, err := k.DecryptString(encrypted)
+
+			Convey("Then it should be decrypted", func() {
+				So(err, ShouldBeNil)
+				So(decrypted, ShouldEqual, "hello")
+			})
+		})
+
+		Convey("When I decrypt a string encrypted with an unknown key", func() {
+
+			encrypted, _ := otherEnc.EncryptString("hello")
+			decrypted, err := k.DecryptString(encrypted)
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(decrypted, ShouldEqual, "")
+			})
+		})
+	})
+}
@@ -12,7 +12,10 @@
 package manipmongo
 
 import (
+	"context"
 	"fmt"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -264,3 +267,185 @@ func GetAttributeEncrypter(manipulator manipulate.Manipulator) elemental.Attribu
 
 	return m.attributeEncrypter
 }
+
+// A ReencryptionProgress holds the progress of a ReencryptAttributes job.
+// Skipped is the number of processed objects that have not been rewritten
+// because they have been changed or deleted since their retrieval.
+type ReencryptionProgress struct {
+	Identity  elemental.Identity
+	Total     int
+	Processed int
+	Skipped   int
+}
+
+// ReencryptAttributes rewrites all the objects with the identity of the given
+// identifiablesTemplate, retrieved by blocks of the given blockSize, using the
+// attribute encrypter of the given mongo manipulator.
+//
+// This is meant to be used with an encrypter returned by NewAttributeEncrypterKeyring:
+// every object is decrypted with any known key, then encrypted back with the current
+// one. Once the job is complete, previous keys can be removed from the keyring.
+//
+// The given manipulate.Context can be used to restrict the objects to rewrite. Its
+// fields selection is ignored, as the objects are rewritten entirely. If
+// progressFunc is not nil, it will be called after each rewritten block.
+// This function blocks until the job is over, so it should be called in a
+// goroutine to run in the background. It can be interrupted by canceling the given context.
+//
+// An object is only rewritten if its encrypted attributes still hold the values that
+// have been retrieved. As every write encrypts them again, an object changed in the
+// meantime is skipped instead of losing the change, as it is already encrypted with
+// the current key. Objects without encrypted values are not rewritten.
+func ReencryptAttributes(
+	ctx context.Context,
+	manipulator manipulate.Manipulator,
+	identifiablesTemplate elemental.Identifiables,
+	mctx manipulate.Context,
+	blockSize int,
+	progressFunc func(ReencryptionProgress),
+) error {
+
+	m, ok := manipulator.(*mongoManipulator)
+	if !ok {
+		panic("you can only pass a mongo manipulator to ReencryptAttributes")
+	}
+
+	if m.attributeEncrypter == nil {
+		return fmt.Errorf("manipulator has no attribute encrypter")
+	}
+
+	return reencryptAttributes(ctx, m, m.attributeEncrypter, identifiablesTemplate, mctx, blockSize, progressFunc)
+}
+
+func reencryptAttributes(
+	ctx context.Context,
+	manipulator manipulate.Manipulator,
+	encrypter elemental.AttributeEncrypter,
+	identifiablesTemplate elemental.Identifiables,
+	mctx manipulate.Context,
+	blockSize int,
+	progressFunc func(ReencryptionProgress),
+) error {
+
+	if mctx == nil {
+		mctx = manipulate.NewContext(ctx)
+	}
+
+	progress := ReencryptionProgress{
+		Identity: identifiablesTemplate.Identity(),
+	}
+
+	total, err := manipulator.Count(mctx.Derive(), progress.Identity)
+	if err != nil {
+		return fmt.Errorf("unable to count objects: %s", err)
+	}
+	progress.Total = total
+
+	return manipulate.IterFunc(
+		ctx,
+		manipulator,
+		identifiablesTemplate,
+		// The objects are rewritten entirely, so we must
+		// retrieve all their fields, whatever mctx selects.
+		// We decrypt them ourselves to keep the encrypted values.
+		mctx.Derive(
+			manipulate.ContextOptionOrder("ID"),
+			manipulate.ContextOptionFields(nil),
+			contextOptionNoDecryption(),
+		),
+		func(block elemental.Identifiables) error {
+
+			for _, o := range block.List() {
+
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				default:
+				}
+
+				encrypted, err := decryptAttributes(o, encrypter)
+				if err != nil {
+					return fmt.Errorf("unable to decrypt object '%s': %s", o.Identifier(), err)
+				}
+
+				progress.Processed++
+
+				if len(encrypted) == 0 {
+					continue
+				}
+
+				err = manipulator.Update(
+					mctx.Derive(
+						manipulate.ContextOptionFields(nil),
+						contextOptionUpdateIf(encrypted),
+					),
+					o,
+				)
+
+				switch {
+				case manipulate.IsObjectNotFoundError(err):
+					progress.Skipped++
+				case err != nil:
+					return fmt.Errorf("unable to rewrite object '%s': %s", o.Identifier(), err)
+				}
+			}
+
+			if progressFunc != nil {
+				progressFunc(progress)
+			}
+
+			return nil
+		},
+		blockSize,
+	)
+}
+
+// decryptAttributes decrypts the attributes of the given object,
+// and returns the stored values of the ones that have been decrypted.
+func decryptAttributes(o elemental.Identifiable, encrypter elemental.AttributeEncrypter) (bson.D, error) {
+
+	a, ok := o.(elemental.AttributeEncryptable)
+	if !ok {
+		return nil, nil
+	}
+
+	before, err := toBSONDocument(o)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = a.DecryptAttributes(encrypter); err != nil {
+		return nil, err
+	}
+
+	after, err := toBSONDocument(o)
+	if err != nil {
+		return nil, err
+	}
+
+	var encrypted bson.D
+	for k, v := range before {
+		if !reflect.DeepEqual(v, after[k]) {
+			encrypted = append(encrypted, bson.DocElem{Name: k, Value: v})
+		}
+	}
+
+	sort.Slice(encrypted, func(i, j int) bool { return encrypted[i].Name < encrypted[j].Name })
+
+	return encrypted, nil
+}
+
+func toBSONDocument(o interface{}) (bson.M, error) {
+
+	data, err := bson.Marshal(o)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := bson.M{}
+	if err = bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
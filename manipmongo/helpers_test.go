@@ -22,6 +22,7 @@ import (
 	"github.com/globalsign/mgo/bson"
 	. "github.com/smartystreets/goconvey/convey"
 	"go.aporeto.io/elemental"
+	testmodel "go.aporeto.io/elemental/test/model"
 	"go.aporeto.io/manipulate"
 	"go.aporeto.io/manipulate/maniptest"
 )
@@ -535,3 +536,238 @@ func TestGetAttributeEncrypter(t *testing.T) {
 		})
 	})
 }
+
+func TestReencryptAttributes(t *testing.T) {
+
+	Convey("Given I a test manipulator", t, func() {
+
+		m := maniptest.NewTestManipulator()
+
+		Convey("When I call ReencryptAttributes", func() {
+			Convey("Then it should panic", func() {
+				So(func() { _ = ReencryptAttributes(context.Background(), m, testmodel.ListsList{}, nil, 10, nil) }, ShouldPanicWith, "you can only pass a mongo manipulator to ReencryptAttributes")
+			})
+		})
+	})
+
+	Convey("Given I a mongo manipulator with no attribute encrypter", t, func() {
+
+		m := &mongoManipulator{}
+
+		Convey("When I call ReencryptAttributes", func() {
+
+			err := ReencryptAttributes(context.Background(), m, testmodel.ListsList{}, nil, 10, nil)
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "manipulator has no attribute encrypter")
+			})
+		})
+	})
+}
+
+var secretIdentity = elemental.MakeIdentity("secret", "secrets")
+
+type secretObject struct {
+	ID     string `bson:"_id"`
+	Name   string `bson:"name"`
+	Secret string `bson:"secret"`
+}
+
+func (o *secretObject) Identity() elemental.Identity { return secretIdentity }
+func (o *secretObject) Identifier() string           { return o.ID }
+func (o *secretObject) SetIdentifier(id string)      { o.ID = id }
+func (o *secretObject) Version() int                 { return 1 }
+
+func (o *secretObject) EncryptAttributes(encrypter elemental.AttributeEncrypter) (err error) {
+	if o.Secret != "" {
+		o.Secret, err = encrypter.EncryptString(o.Secret)
+	}
+	return err
+}
+
+func (o *secretObject) DecryptAttributes(encrypter elemental.AttributeEncrypter) (err error) {
+	if o.Secret != "" {
+		o.Secret, err = encrypter.DecryptString(o.Secret)
+	}
+	return err
+}
+
+type secretObjectsList []*secretObject
+
+func (l secretObjectsList) Identity() elemental.Identity { return secretIdentity }
+func (l secretObjectsList) Version() int                 { return 1 }
+
+func (l secretObjectsList) Copy() elemental.Identifiables {
+	out := append(secretObjectsList{}, l...)
+	return &out
+}
+
+func (l secretObjectsList) Append(objects ...elemental.Identifiable) elemental.Identifiables {
+	out := append(secretObjectsList{}, l...)
+	for _, o := range objects {
+		out = append(out, o.(*secretObject))
+	}
+	return &out
+}
+
+func (l secretObjectsList) List() elemental.IdentifiablesList {
+	out := make(elemental.IdentifiablesList, len(l))
+	for i, o := range l {
+		out[i] = o
+	}
+	return out
+}
+
+func Test_reencryptAttributes(t *testing.T) {
+
+	Convey("Given I have a test manipulator with 2 objects, one of them with an encrypted attribute", t, func() {
+
+		encrypter, err := elemental.NewAESAttributeEncrypter("0123456789ABCDEF")
+		So(err, ShouldBeNil)
+
+		encrypted, err := encrypter.EncryptString("hello")
+		So(err, ShouldBeNil)
+
+		m := maniptest.NewTestManipulator()
+
+		m.MockCount(t, func(mctx manipulate.Context, identity elemental.Identity) (int, error) {
+			return 2, nil
+		})
+
+		var order, fields []string
+		var noDecryption bool
+		m.MockRetrieveMany(t, func(mctx manipulate.Context, dest elemental.Identifiables) error {
+			order = mctx.Order()
+			fields = mctx.Fields()
+			noDecryption = getNoDecryption(mctx)
+			*dest.(*secretObjectsList) = append(
+				*dest.(*secretObjectsList),
+				&secretObject{ID: "1", Secret: encrypted},
+				&secretObject{ID: "2"},
+			)
+			return nil
+		})
+
+		var updated []string
+		var updatedSecrets []string
+		var conditions []bson.D
+		m.MockUpdate(t, func(mctx manipulate.Context, object elemental.Identifiable) error {
+			updated = append(updated, object.Identifier())
+			updatedSecrets = append(updatedSecrets, object.(*secretObject).Secret)
+			conditions = append(conditions, getUpdateIf(mctx))
+			return nil
+		})
+
+		Convey("When I call reencryptAttributes", func() {
+
+			var progresses []ReencryptionProgress
+			err := reencryptAttributes(context.Background(), m, encrypter, &secretObjectsList{}, nil, 10, func(p ReencryptionProgress) {
+				progresses = append(progresses, p)
+			})
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the objects should be ordered by ID and retrieved encrypted", func() {
+				So(order, ShouldResemble, []string{"ID"})
+				So(noDecryption, ShouldBeTrue)
+			})
+
+			Convey("Then only the object with an encrypted value should have been rewritten decrypted", func() {
+				So(updated, ShouldResemble, []string{"1"})
+				So(updatedSecrets, ShouldResemble, []string{"hello"})
+			})
+
+			Convey("Then the rewrite should be conditioned on the encrypted value", func() {
+				So(conditions, ShouldResemble, []bson.D{{{Name: "secret", Value: encrypted}}})
+			})
+
+			Convey("Then progress should have been reported", func() {
+				So(progresses, ShouldResemble, []ReencryptionProgress{
+					{Identity: secretIdentity, Total: 2, Processed: 2},
+				})
+			})
+		})
+
+		Convey("When I call reencryptAttributes with a context selecting some fields", func() {
+
+			mctx := manipulate.NewContext(context.Background(), manipulate.ContextOptionFields([]string{"name"}))
+			err := reencryptAttributes(context.Background(), m, encrypter, &secretObjectsList{}, mctx, 10, nil)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then all the fields should have been retrieved", func() {
+				So(fields, ShouldBeNil)
+			})
+		})
+
+		Convey("When the object has changed since its retrieval", func() {
+
+			m.MockUpdate(t, func(mctx manipulate.Context, object elemental.Identifiable) error {
+				return manipulate.NewErrObjectNotFound("cannot find the object for the given ID")
+			})
+
+			var progresses []ReencryptionProgress
+			err := reencryptAttributes(context.Background(), m, encrypter, &secretObjectsList{}, nil, 10, func(p ReencryptionProgress) {
+				progresses = append(progresses, p)
+			})
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the object should have been skipped", func() {
+				So(progresses, ShouldResemble, []ReencryptionProgress{
+					{Identity: secretIdentity, Total: 2, Processed: 2, Skipped: 1},
+				})
+			})
+		})
+
+		Convey("When the update fails", func() {
+
+			m.MockUpdate(t, func(mctx manipulate.Context, object elemental.Identifiable) error {
+				return fmt.Errorf("boom")
+			})
+
+			err := reencryptAttributes(context.Background(), m, encrypter, &secretObjectsList{}, nil, 10, nil)
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "iter function returned an error on iteration 1: unable to rewrite object '1': boom")
+			})
+		})
+
+		Convey("When an object cannot be decrypted", func() {
+
+			m.MockRetrieveMany(t, func(mctx manipulate.Context, dest elemental.Identifiables) error {
+				*dest.(*secretObjectsList) = append(*dest.(*secretObjectsList), &secretObject{ID: "1", Secret: "not encrypted"})
+				return nil
+			})
+
+			err := reencryptAttributes(context.Background(), m, encrypter, &secretObjectsList{}, nil, 10, nil)
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldStartWith, "iter function returned an error on iteration 1: unable to decrypt object '1': ")
+			})
+		})
+
+		Convey("When the count fails", func() {
+
+			m.MockCount(t, func(mctx manipulate.Context, identity elemental.Identity) (int, error) {
+				return 0, fmt.Errorf("boom")
+			})
+
+			err := reencryptAttributes(context.Background(), m, encrypter, &secretObjectsList{}, nil, 10, nil)
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "unable to count objects: boom")
+			})
+		})
+	})
+}
@@ -69,13 +69,33 @@ func massageValues(key string, values []interface{}) []interface{} {
 	return out
 }
 
+// A CompileOption configures CompileFilter.
+type CompileOption func(*compileConfig)
+
+type compileConfig struct {
+	caseInsensitiveMatch bool
+}
+
+// CompileOptionCaseInsensitiveMatch makes the MatchComparator
+// compile to case insensitive regular expressions.
+func CompileOptionCaseInsensitiveMatch() CompileOption {
+	return func(c *compileConfig) {
+		c.caseInsensitiveMatch = true
+	}
+}
+
 // CompileFilter compiles the given manipulate Filter into a mongo filter.
-func CompileFilter(f *elemental.Filter) bson.D {
+func CompileFilter(f *elemental.Filter, options ...CompileOption) bson.D {
 
 	if len(f.Operators()) == 0 {
 		return bson.D{}
 	}
 
+	cfg := compileConfig{}
+	for _, opt := range options {
+		opt(&cfg)
+	}
+
 	ands := []bson.D{}
 
 	for i, operator := range f.Operators() {
@@ -141,7 +161,11 @@ func CompileFilter(f *elemental.Filter) bson.D {
 			case elemental.MatchComparator:
 				dest := []bson.D{}
 				for _, v := range f.Values()[i] {
-					dest = append(dest, bson.D{{Name: k, Value: bson.D{{Name: "$regex", Value: v}}}})
+					regex := bson.D{{Name: "$regex", Value: v}}
+					if cfg.caseInsensitiveMatch {
+						regex = append(regex, bson.DocElem{Name: "$options", Value: "i"})
+					}
+					dest = append(dest, bson.D{{Name: k, Value: regex}})
 				}
 				items = append(items, bson.D{{Name: "$or", Value: dest}})
 			}
@@ -151,14 +175,14 @@ func CompileFilter(f *elemental.Filter) bson.D {
 		case elemental.AndFilterOperator:
 			subs := []bson.D{}
 			for _, sub := range f.AndFilters()[i] {
-				subs = append(subs, CompileFilter(sub))
+				subs = append(subs, CompileFilter(sub, options...))
 			}
 			ands = append(ands, bson.D{{Name: "$and", Value: subs}})
 
 		case elemental.OrFilterOperator:
 			subs := []bson.D{}
 			for _, sub := range f.OrFilters()[i] {
-				subs = append(subs, CompileFilter(sub))
+				subs = append(subs, CompileFilter(sub, options...))
 			}
 			ands = append(ands, bson.D{{Name: "$or", Value: subs}})
 		}
@@ -183,6 +183,23 @@ func TestUtils_compiler(t *testing.T) {
 		})
 	})
 
+	Convey("Given I have filter that contains a nested Match", t, func() {
+
+		f := elemental.NewFilterComposer().Or(
+			elemental.NewFilterComposer().
+				WithKey("x").Matches("$abc^").
+				Done(),
+		).Done()
+
+		Convey("When I compile the filter with a case insensitive match", func() {
+			b, _ := bson.MarshalJSON(toMap(CompileFilter(f, CompileOptionCaseInsensitiveMatch())))
+
+			Convey("Then the bson should be correct", func() {
+				So(strings.Replace(string(b), "\n", "", 1), ShouldEqual, `{"$and":[{"$or":[{"$and":[{"$or":[{"x":{"$options":"i","$regex":"$abc^"}}]}]}]}]}`)
+			})
+		})
+	})
+
 	Convey("Given I have filter that contains Exists", t, func() {
 
 		f := elemental.NewFilterComposer().
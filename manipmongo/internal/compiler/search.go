@@ -0,0 +1,81 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compiler
+
+import (
+	"github.com/globalsign/mgo/bson"
+)
+
+// CompileTextSearch compiles the given search into a mongo $text filter.
+// If language is empty, the default language of the text index is used.
+func CompileTextSearch(search string, language string, caseSensitive bool) bson.D {
+
+	text := bson.D{{Name: "$search", Value: search}}
+
+	if language != "" {
+		text = append(text, bson.DocElem{Name: "$language", Value: language})
+	}
+
+	if caseSensitive {
+		text = append(text, bson.DocElem{Name: "$caseSensitive", Value: true})
+	}
+
+	return bson.D{{Name: "$text", Value: text}}
+}
+
+// CompileGeoWithin compiles the given GeoJSON geometry into a mongo
+// $geoWithin filter on the given key.
+func CompileGeoWithin(key string, geometry interface{}) bson.D {
+
+	return bson.D{
+		{
+			Name: massageKey(key),
+			Value: bson.D{
+				{
+					Name:  "$geoWithin",
+					Value: bson.D{{Name: "$geometry", Value: geometry}},
+				},
+			},
+		},
+	}
+}
+
+// CompileNear compiles the given point into a mongo $near filter
+// on the given key. Distances are in meters. Distances that are
+// less or equal to zero are ignored.
+func CompileNear(key string, longitude float64, latitude float64, minDistance float64, maxDistance float64) bson.D {
+
+	near := bson.D{
+		{
+			Name: "$geometry",
+			Value: bson.D{
+				{Name: "type", Value: "Point"},
+				{Name: "coordinates", Value: []float64{longitude, latitude}},
+			},
+		},
+	}
+
+	if minDistance > 0 {
+		near = append(near, bson.DocElem{Name: "$minDistance", Value: minDistance})
+	}
+
+	if maxDistance > 0 {
+		near = append(near, bson.DocElem{Name: "$maxDistance", Value: maxDistance})
+	}
+
+	return bson.D{
+		{
+			Name:  massageKey(key),
+			Value: bson.D{{Name: "$near", Value: near}},
+		},
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compiler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompileTextSearch(t *testing.T) {
+
+	Convey("Given I compile a simple text search", t, func() {
+
+		b, _ := bson.MarshalJSON(toMap(CompileTextSearch("hello world", "", false)))
+
+		Convey("Then the bson should be correct", func() {
+			So(strings.Replace(string(b), "\n", "", 1), ShouldEqual, `{"$text":{"$search":"hello world"}}`)
+		})
+	})
+
+	Convey("Given I compile a text search with language and case sensitivity", t, func() {
+
+		b, _ := bson.MarshalJSON(toMap(CompileTextSearch("bonjour", "french", true)))
+
+		Convey("Then the bson should be correct", func() {
+			So(strings.Replace(string(b), "\n", "", 1), ShouldEqual, `{"$text":{"$caseSensitive":true,"$language":"french","$search":"bonjour"}}`)
+		})
+	})
+}
+
+func TestCompileGeoWithin(t *testing.T) {
+
+	Convey("Given I compile a geoWithin filter", t, func() {
+
+		polygon := bson.M{
+			"type":        "Polygon",
+			"coordinates": [][][]float64{{{0, 0}, {3, 6}, {6, 1}, {0, 0}}},
+		}
+
+		b, _ := bson.MarshalJSON(toMap(CompileGeoWithin("Location", polygon)))
+
+		Convey("Then the bson should be correct", func() {
+			So(strings.Replace(string(b), "\n", "", 1), ShouldEqual, `{"location":{"$geoWithin":{"$geometry":{"coordinates":[[[0,0],[3,6],[6,1],[0,0]]],"type":"Polygon"}}}}`)
+		})
+	})
+}
+
+func TestCompileNear(t *testing.T) {
+
+	Convey("Given I compile a near filter without distances", t, func() {
+
+		b, _ := bson.MarshalJSON(toMap(CompileNear("Location", 1.5, 2.5, 0, 0)))
+
+		Convey("Then the bson should be correct", func() {
+			So(strings.Replace(string(b), "\n", "", 1), ShouldEqual, `{"location":{"$near":{"$geometry":{"coordinates":[1.5,2.5],"type":"Point"}}}}`)
+		})
+	})
+
+	Convey("Given I compile a near filter with distances", t, func() {
+
+		b, _ := bson.MarshalJSON(toMap(CompileNear("Location", 1.5, 2.5, 10, 100)))
+
+		Convey("Then the bson should be correct", func() {
+			So(strings.Replace(string(b), "\n", "", 1), ShouldEqual, `{"location":{"$near":{"$geometry":{"coordinates":[1.5,2.5],"type":"Point"},"$maxDistance":100,"$minDistance":10}}}`)
+		})
+	})
+}
@@ -16,6 +16,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/globalsign/mgo"
@@ -32,13 +33,15 @@ const defaultGlobalContextTimeout = 60 * time.Second
 
 // MongoStore represents a MongoDB session.
 type mongoManipulator struct {
-	rootSession        *mgo.Session
-	dbName             string
-	sharder            Sharder
-	defaultRetryFunc   manipulate.RetryFunc
-	forcedReadFilter   bson.D
-	attributeEncrypter elemental.AttributeEncrypter
-	explain            map[elemental.Identity]map[elemental.Operation]struct{}
+	rootSession          *mgo.Session
+	dbName               string
+	sharder              Sharder
+	defaultRetryFunc     manipulate.RetryFunc
+	forcedReadFilter     bson.D
+	attributeEncrypter   elemental.AttributeEncrypter
+	explain              map[elemental.Identity]map[elemental.Operation]struct{}
+	collations           map[elemental.Identity]*mgo.Collation
+	readAfterWriteWindow time.Duration
 }
 
 // New returns a new manipulator backed by MongoDB.
@@ -81,13 +84,15 @@ func New(url string, db string, options ...Option) (manipulate.TransactionalMani
 	session.SetSafe(convertWriteConsistency(cfg.writeConsistency))
 
 	return &mongoManipulator{
-		dbName:             db,
-		rootSession:        session,
-		sharder:            cfg.sharder,
-		defaultRetryFunc:   cfg.defaultRetryFunc,
-		forcedReadFilter:   cfg.forcedReadFilter,
-		attributeEncrypter: cfg.attributeEncrypter,
-		explain:            cfg.explain,
+		dbName:               db,
+		rootSession:          session,
+		sharder:              cfg.sharder,
+		defaultRetryFunc:     cfg.defaultRetryFunc,
+		forcedReadFilter:     cfg.forcedReadFilter,
+		attributeEncrypter:   cfg.attributeEncrypter,
+		explain:              cfg.explain,
+		collations:           cfg.collations,
+		readAfterWriteWindow: cfg.readAfterWriteWindow,
 	}, nil
 }
 
@@ -102,20 +107,34 @@ func (m *mongoManipulator) RetrieveMany(mctx manipulate.Context, dest elemental.
 	sp := tracing.StartTrace(mctx, fmt.Sprintf("manipmongo.retrieve_many.%s", dest.Identity().Category))
 	defer sp.Finish()
 
-	c, close := m.makeSession(dest.Identity(), mctx.ReadConsistency(), mctx.WriteConsistency())
+	c, close := m.makeSession(dest.Identity(), readAfterWriteConsistency(mctx, m.readAfterWriteWindow, time.Now()), mctx.WriteConsistency())
 	defer close()
 
+	// The results of a $near search are sorted by distance,
+	// which any explicit sort would override.
+	near := hasNearFilter(mctx)
+	if near && (len(mctx.Order()) > 0 || mctx.After() != "") {
+		return manipulate.NewErrCannotBuildQuery("cannot order or use a cursor with a near search")
+	}
+
 	var order []string
 	if o := mctx.Order(); len(o) > 0 {
 		order = applyOrdering(o)
-	} else if orderer, ok := dest.(elemental.DefaultOrderer); ok {
+	} else if orderer, ok := dest.(elemental.DefaultOrderer); ok && !near {
 		order = applyOrdering(orderer.DefaultOrder())
 	}
 
+	// Cursor based pagination needs a total ordering,
+	// so we use the _id as a tie-breaker.
+	paginated := !near && (mctx.After() != "" || mctx.Limit() > 0)
+	if paginated {
+		order = cursorOrdering(order)
+	}
+
 	// Filtering
 	filter := bson.D{}
 	if f := mctx.Filter(); f != nil {
-		filter = compiler.CompileFilter(f)
+		filter = compileFilter(f, getCollation(mctx, dest.Identity(), m.collations))
 	}
 
 	var ands []bson.D
@@ -134,18 +153,11 @@ func (m *mongoManipulator) RetrieveMany(mctx manipulate.Context, dest elemental.
 		ands = append(ands, m.forcedReadFilter)
 	}
 
-	if after := mctx.After(); after != "" {
+	ands = append(ands, getSearchFilters(mctx)...)
 
-		if len(order) > 1 {
-			return manipulate.NewErrCannotBuildQuery("cannot use multiple ordering fields when using 'after'")
-		}
-
-		var o string
-		if len(order) == 1 {
-			o = order[0]
-		}
+	if after := mctx.After(); after != "" {
 
-		f, err := prepareNextFilter(c, o, after)
+		f, err := prepareNextFilter(c, prototypeOf(dest), order, after)
 		if err != nil {
 			return err
 		}
@@ -178,10 +190,27 @@ func (m *mongoManipulator) RetrieveMany(mctx manipulate.Context, dest elemental.
 	}
 
 	// Fields selection
+	var unselected []string
 	if sels := makeFieldsSelector(mctx.Fields()); sels != nil {
+		// We need the ordering fields to build the next cursor.
+		// We remove the ones that were not selected afterwards.
+		if paginated {
+			for _, o := range order {
+				f := strings.TrimPrefix(o, "-")
+				if _, ok := sels[f]; !ok && f != "_id" {
+					unselected = append(unselected, f)
+				}
+				sels[f] = 1
+			}
+		}
 		q = q.Select(sels)
 	}
 
+	// Collation
+	if coll := getCollation(mctx, dest.Identity(), m.collations); coll != nil {
+		q = q.Collation(coll)
+	}
+
 	// Query timing limiting
 	q = q.SetMaxTime(defaultGlobalContextTimeout)
 	if d, ok := mctx.Context().Deadline(); ok {
@@ -209,32 +238,42 @@ func (m *mongoManipulator) RetrieveMany(mctx manipulate.Context, dest elemental.
 		return err
 	}
 
-	var lastID string
-
 	lst := dest.List()
+
+	// We compute the next cursor before backporting
+	// the default values, so it holds the stored values.
+	var next string
+	if paginated && len(lst) > 0 && len(lst) == mctx.Limit() {
+		n, err := encodeNextCursor(lst[len(lst)-1], order)
+		if err != nil {
+			return manipulate.NewErrCannotBuildQuery(fmt.Sprintf("retrievemany: unable to build next cursor: %s", err))
+		}
+		next = n
+	}
+
 	for _, o := range lst {
 
+		for _, f := range unselected {
+			resetFieldByBSONName(o, f)
+		}
+
 		// backport all default values that are empty.
 		if a, ok := o.(elemental.AttributeSpecifiable); ok {
 			elemental.ResetDefaultForZeroValues(a)
 		}
 
 		// Decrypt attributes if needed.
-		if m.attributeEncrypter != nil {
+		if m.attributeEncrypter != nil && !getNoDecryption(mctx) {
 			if a, ok := o.(elemental.AttributeEncryptable); ok {
 				if err := a.DecryptAttributes(m.attributeEncrypter); err != nil {
 					return manipulate.NewErrCannotBuildQuery(fmt.Sprintf("retrievemany: unable to decrypt attributes: %s", err))
 				}
 			}
 		}
-
-		lastID = o.Identifier()
 	}
 
-	if lastID != "" && (mctx.After() != "" || mctx.Limit() > 0) && len(lst) == mctx.Limit() {
-		if lastID != mctx.After() {
-			mctx.SetNext(lastID)
-		}
+	if next != "" && next != mctx.After() {
+		mctx.SetNext(next)
 	}
 
 	return nil
@@ -248,13 +287,13 @@ func (m *mongoManipulator) Retrieve(mctx manipulate.Context, object elemental.Id
 		mctx = manipulate.NewContext(ctx)
 	}
 
-	c, close := m.makeSession(object.Identity(), mctx.ReadConsistency(), mctx.WriteConsistency())
+	c, close := m.makeSession(object.Identity(), readAfterWriteConsistency(mctx, m.readAfterWriteWindow, time.Now()), mctx.WriteConsistency())
 	defer close()
 
 	filter := bson.D{}
 
 	if f := mctx.Filter(); f != nil {
-		filter = compiler.CompileFilter(f)
+		filter = compileFilter(f, getCollation(mctx, object.Identity(), m.collations))
 	}
 
 	if oid, ok := objectid.Parse(object.Identifier()); ok {
@@ -286,6 +325,10 @@ func (m *mongoManipulator) Retrieve(mctx manipulate.Context, object elemental.Id
 		q = q.Select(sels)
 	}
 
+	if coll := getCollation(mctx, object.Identity(), m.collations); coll != nil {
+		q = q.Collation(coll)
+	}
+
 	q = q.SetMaxTime(defaultGlobalContextTimeout)
 	if d, ok := mctx.Context().Deadline(); ok {
 		q = q.SetMaxTime(time.Until(d))
@@ -457,6 +500,8 @@ func (m *mongoManipulator) Create(mctx manipulate.Context, object elemental.Iden
 		}
 	}
 
+	recordWrite(mctx, time.Now())
+
 	if m.sharder != nil {
 		if err := m.sharder.OnShardedWrite(m, mctx, elemental.OperationCreate, object); err != nil {
 			return manipulate.NewErrCannotBuildQuery(fmt.Sprintf("unable to execute sharder.OnShardedWrite on create: %s", err))
@@ -518,6 +563,10 @@ func (m *mongoManipulator) Update(mctx manipulate.Context, object elemental.Iden
 		}
 	}
 
+	if f := getUpdateIf(mctx); f != nil {
+		filter = bson.D{{Name: "$and", Value: []bson.D{filter, f}}}
+	}
+
 	if _, err := RunQuery(
 		mctx,
 		func() (interface{}, error) { return nil, c.Update(filter, bson.M{"$set": object}) },
@@ -532,6 +581,8 @@ func (m *mongoManipulator) Update(mctx manipulate.Context, object elemental.Iden
 		return err
 	}
 
+	recordWrite(mctx, time.Now())
+
 	if encryptable != nil {
 		if err := encryptable.DecryptAttributes(m.attributeEncrypter); err != nil {
 			return manipulate.NewErrCannotBuildQuery(fmt.Sprintf("update: unable to decrypt attributes: %s", err))
@@ -592,6 +643,8 @@ func (m *mongoManipulator) Delete(mctx manipulate.Context, object elemental.Iden
 		return err
 	}
 
+	recordWrite(mctx, time.Now())
+
 	if m.sharder != nil {
 		if err := m.sharder.OnShardedWrite(m, mctx, elemental.OperationDelete, object); err != nil {
 			return manipulate.NewErrCannotBuildQuery(fmt.Sprintf("unable to execute sharder.OnShardedWrite for delete: %s", err))
@@ -620,7 +673,7 @@ func (m *mongoManipulator) DeleteMany(mctx manipulate.Context, identity elementa
 	c, close := m.makeSession(identity, mctx.ReadConsistency(), mctx.WriteConsistency())
 	defer close()
 
-	filter := compiler.CompileFilter(mctx.Filter())
+	filter := compileFilter(mctx.Filter(), getCollation(mctx, identity, m.collations))
 	if m.sharder != nil {
 		sq, err := m.sharder.FilterMany(m, mctx, identity)
 		if err != nil {
@@ -635,9 +688,18 @@ func (m *mongoManipulator) DeleteMany(mctx manipulate.Context, identity elementa
 		filter = bson.D{{Name: "$and", Value: []bson.D{m.forcedReadFilter, filter}}}
 	}
 
+	if sf := getSearchFilters(mctx); len(sf) > 0 {
+		filter = bson.D{{Name: "$and", Value: append(sf, filter)}}
+	}
+
 	if _, err := RunQuery(
 		mctx,
-		func() (interface{}, error) { return c.RemoveAll(filter) },
+		func() (interface{}, error) {
+			if coll := getCollation(mctx, identity, m.collations); coll != nil {
+				return nil, removeAllWithCollation(c, filter, coll)
+			}
+			return c.RemoveAll(filter)
+		},
 		RetryInfo{
 			Operation:        elemental.OperationDelete, // we miss DeleteMany
 			Identity:         identity,
@@ -649,6 +711,8 @@ func (m *mongoManipulator) DeleteMany(mctx manipulate.Context, identity elementa
 		return err
 	}
 
+	recordWrite(mctx, time.Now())
+
 	return nil
 }
 
@@ -660,13 +724,13 @@ func (m *mongoManipulator) Count(mctx manipulate.Context, identity elemental.Ide
 		mctx = manipulate.NewContext(ctx)
 	}
 
-	c, close := m.makeSession(identity, mctx.ReadConsistency(), mctx.WriteConsistency())
+	c, close := m.makeSession(identity, readAfterWriteConsistency(mctx, m.readAfterWriteWindow, time.Now()), mctx.WriteConsistency())
 	defer close()
 
 	filter := bson.D{}
 
 	if f := mctx.Filter(); f != nil {
-		filter = compiler.CompileFilter(f)
+		filter = compileFilter(f, getCollation(mctx, identity, m.collations))
 	}
 
 	if m.sharder != nil {
@@ -683,11 +747,19 @@ func (m *mongoManipulator) Count(mctx manipulate.Context, identity elemental.Ide
 		filter = bson.D{{Name: "$and", Value: []bson.D{m.forcedReadFilter, filter}}}
 	}
 
+	if sf := getSearchFilters(mctx); len(sf) > 0 {
+		filter = bson.D{{Name: "$and", Value: append(sf, filter)}}
+	}
+
 	sp := tracing.StartTrace(mctx, fmt.Sprintf("manipmongo.count.%s", identity.Category))
 	defer sp.Finish()
 
 	q := c.Find(filter).SetMaxTime(defaultGlobalContextTimeout)
 
+	if coll := getCollation(mctx, identity, m.collations); coll != nil {
+		q = q.Collation(coll)
+	}
+
 	if d, ok := mctx.Context().Deadline(); ok {
 		q = q.SetMaxTime(time.Until(d))
 	}
@@ -15,29 +15,33 @@ import (
 	"crypto/tls"
 	"time"
 
+	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 	"go.aporeto.io/elemental"
 	"go.aporeto.io/manipulate"
+	"go.aporeto.io/manipulate/manipmongo/internal/compiler"
 )
 
 // An Option represents a maniphttp.Manipulator option.
 type Option func(*config)
 
 type config struct {
-	username           string
-	password           string
-	authsource         string
-	tlsConfig          *tls.Config
-	poolLimit          int
-	connectTimeout     time.Duration
-	socketTimeout      time.Duration
-	readConsistency    manipulate.ReadConsistency
-	writeConsistency   manipulate.WriteConsistency
-	sharder            Sharder
-	defaultRetryFunc   manipulate.RetryFunc
-	forcedReadFilter   bson.D
-	attributeEncrypter elemental.AttributeEncrypter
-	explain            map[elemental.Identity]map[elemental.Operation]struct{}
+	username             string
+	password             string
+	authsource           string
+	tlsConfig            *tls.Config
+	poolLimit            int
+	connectTimeout       time.Duration
+	socketTimeout        time.Duration
+	readConsistency      manipulate.ReadConsistency
+	writeConsistency     manipulate.WriteConsistency
+	sharder              Sharder
+	defaultRetryFunc     manipulate.RetryFunc
+	forcedReadFilter     bson.D
+	attributeEncrypter   elemental.AttributeEncrypter
+	explain              map[elemental.Identity]map[elemental.Operation]struct{}
+	collations           map[elemental.Identity]*mgo.Collation
+	readAfterWriteWindow time.Duration
 }
 
 func newConfig() *config {
@@ -126,6 +130,7 @@ func OptionForceReadFilter(f bson.D) Option {
 
 // OptionAttributeEncrypter allows to set an elemental.AttributeEncrypter
 // to use to encrypt/decrypt elemental.AttributeEncryptable.
+// To rotate encryption keys, use NewAttributeEncrypterKeyring.
 func OptionAttributeEncrypter(enc elemental.AttributeEncrypter) Option {
 	return func(c *config) {
 		c.attributeEncrypter = enc
@@ -148,7 +153,53 @@ func OptionExplain(explain map[elemental.Identity]map[elemental.Operation]struct
 	}
 }
 
-const opaqueKeyUpsert = "manipmongo.upsert"
+// OptionCollation sets the collation to use when querying the given identity.
+// The collation is used by RetrieveMany, Retrieve, Count and DeleteMany,
+// for both matching and ordering. To be backed by an index, the collation
+// must be the same as the one of the index.
+//
+// For instance, &mgo.Collation{Locale: "en", Strength: 2} makes
+// comparisons case-insensitive.
+//
+// The matches comparator compiles to a regular expression, which
+// ignores the collation. It is made case-insensitive when the strength
+// of the collation is 1 or 2, but it still does not ignore diacritics.
+//
+// This can be overridden per request by using ContextOptionCollation.
+func OptionCollation(identity elemental.Identity, collation *mgo.Collation) Option {
+	return func(c *config) {
+		if c.collations == nil {
+			c.collations = map[elemental.Identity]*mgo.Collation{}
+		}
+		c.collations[identity] = collation
+	}
+}
+
+// OptionReadAfterWriteWindow sets the maximum replication lag expected
+// between the primary and the secondaries. Reads done after a write (see
+// ContextOptionReadAfterWrite) are sent to the primary until that duration
+// has elapsed since the write. The elapsed time is computed using the clocks
+// of the writer and the reader, so the window must also account for the clock
+// skew between them.
+//
+// The default is zero, meaning reads after a write are always sent to the
+// primary. This is the only setting that guarantees reading the write if
+// replication lag or clock skew are unbounded.
+func OptionReadAfterWriteWindow(window time.Duration) Option {
+	return func(c *config) {
+		c.readAfterWriteWindow = window
+	}
+}
+
+const (
+	opaqueKeyUpsert         = "manipmongo.upsert"
+	opaqueKeySearchFilter   = "manipmongo.search"
+	opaqueKeyCollation      = "manipmongo.collation"
+	opaqueKeyReadAfterWrite = "manipmongo.readafterwrite"
+	opaqueKeyWriteToken     = "manipmongo.writetoken"
+	opaqueKeyNoDecryption   = "manipmongo.nodecryption"
+	opaqueKeyUpdateIf       = "manipmongo.update.if"
+)
 
 type opaquer interface {
 	Opaque() map[string]interface{}
@@ -178,3 +229,113 @@ func ContextOptionUpsert(operations bson.M) manipulate.ContextOption {
 		c.(opaquer).Opaque()[opaqueKeyUpsert] = operations
 	}
 }
+
+// ContextOptionTextSearch adds a full text search to a RetrieveMany,
+// Count or DeleteMany operation. The collection must have a text index.
+// If language is empty, the default language of the text index is used.
+func ContextOptionTextSearch(search string, language string, caseSensitive bool) manipulate.ContextOption {
+
+	return func(c manipulate.Context) {
+		addSearchFilter(c, compiler.CompileTextSearch(search, language, caseSensitive))
+	}
+}
+
+// ContextOptionGeoWithin restricts a RetrieveMany, Count or DeleteMany operation
+// to the objects whose given attribute is within the given GeoJSON geometry,
+// like bson.M{"type": "Polygon", "coordinates": ...}.
+func ContextOptionGeoWithin(attribute string, geometry interface{}) manipulate.ContextOption {
+
+	return func(c manipulate.Context) {
+		addSearchFilter(c, compiler.CompileGeoWithin(attribute, geometry))
+	}
+}
+
+// ContextOptionNear restricts a RetrieveMany or DeleteMany operation
+// to the objects whose given attribute is near the given point, from the
+// nearest to the farthest. Distances are in meters and are ignored when they are
+// less or equal to zero. The collection must have a 2dsphere index on the attribute.
+// As the results are sorted by distance, the default order of the identity is ignored,
+// manipulate.ContextOptionLimit does not return a next cursor, and RetrieveMany returns
+// an error when it is used with manipulate.ContextOptionOrder or manipulate.ContextOptionAfter.
+// It cannot be used with Count.
+func ContextOptionNear(attribute string, longitude float64, latitude float64, minDistance float64, maxDistance float64) manipulate.ContextOption {
+
+	return func(c manipulate.Context) {
+		addSearchFilter(c, compiler.CompileNear(attribute, longitude, latitude, minDistance, maxDistance))
+	}
+}
+
+// ContextOptionCollation sets the collation to use for a RetrieveMany, Retrieve,
+// Count or DeleteMany operation. It takes precedence over the collation set
+// for the identity with OptionCollation.
+func ContextOptionCollation(collation *mgo.Collation) manipulate.ContextOption {
+
+	return func(c manipulate.Context) {
+		c.(opaquer).Opaque()[opaqueKeyCollation] = collation
+	}
+}
+
+// ContextOptionRecordWrite makes the write operations (Create, Update,
+// Delete and DeleteMany) using this option record the time of their success
+// in the given WriteToken. The manipulate.Context itself is not modified, so
+// it can be shared by concurrent operations.
+func ContextOptionRecordWrite(token *WriteToken) manipulate.ContextOption {
+
+	if token == nil {
+		panic("nil write token")
+	}
+
+	return func(c manipulate.Context) {
+		c.(opaquer).Opaque()[opaqueKeyWriteToken] = token
+	}
+}
+
+// ContextOptionReadAfterWrite sets the token returned by WriteToken.String
+// after a write operation. A RetrieveMany, Retrieve or Count operation using
+// this option is sent to the primary, whatever the requested read consistency
+// is, so it sees that write as long as it has been acknowledged by the primary
+// (i.e. the write consistency was not manipulate.WriteConsistencyNone).
+//
+// This is not causal consistency: reads are not served by the secondaries
+// that have replicated the write, but by the primary. The token holds the wall
+// clock time of the process that did the write. If OptionReadAfterWriteWindow
+// is set, reads go back to the requested read consistency once the window has
+// elapsed since that time, according to the clock of the process doing the read.
+// This assumes that the clocks of the processes sharing tokens are synchronized
+// well within the window, and that the replication lag stays below it. If the
+// clock of the reader is behind, the read is sent to the primary for longer.
+// If it is ahead, the read may go to a secondary that has not replicated the
+// write. Invalid tokens always fall back to reading from the primary.
+func ContextOptionReadAfterWrite(token string) manipulate.ContextOption {
+
+	return func(c manipulate.Context) {
+		c.(opaquer).Opaque()[opaqueKeyReadAfterWrite] = token
+	}
+}
+
+// contextOptionNoDecryption makes RetrieveMany return
+// the encrypted attributes as they are stored.
+func contextOptionNoDecryption() manipulate.ContextOption {
+
+	return func(c manipulate.Context) {
+		c.(opaquer).Opaque()[opaqueKeyNoDecryption] = true
+	}
+}
+
+// contextOptionUpdateIf makes Update only update the object if
+// it still matches the given filter. Otherwise, Update returns
+// a manipulate.ErrObjectNotFound.
+func contextOptionUpdateIf(filter bson.D) manipulate.ContextOption {
+
+	return func(c manipulate.Context) {
+		c.(opaquer).Opaque()[opaqueKeyUpdateIf] = filter
+	}
+}
+
+func addSearchFilter(c manipulate.Context, filter bson.D) {
+
+	opaque := c.(opaquer).Opaque()
+	existing, _ := opaque[opaqueKeySearchFilter].([]bson.D)
+
+	opaque[opaqueKeySearchFilter] = append(append([]bson.D{}, existing...), filter)
+}
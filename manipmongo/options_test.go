@@ -17,6 +17,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 	. "github.com/smartystreets/goconvey/convey"
 	"go.aporeto.io/elemental"
@@ -138,6 +139,20 @@ func Test_Options(t *testing.T) {
 		OptionExplain(m)(c)
 		So(c.explain, ShouldEqual, m)
 	})
+
+	Convey("Calling OptionReadAfterWriteWindow should work", t, func() {
+		c := newConfig()
+		OptionReadAfterWriteWindow(12 * time.Second)(c)
+		So(c.readAfterWriteWindow, ShouldEqual, 12*time.Second)
+	})
+
+	Convey("Calling OptionCollation should work", t, func() {
+		coll := &mgo.Collation{Locale: "en", Strength: 2}
+		identity := elemental.MakeIdentity("thing", "things")
+		c := newConfig()
+		OptionCollation(identity, coll)(c)
+		So(c.collations[identity], ShouldEqual, coll)
+	})
 }
 
 func Test_ContextOptions(t *testing.T) {
@@ -160,4 +175,55 @@ func Test_ContextOptions(t *testing.T) {
 		b := bson.M{"$setOnInsert": bson.M{"_id": 1}}
 		So(func() { ContextOptionUpsert(b)(nil) }, ShouldPanicWith, "cannot use $setOnInsert on _id in upsert operations")
 	})
+
+	Convey("Calling ContextOptionCollation should work", t, func() {
+		coll := &mgo.Collation{Locale: "en", Strength: 2}
+		mctx := manipulate.NewContext(context.Background())
+		ContextOptionCollation(coll)(mctx)
+		So(mctx.(opaquer).Opaque()[opaqueKeyCollation], ShouldEqual, coll)
+	})
+
+	Convey("Calling ContextOptionReadAfterWrite should work", t, func() {
+		mctx := manipulate.NewContext(context.Background())
+		ContextOptionReadAfterWrite("token")(mctx)
+		So(mctx.(opaquer).Opaque()[opaqueKeyReadAfterWrite], ShouldEqual, "token")
+	})
+
+	Convey("Calling ContextOptionRecordWrite should work", t, func() {
+		token := &WriteToken{}
+		mctx := manipulate.NewContext(context.Background())
+		ContextOptionRecordWrite(token)(mctx)
+		So(mctx.(opaquer).Opaque()[opaqueKeyWriteToken], ShouldEqual, token)
+	})
+
+	Convey("Calling ContextOptionRecordWrite with a nil token should panic", t, func() {
+		So(func() { ContextOptionRecordWrite(nil) }, ShouldPanicWith, "nil write token")
+	})
+
+	Convey("Calling ContextOptionTextSearch should work", t, func() {
+		mctx := manipulate.NewContext(context.Background())
+		ContextOptionTextSearch("hello", "", false)(mctx)
+		So(mctx.(opaquer).Opaque()[opaqueKeySearchFilter], ShouldResemble, []bson.D{
+			{{Name: "$text", Value: bson.D{{Name: "$search", Value: "hello"}}}},
+		})
+	})
+
+	Convey("Calling ContextOptionGeoWithin should work", t, func() {
+		g := bson.M{"type": "Polygon"}
+		mctx := manipulate.NewContext(context.Background())
+		ContextOptionGeoWithin("location", g)(mctx)
+		So(mctx.(opaquer).Opaque()[opaqueKeySearchFilter], ShouldResemble, []bson.D{
+			{{Name: "location", Value: bson.D{{Name: "$geoWithin", Value: bson.D{{Name: "$geometry", Value: g}}}}}},
+		})
+	})
+
+	Convey("Calling multiple search context options should accumulate the filters", t, func() {
+		mctx := manipulate.NewContext(
+			context.Background(),
+			ContextOptionTextSearch("hello", "", false),
+			ContextOptionNear("location", 1, 2, 0, 10),
+		)
+		So(len(mctx.(opaquer).Opaque()[opaqueKeySearchFilter].([]bson.D)), ShouldEqual, 2)
+		So(getSearchFilters(mctx), ShouldResemble, mctx.(opaquer).Opaque()[opaqueKeySearchFilter])
+	})
 }
@@ -0,0 +1,102 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manipmongo
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"go.aporeto.io/manipulate"
+)
+
+// A WriteToken records when the last successful write operation (Create,
+// Update, Delete or DeleteMany) made with a manipulate.Context has been done,
+// so that later reads can be sent to the primary to see it. It is set on a
+// manipulate.Context using ContextOptionRecordWrite.
+//
+// This is not causal consistency: the token holds the wall clock time of the
+// process that did the write, not a cluster time, as the MongoDB driver used by
+// manipmongo does not support causally consistent sessions. See
+// ContextOptionReadAfterWrite. A WriteToken is safe for concurrent use.
+type WriteToken struct {
+	value string
+	lock  sync.RWMutex
+}
+
+// String returns the token, to be given to ContextOptionReadAfterWrite.
+// It returns an empty string if no write has been done.
+func (t *WriteToken) String() string {
+
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.value
+}
+
+func (t *WriteToken) set(now time.Time) {
+
+	t.lock.Lock()
+	t.value = strconv.FormatInt(now.UnixNano(), 36)
+	t.lock.Unlock()
+}
+
+// recordWrite records the given time in the WriteToken
+// set in the given manipulate.Context, if any.
+func recordWrite(mctx manipulate.Context, now time.Time) {
+
+	o, ok := mctx.(opaquer)
+	if !ok {
+		return
+	}
+
+	if token, ok := o.Opaque()[opaqueKeyWriteToken].(*WriteToken); ok && token != nil {
+		token.set(now)
+	}
+}
+
+// readAfterWriteConsistency returns the read consistency to use for a read
+// operation done with the given manipulate.Context.
+//
+// If the manipulate.Context has a write token, the read is sent to the primary
+// until the given window has elapsed since the write, after which every member is
+// expected to have replicated it. The token holds the clock of the writer and is
+// compared to the given time, so this relies on the clocks being synchronized.
+// A zero window always sends it to the primary. Invalid tokens, and tokens from
+// the future, are handled like fresh ones.
+func readAfterWriteConsistency(mctx manipulate.Context, window time.Duration, now time.Time) manipulate.ReadConsistency {
+
+	o, ok := mctx.(opaquer)
+	if !ok {
+		return mctx.ReadConsistency()
+	}
+
+	token, _ := o.Opaque()[opaqueKeyReadAfterWrite].(string)
+	if token == "" {
+		return mctx.ReadConsistency()
+	}
+
+	if window <= 0 {
+		return manipulate.ReadConsistencyStrong
+	}
+
+	ts, err := strconv.ParseInt(token, 36, 64)
+	if err != nil {
+		return manipulate.ReadConsistencyStrong
+	}
+
+	if now.Sub(time.Unix(0, ts)) < window {
+		return manipulate.ReadConsistencyStrong
+	}
+
+	return mctx.ReadConsistency()
+}
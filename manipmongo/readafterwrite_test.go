@@ -0,0 +1,124 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manipmongo
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/manipulate"
+)
+
+func TestWriteToken(t *testing.T) {
+
+	Convey("Given I have a context recording writes with no write", t, func() {
+
+		token := &WriteToken{}
+		manipulate.NewContext(context.Background(), ContextOptionRecordWrite(token))
+
+		Convey("Then the token should be empty", func() {
+			So(token.String(), ShouldEqual, "")
+		})
+	})
+
+	Convey("Given I have a context recording writes shared by concurrent writes", t, func() {
+
+		wt := &WriteToken{}
+		mctx := manipulate.NewContext(context.Background(), ContextOptionRecordWrite(wt))
+
+		now := time.Now()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				recordWrite(mctx, now)
+				_ = mctx.Derive()
+			}()
+		}
+		wg.Wait()
+
+		token := wt.String()
+
+		Convey("Then the token should not be empty", func() {
+			So(token, ShouldNotBeEmpty)
+		})
+
+		Convey("When I read with the token and a zero window", func() {
+
+			rmctx := manipulate.NewContext(
+				context.Background(),
+				manipulate.ContextOptionReadConsistency(manipulate.ReadConsistencyEventual),
+				ContextOptionReadAfterWrite(token),
+			)
+
+			Convey("Then the read consistency should be strong", func() {
+				So(readAfterWriteConsistency(rmctx, 0, now.Add(time.Hour)), ShouldEqual, manipulate.ReadConsistencyStrong)
+			})
+		})
+
+		Convey("When I read with the token within the window", func() {
+
+			rmctx := manipulate.NewContext(
+				context.Background(),
+				manipulate.ContextOptionReadConsistency(manipulate.ReadConsistencyEventual),
+				ContextOptionReadAfterWrite(token),
+			)
+
+			Convey("Then the read consistency should be strong", func() {
+				So(readAfterWriteConsistency(rmctx, 10*time.Second, now.Add(5*time.Second)), ShouldEqual, manipulate.ReadConsistencyStrong)
+			})
+		})
+
+		Convey("When I read with the token after the window", func() {
+
+			rmctx := manipulate.NewContext(
+				context.Background(),
+				manipulate.ContextOptionReadConsistency(manipulate.ReadConsistencyEventual),
+				ContextOptionReadAfterWrite(token),
+			)
+
+			Convey("Then the read consistency should be the requested one", func() {
+				So(readAfterWriteConsistency(rmctx, 10*time.Second, now.Add(11*time.Second)), ShouldEqual, manipulate.ReadConsistencyEventual)
+			})
+		})
+
+		Convey("When I read with an invalid token", func() {
+
+			rmctx := manipulate.NewContext(
+				context.Background(),
+				manipulate.ContextOptionReadConsistency(manipulate.ReadConsistencyEventual),
+				ContextOptionReadAfterWrite("not a token!"),
+			)
+
+			Convey("Then the read consistency should be strong", func() {
+				So(readAfterWriteConsistency(rmctx, 10*time.Second, now), ShouldEqual, manipulate.ReadConsistencyStrong)
+			})
+		})
+
+		Convey("When I read without token", func() {
+
+			rmctx := manipulate.NewContext(
+				context.Background(),
+				manipulate.ContextOptionReadConsistency(manipulate.ReadConsistencyEventual),
+			)
+
+			Convey("Then the read consistency should be the requested one", func() {
+				So(readAfterWriteConsistency(rmctx, 0, now), ShouldEqual, manipulate.ReadConsistencyEventual)
+			})
+		})
+	})
+}
@@ -0,0 +1,235 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manipmongo
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/manipulate"
+)
+
+const namespaceField = "namespace"
+
+// namespaceHashSharder is a Sharder that stores a hash of the
+// namespace of the objects in a dedicated field.
+type namespaceHashSharder struct {
+	field string
+}
+
+// NewNamespaceHashSharder returns a Sharder that shards objects using a
+// hash of their namespace. The hash is stored in the field with the given
+// bson name, which must exist in the stored objects and must be an integer.
+//
+// If the object namespace is empty during creation, the namespace of the
+// manipulate.Context will be used.
+//
+// Queries on a single object are targeted to the shard of the object
+// namespace when it is set. Otherwise, like the other queries, they are
+// targeted to a single shard when the manipulate.Context has a namespace
+// and is not recursive, or broadcasted.
+func NewNamespaceHashSharder(field string) Sharder {
+
+	if field == "" {
+		panic("field must not be empty")
+	}
+
+	return &namespaceHashSharder{
+		field: field,
+	}
+}
+
+func (s *namespaceHashSharder) Shard(_ manipulate.TransactionalManipulator, mctx manipulate.Context, object elemental.Identifiable) error {
+
+	ns := objectNamespace(object)
+
+	if ns == "" && mctx != nil {
+		ns = mctx.Namespace()
+	}
+
+	if ns == "" {
+		return fmt.Errorf("unable to find the namespace of object '%s'", object.Identifier())
+	}
+
+	return setFieldByBSONName(object, s.field, hashShardKey(ns))
+}
+
+func (s *namespaceHashSharder) OnShardedWrite(manipulate.TransactionalManipulator, manipulate.Context, elemental.Operation, elemental.Identifiable) error {
+	return nil
+}
+
+func (s *namespaceHashSharder) FilterOne(_ manipulate.TransactionalManipulator, mctx manipulate.Context, object elemental.Identifiable) (bson.D, error) {
+
+	// Like in Shard, the namespace of the object comes first.
+	if ns := objectNamespace(object); ns != "" {
+		return bson.D{{Name: s.field, Value: hashShardKey(ns)}}, nil
+	}
+
+	return s.filter(mctx), nil
+}
+
+func (s *namespaceHashSharder) FilterMany(_ manipulate.TransactionalManipulator, mctx manipulate.Context, _ elemental.Identity) (bson.D, error) {
+	return s.filter(mctx), nil
+}
+
+func (s *namespaceHashSharder) filter(mctx manipulate.Context) bson.D {
+
+	if mctx == nil || mctx.Recursive() || mctx.Namespace() == "" {
+		return nil
+	}
+
+	return bson.D{{Name: s.field, Value: hashShardKey(mctx.Namespace())}}
+}
+
+// attributeSharder is a Sharder that uses the value of an
+// attribute of the objects as the shard key.
+type attributeSharder struct {
+	field string
+}
+
+// NewAttributeSharder returns a Sharder that uses the value of the attribute
+// with the given bson name as the shard key. As the value is used as is, this
+// is suited for range based sharding.
+//
+// Shard will return an error if the attribute is not set on the object.
+// If the attribute is the namespace, the namespace of the manipulate.Context will
+// be used when the object has none.
+//
+// FilterOne targets the shard using the value of the attribute if it is set
+// on the object. FilterMany targets the shard if the manipulate.Context filter
+// contains an equality or an inclusion on the attribute at its top level or,
+// if the attribute is the namespace, using the namespace of the manipulate.Context.
+// Otherwise, queries are broadcasted.
+func NewAttributeSharder(field string) Sharder {
+
+	if field == "" {
+		panic("field must not be empty")
+	}
+
+	return &attributeSharder{
+		field: field,
+	}
+}
+
+func (s *attributeSharder) Shard(_ manipulate.TransactionalManipulator, mctx manipulate.Context, object elemental.Identifiable) error {
+
+	v, err := getFieldByBSONName(object, s.field)
+	if err != nil {
+		return err
+	}
+
+	if !isZeroShardKey(v) {
+		return nil
+	}
+
+	if s.field == namespaceField && mctx != nil && mctx.Namespace() != "" {
+		return setFieldByBSONName(object, s.field, mctx.Namespace())
+	}
+
+	return fmt.Errorf("missing value for shard key '%s' on object '%s'", s.field, object.Identifier())
+}
+
+func (s *attributeSharder) OnShardedWrite(manipulate.TransactionalManipulator, manipulate.Context, elemental.Operation, elemental.Identifiable) error {
+	return nil
+}
+
+func (s *attributeSharder) FilterOne(_ manipulate.TransactionalManipulator, mctx manipulate.Context, object elemental.Identifiable) (bson.D, error) {
+
+	if v, err := getFieldByBSONName(object, s.field); err == nil && !isZeroShardKey(v) {
+		return bson.D{{Name: s.field, Value: v}}, nil
+	}
+
+	if s.field == namespaceField && mctx != nil && !mctx.Recursive() && mctx.Namespace() != "" {
+		return bson.D{{Name: s.field, Value: mctx.Namespace()}}, nil
+	}
+
+	return nil, nil
+}
+
+func (s *attributeSharder) FilterMany(_ manipulate.TransactionalManipulator, mctx manipulate.Context, _ elemental.Identity) (bson.D, error) {
+
+	if mctx == nil {
+		return nil, nil
+	}
+
+	if f := mctx.Filter(); f != nil {
+
+		for i, operator := range f.Operators() {
+
+			if operator != elemental.AndOperator || strings.ToLower(f.Keys()[i]) != s.field {
+				continue
+			}
+
+			switch f.Comparators()[i] {
+
+			case elemental.EqualComparator:
+				return bson.D{{Name: s.field, Value: f.Values()[i][0]}}, nil
+
+			case elemental.InComparator:
+				return bson.D{{Name: s.field, Value: bson.D{{Name: "$in", Value: f.Values()[i]}}}}, nil
+			}
+		}
+	}
+
+	if s.field == namespaceField && !mctx.Recursive() && mctx.Namespace() != "" {
+		return bson.D{{Name: s.field, Value: mctx.Namespace()}}, nil
+	}
+
+	return nil, nil
+}
+
+// objectNamespace returns the namespace of the given
+// object, or an empty string if it has none.
+func objectNamespace(object elemental.Identifiable) string {
+
+	if object == nil {
+		return ""
+	}
+
+	v, err := getFieldByBSONName(object, namespaceField)
+	if err != nil {
+		return ""
+	}
+
+	ns, _ := v.(string)
+
+	return ns
+}
+
+func hashShardKey(value string) int64 {
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(value)) // nolint: errcheck
+
+	return int64(h.Sum64())
+}
+
+func isZeroShardKey(v interface{}) bool {
+
+	switch tv := v.(type) {
+	case nil:
+		return true
+	case string:
+		return tv == ""
+	case int:
+		return tv == 0
+	case int64:
+		return tv == 0
+	case int32:
+		return tv == 0
+	default:
+		return false
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manipmongo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/manipulate"
+)
+
+var shardedIdentity = elemental.MakeIdentity("sharded", "shardeds")
+
+type shardedObject struct {
+	ID        string `bson:"_id"`
+	Namespace string `bson:"namespace"`
+	ZHash     int64  `bson:"zhash"`
+	Zone      int
+	Name      string `bson:"-"`
+}
+
+func (o *shardedObject) Identity() elemental.Identity { return shardedIdentity }
+func (o *shardedObject) Identifier() string           { return o.ID }
+func (o *shardedObject) SetIdentifier(id string)      { o.ID = id }
+func (o *shardedObject) Version() int                 { return 1 }
+
+func TestNewNamespaceHashSharder(t *testing.T) {
+
+	Convey("Calling NewNamespaceHashSharder with an empty field should panic", t, func() {
+		So(func() { NewNamespaceHashSharder("") }, ShouldPanicWith, "field must not be empty")
+	})
+
+	Convey("Given I have a namespace hash sharder", t, func() {
+
+		s := NewNamespaceHashSharder("zhash")
+
+		Convey("When I call Shard on an object with a namespace", func() {
+
+			o := &shardedObject{Namespace: "/a"}
+			err := s.Shard(nil, manipulate.NewContext(context.Background(), manipulate.ContextOptionNamespace("/b")), o)
+
+			Convey("Then the shard key should be set from the object namespace", func() {
+				So(err, ShouldBeNil)
+				So(o.ZHash, ShouldEqual, hashShardKey("/a"))
+			})
+		})
+
+		Convey("When I call Shard on an object with no namespace", func() {
+
+			o := &shardedObject{}
+			err := s.Shard(nil, manipulate.NewContext(context.Background(), manipulate.ContextOptionNamespace("/b")), o)
+
+			Convey("Then the shard key should be set from the context namespace", func() {
+				So(err, ShouldBeNil)
+				So(o.ZHash, ShouldEqual, hashShardKey("/b"))
+			})
+		})
+
+		Convey("When I call Shard without any namespace", func() {
+
+			o := &shardedObject{ID: "x"}
+			err := s.Shard(nil, manipulate.NewContext(context.Background()), o)
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "unable to find the namespace of object 'x'")
+			})
+		})
+
+		Convey("When I call Shard on a sharder with a missing field", func() {
+
+			o := &shardedObject{Namespace: "/a"}
+			err := NewNamespaceHashSharder("nope").Shard(nil, nil, o)
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "unable to find field 'nope' in object")
+			})
+		})
+
+		Convey("When I call FilterOne and FilterMany with a namespace", func() {
+
+			mctx := manipulate.NewContext(context.Background(), manipulate.ContextOptionNamespace("/a"))
+			f1, err1 := s.FilterOne(nil, mctx, &shardedObject{})
+			f2, err2 := s.FilterMany(nil, mctx, shardedIdentity)
+
+			Convey("Then the filters should be correct", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+				So(f1, ShouldResemble, bson.D{{Name: "zhash", Value: hashShardKey("/a")}})
+				So(f2, ShouldResemble, bson.D{{Name: "zhash", Value: hashShardKey("/a")}})
+			})
+		})
+
+		Convey("When I call FilterOne on an object whose namespace differs from the context", func() {
+
+			mctx := manipulate.NewContext(context.Background(), manipulate.ContextOptionNamespace("/a"))
+			f, err := s.FilterOne(nil, mctx, &shardedObject{Namespace: "/a/b"})
+
+			Convey("Then the filter should use the object namespace", func() {
+				So(err, ShouldBeNil)
+				So(f, ShouldResemble, bson.D{{Name: "zhash", Value: hashShardKey("/a/b")}})
+			})
+		})
+
+		Convey("When I call FilterOne on an object with a namespace and a recursive context", func() {
+
+			mctx := manipulate.NewContext(
+				context.Background(),
+				manipulate.ContextOptionNamespace("/a"),
+				manipulate.ContextOptionRecursive(true),
+			)
+			f, err := s.FilterOne(nil, mctx, &shardedObject{Namespace: "/a/b"})
+
+			Convey("Then the filter should use the object namespace", func() {
+				So(err, ShouldBeNil)
+				So(f, ShouldResemble, bson.D{{Name: "zhash", Value: hashShardKey("/a/b")}})
+			})
+		})
+
+		Convey("When I call FilterOne and FilterMany with a recursive context", func() {
+
+			mctx := manipulate.NewContext(
+				context.Background(),
+				manipulate.ContextOptionNamespace("/a"),
+				manipulate.ContextOptionRecursive(true),
+			)
+			f1, _ := s.FilterOne(nil, mctx, &shardedObject{})
+			f2, _ := s.FilterMany(nil, mctx, shardedIdentity)
+
+			Convey("Then the filters should be nil", func() {
+				So(f1, ShouldBeNil)
+				So(f2, ShouldBeNil)
+			})
+		})
+
+		Convey("When I call FilterMany without namespace", func() {
+
+			f, _ := s.FilterMany(nil, manipulate.NewContext(context.Background()), shardedIdentity)
+
+			Convey("Then the filter should be nil", func() {
+				So(f, ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestNewAttributeSharder(t *testing.T) {
+
+	Convey("Calling NewAttributeSharder with an empty field should panic", t, func() {
+		So(func() { NewAttributeSharder("") }, ShouldPanicWith, "field must not be empty")
+	})
+
+	Convey("Given I have an attribute sharder on a non namespace attribute", t, func() {
+
+		s := NewAttributeSharder("zone")
+
+		Convey("When I call Shard on an object with the attribute set", func() {
+
+			err := s.Shard(nil, nil, &shardedObject{Zone: 2})
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When I call Shard on an object without the attribute set", func() {
+
+			err := s.Shard(nil, manipulate.NewContext(context.Background()), &shardedObject{ID: "x"})
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "missing value for shard key 'zone' on object 'x'")
+			})
+		})
+
+		Convey("When I call FilterOne on an object with the attribute set", func() {
+
+			f, err := s.FilterOne(nil, nil, &shardedObject{Zone: 2})
+
+			Convey("Then the filter should be correct", func() {
+				So(err, ShouldBeNil)
+				So(f, ShouldResemble, bson.D{{Name: "zone", Value: 2}})
+			})
+		})
+
+		Convey("When I call FilterOne on an object without the attribute set", func() {
+
+			f, err := s.FilterOne(nil, manipulate.NewContext(context.Background()), &shardedObject{})
+
+			Convey("Then the filter should be nil", func() {
+				So(err, ShouldBeNil)
+				So(f, ShouldBeNil)
+			})
+		})
+
+		Convey("When I call FilterMany with an equal filter on the attribute", func() {
+
+			mctx := manipulate.NewContext(
+				context.Background(),
+				manipulate.ContextOptionFilter(elemental.NewFilterComposer().WithKey("name").Equals("a").WithKey("Zone").Equals(3).Done()),
+			)
+			f, err := s.FilterMany(nil, mctx, shardedIdentity)
+
+			Convey("Then the filter should be correct", func() {
+				So(err, ShouldBeNil)
+				So(f, ShouldResemble, bson.D{{Name: "zone", Value: 3}})
+			})
+		})
+
+		Convey("When I call FilterMany with an in filter on the attribute", func() {
+
+			mctx := manipulate.NewContext(
+				context.Background(),
+				manipulate.ContextOptionFilter(elemental.NewFilterComposer().WithKey("zone").In(1, 2).Done()),
+			)
+			f, err := s.FilterMany(nil, mctx, shardedIdentity)
+
+			Convey("Then the filter should be correct", func() {
+				So(err, ShouldBeNil)
+				So(f, ShouldResemble, bson.D{{Name: "zone", Value: bson.D{{Name: "$in", Value: []interface{}{1, 2}}}}})
+			})
+		})
+
+		Convey("When I call FilterMany with no filter on the attribute", func() {
+
+			mctx := manipulate.NewContext(
+				context.Background(),
+				manipulate.ContextOptionNamespace("/a"),
+				manipulate.ContextOptionFilter(elemental.NewFilterComposer().WithKey("name").Equals("a").Done()),
+			)
+			f, err := s.FilterMany(nil, mctx, shardedIdentity)
+
+			Convey("Then the filter should be nil", func() {
+				So(err, ShouldBeNil)
+				So(f, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given I have an attribute sharder on the namespace", t, func() {
+
+		s := NewAttributeSharder("namespace")
+
+		Convey("When I call Shard on an object without namespace", func() {
+
+			o := &shardedObject{}
+			err := s.Shard(nil, manipulate.NewContext(context.Background(), manipulate.ContextOptionNamespace("/a")), o)
+
+			Convey("Then the namespace should be set from the context", func() {
+				So(err, ShouldBeNil)
+				So(o.Namespace, ShouldEqual, "/a")
+			})
+		})
+
+		Convey("When I call FilterOne and FilterMany with a namespace", func() {
+
+			mctx := manipulate.NewContext(context.Background(), manipulate.ContextOptionNamespace("/a"))
+			f1, _ := s.FilterOne(nil, mctx, &shardedObject{})
+			f2, _ := s.FilterMany(nil, mctx, shardedIdentity)
+
+			Convey("Then the filters should be correct", func() {
+				So(f1, ShouldResemble, bson.D{{Name: "namespace", Value: "/a"}})
+				So(f2, ShouldResemble, bson.D{{Name: "namespace", Value: "/a"}})
+			})
+		})
+
+		Convey("When I call FilterMany with a recursive context", func() {
+
+			mctx := manipulate.NewContext(
+				context.Background(),
+				manipulate.ContextOptionNamespace("/a"),
+				manipulate.ContextOptionRecursive(true),
+			)
+			f, _ := s.FilterMany(nil, mctx, shardedIdentity)
+
+			Convey("Then the filter should be nil", func() {
+				So(f, ShouldBeNil)
+			})
+		})
+	})
+}
@@ -12,17 +12,21 @@
 package manipmongo
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net"
+	"reflect"
 	"strings"
+	"time"
 
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 	"go.aporeto.io/elemental"
 	"go.aporeto.io/manipulate"
 	"go.aporeto.io/manipulate/internal/objectid"
+	"go.aporeto.io/manipulate/manipmongo/internal/compiler"
 )
 
 func applyOrdering(order []string) []string {
@@ -49,7 +53,218 @@ func applyOrdering(order []string) []string {
 	return o
 }
 
-func prepareNextFilter(collection *mgo.Collection, orderingField string, next string) (bson.D, error) {
+// prototypeOf returns a new object of the type held by
+// the given list, or nil if it cannot be determined.
+func prototypeOf(dest elemental.Identifiables) interface{} {
+
+	t := reflect.Indirect(reflect.ValueOf(dest)).Type()
+	if t.Kind() != reflect.Slice {
+		return nil
+	}
+
+	t = t.Elem()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	return reflect.New(t).Interface()
+}
+
+// cursorOrdering returns the given ordering with _id appended
+// as tie-breaker if it is not already part of it, so that the
+// resulting ordering is total and can be used to build cursors.
+func cursorOrdering(order []string) []string {
+
+	for _, o := range order {
+		if strings.TrimPrefix(o, "-") == "_id" {
+			return order
+		}
+	}
+
+	return append(append([]string{}, order...), "_id")
+}
+
+// encodeNextCursor returns an opaque cursor holding the values of the
+// given object for every field of the given ordering.
+func encodeNextCursor(object elemental.Identifiable, order []string) (string, error) {
+
+	data, err := bson.Marshal(object)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal object: %s", err)
+	}
+
+	doc := bson.M{}
+	if err = bson.Unmarshal(data, &doc); err != nil {
+		return "", fmt.Errorf("unable to unmarshal object: %s", err)
+	}
+
+	cursor := make(bson.D, len(order))
+	for i, o := range order {
+
+		f := strings.TrimPrefix(o, "-")
+
+		if f == "_id" {
+			if oid, ok := objectid.Parse(object.Identifier()); ok {
+				cursor[i] = bson.DocElem{Name: f, Value: oid}
+			} else {
+				cursor[i] = bson.DocElem{Name: f, Value: object.Identifier()}
+			}
+			continue
+		}
+
+		cursor[i] = bson.DocElem{Name: f, Value: lookupBSONPath(doc, f)}
+	}
+
+	data, err = bson.Marshal(cursor)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal cursor: %s", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeNextCursor decodes the given cursor made by encodeNextCursor.
+// It returns false if the given string is not a cursor, and an
+// error if the cursor has not been made for the given ordering.
+// As the cursor is given by the client, it also returns an error if
+// one of its values is not a scalar, or does not match the type of
+// the corresponding field of the given prototype object.
+func decodeNextCursor(next string, order []string, proto interface{}) (bson.D, bool, error) {
+
+	data, err := base64.RawURLEncoding.DecodeString(next)
+	if err != nil {
+		return nil, false, nil
+	}
+
+	cursor := bson.D{}
+	if err = bson.Unmarshal(data, &cursor); err != nil {
+		return nil, false, nil
+	}
+
+	if len(cursor) != len(order) {
+		return nil, true, fmt.Errorf("cursor does not match the requested ordering")
+	}
+
+	for i, o := range order {
+		if cursor[i].Name != strings.TrimPrefix(o, "-") {
+			return nil, true, fmt.Errorf("cursor does not match the requested ordering")
+		}
+
+		if err := checkCursorValue(proto, cursor[i].Name, cursor[i].Value); err != nil {
+			return nil, true, err
+		}
+	}
+
+	return cursor, true, nil
+}
+
+// checkCursorValue returns an error if the given value of the given
+// field of a cursor cannot be used to build the next filter. Values
+// must be scalars, or arrays of scalars, so they cannot hold query
+// operators. The _id must be an ObjectId or a string, and other
+// values must match the type of the field of the given prototype
+// object, when it can be found.
+func checkCursorValue(proto interface{}, name string, value interface{}) error {
+
+	if !isCursorScalar(value) {
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("invalid value for field '%s' in cursor", name)
+		}
+		for _, item := range items {
+			if !isCursorScalar(item) {
+				return fmt.Errorf("invalid value for field '%s' in cursor", name)
+			}
+		}
+		return nil
+	}
+
+	if value == nil {
+		return nil
+	}
+
+	if name == "_id" {
+		switch value.(type) {
+		case bson.ObjectId, string:
+			return nil
+		default:
+			return fmt.Errorf("invalid value for field '_id' in cursor")
+		}
+	}
+
+	if proto == nil || strings.Contains(name, ".") {
+		return nil
+	}
+
+	fv, err := fieldByBSONName(proto, name)
+	if err != nil {
+		return nil
+	}
+
+	kind := fv.Kind()
+
+	var ok bool
+	switch value.(type) {
+	case bool:
+		ok = kind == reflect.Bool
+	case int, int32, int64, float64:
+		ok = (kind >= reflect.Int && kind <= reflect.Uint64) || kind == reflect.Float32 || kind == reflect.Float64
+	case string, bson.ObjectId:
+		ok = kind == reflect.String
+	case time.Time:
+		ok = fv.Type() == reflect.TypeOf(time.Time{})
+	}
+
+	// Fields holding composite values can be sorted
+	// on scalars, so we only check the scalar ones.
+	switch kind {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Interface, reflect.Ptr:
+		ok = true
+	case reflect.Struct:
+		ok = ok || fv.Type() != reflect.TypeOf(time.Time{})
+	}
+
+	if !ok {
+		return fmt.Errorf("invalid type %T for field '%s' in cursor", value, name)
+	}
+
+	return nil
+}
+
+func isCursorScalar(value interface{}) bool {
+
+	switch value.(type) {
+	case nil, bool, int, int32, int64, float64, string, bson.ObjectId, time.Time:
+		return true
+	default:
+		return false
+	}
+}
+
+func prepareNextFilter(collection *mgo.Collection, proto interface{}, order []string, next string) (bson.D, error) {
+
+	cursor, ok, err := decodeNextCursor(next, order, proto)
+	if err != nil {
+		return nil, manipulate.NewErrCannotBuildQuery(fmt.Sprintf("invalid 'after' cursor: %s", err))
+	}
+
+	// If next is not a cursor, it is an object identifier,
+	// as returned by previous versions. In that case, we
+	// need to retrieve the values of the ordering fields.
+	if !ok {
+		if cursor, err = legacyNextCursor(collection, order, next); err != nil {
+			return nil, err
+		}
+	}
+
+	return makeNextFilter(cursor, order), nil
+}
+
+func legacyNextCursor(collection *mgo.Collection, order []string, next string) (bson.D, error) {
 
 	var id interface{}
 	if oid, ok := objectid.Parse(next); ok {
@@ -58,42 +273,224 @@ func prepareNextFilter(collection *mgo.Collection, orderingField string, next st
 		id = next
 	}
 
-	if orderingField == "" {
-		return bson.D{
-			{
-				Name: "_id",
-				Value: bson.D{
-					{
-						Name:  "$gt",
-						Value: id,
-					},
-				},
-			},
-		}, nil
+	if len(order) == 1 {
+		return bson.D{{Name: "_id", Value: id}}, nil
 	}
 
-	comp := "$gt"
-	if strings.HasPrefix(orderingField, "-") {
-		orderingField = strings.TrimPrefix(orderingField, "-")
-		comp = "$lt"
+	sels := bson.M{}
+	for _, o := range order {
+		sels[strings.TrimPrefix(o, "-")] = 1
 	}
 
 	doc := bson.M{}
-	if err := collection.FindId(id).Select(bson.M{orderingField: 1}).One(&doc); err != nil {
+	if err := collection.FindId(id).Select(sels).One(&doc); err != nil {
 		return nil, handleQueryError(err)
 	}
 
-	return bson.D{
-		{
-			Name: orderingField,
-			Value: bson.D{
+	cursor := make(bson.D, len(order))
+	for i, o := range order {
+		f := strings.TrimPrefix(o, "-")
+		cursor[i] = bson.DocElem{Name: f, Value: lookupBSONPath(doc, f)}
+	}
+
+	return cursor, nil
+}
+
+// makeNextFilter returns the filter matching the documents coming
+// after the given cursor in the given ordering. For an ordering
+// a, -b, _id, it is:
+//
+//	a > va || (a == va && b < vb) || (a == va && b == vb && _id > vid)
+func makeNextFilter(cursor bson.D, order []string) bson.D {
+
+	ors := make([]bson.D, 0, len(order))
+
+	for i, o := range order {
+
+		comp := "$gt"
+		if strings.HasPrefix(o, "-") {
+			comp = "$lt"
+		}
+
+		v := cursor[i].Value
+
+		// Null values come first in ascending order and last in
+		// descending order. They cannot be compared with $gt or $lt.
+		if v == nil && comp == "$lt" {
+			continue
+		}
+
+		clause := make(bson.D, 0, i+1)
+		clause = append(clause, cursor[:i]...)
+
+		if v == nil {
+			clause = append(clause, bson.DocElem{Name: cursor[i].Name, Value: bson.D{{Name: "$ne", Value: nil}}})
+		} else {
+			clause = append(clause, bson.DocElem{Name: cursor[i].Name, Value: bson.D{{Name: comp, Value: v}}})
+		}
+
+		ors = append(ors, clause)
+	}
+
+	if len(ors) == 1 {
+		return ors[0]
+	}
+
+	return bson.D{{Name: "$or", Value: ors}}
+}
+
+func lookupBSONPath(doc bson.M, path string) interface{} {
+
+	parts := strings.Split(path, ".")
+
+	var current interface{} = doc
+	for _, p := range parts {
+
+		m, ok := current.(bson.M)
+		if !ok {
+			return nil
+		}
+
+		current = m[p]
+	}
+
+	return current
+}
+
+func getSearchFilters(mctx manipulate.Context) []bson.D {
+
+	o, ok := mctx.(opaquer)
+	if !ok {
+		return nil
+	}
+
+	filters, _ := o.Opaque()[opaqueKeySearchFilter].([]bson.D)
+
+	// We return a copy so callers can safely append to it.
+	return append([]bson.D{}, filters...)
+}
+
+// hasNearFilter returns true if the given manipulate.Context
+// has a search filter added by ContextOptionNear.
+func hasNearFilter(mctx manipulate.Context) bool {
+
+	for _, f := range getSearchFilters(mctx) {
+		for _, elem := range f {
+			if op, ok := elem.Value.(bson.D); ok && len(op) > 0 && op[0].Name == "$near" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func getNoDecryption(mctx manipulate.Context) bool {
+
+	if o, ok := mctx.(opaquer); ok {
+		v, _ := o.Opaque()[opaqueKeyNoDecryption].(bool)
+		return v
+	}
+
+	return false
+}
+
+func getUpdateIf(mctx manipulate.Context) bson.D {
+
+	if o, ok := mctx.(opaquer); ok {
+		f, _ := o.Opaque()[opaqueKeyUpdateIf].(bson.D)
+		return f
+	}
+
+	return nil
+}
+
+func getCollation(mctx manipulate.Context, identity elemental.Identity, collations map[elemental.Identity]*mgo.Collation) *mgo.Collation {
+
+	if o, ok := mctx.(opaquer); ok {
+		if coll, ok := o.Opaque()[opaqueKeyCollation].(*mgo.Collation); ok && coll != nil {
+			return coll
+		}
+	}
+
+	return collations[identity]
+}
+
+// compileFilter compiles the given filter. The MatchComparator compiles
+// to $regex, which does not support collations, so it is made case
+// insensitive if the given collation is.
+func compileFilter(f *elemental.Filter, collation *mgo.Collation) bson.D {
+
+	if collation != nil && collation.Strength > 0 && collation.Strength <= 2 {
+		return compiler.CompileFilter(f, compiler.CompileOptionCaseInsensitiveMatch())
+	}
+
+	return compiler.CompileFilter(f)
+}
+
+// removeAllWithCollation removes all the documents matching the given filter
+// using the given collation. mgo's RemoveAll does not support collations
+// so this directly runs the delete command, with the write concern of the
+// session of the given collection.
+func removeAllWithCollation(c *mgo.Collection, filter bson.D, collation *mgo.Collation) error {
+
+	res := struct {
+		WriteErrors []struct {
+			Code   int    `bson:"code"`
+			ErrMsg string `bson:"errmsg"`
+		} `bson:"writeErrors"`
+	}{}
+
+	if err := c.Database.Run(
+		bson.D{
+			{Name: "delete", Value: c.Name},
+			{Name: "deletes", Value: []bson.D{
 				{
-					Name:  comp,
-					Value: doc[orderingField],
+					{Name: "q", Value: filter},
+					{Name: "limit", Value: 0},
+					{Name: "collation", Value: collation},
 				},
-			},
+			}},
+			{Name: "writeConcern", Value: writeConcern(c.Database.Session.Safe())},
 		},
-	}, nil
+		&res,
+	); err != nil {
+		return err
+	}
+
+	if len(res.WriteErrors) > 0 {
+		return &mgo.QueryError{Code: res.WriteErrors[0].Code, Message: res.WriteErrors[0].ErrMsg}
+	}
+
+	return nil
+}
+
+// writeConcern returns the write concern document
+// matching the given safety mode of a session.
+func writeConcern(safe *mgo.Safe) bson.D {
+
+	if safe == nil {
+		return bson.D{{Name: "w", Value: 0}}
+	}
+
+	wc := bson.D{}
+
+	switch {
+	case safe.WMode != "":
+		wc = append(wc, bson.DocElem{Name: "w", Value: safe.WMode})
+	case safe.W > 0:
+		wc = append(wc, bson.DocElem{Name: "w", Value: safe.W})
+	}
+
+	if safe.J || safe.FSync {
+		wc = append(wc, bson.DocElem{Name: "j", Value: true})
+	}
+
+	if safe.WTimeout > 0 {
+		wc = append(wc, bson.DocElem{Name: "wtimeout", Value: safe.WTimeout})
+	}
+
+	return wc
 }
 
 func handleQueryError(err error) error {
@@ -311,3 +708,93 @@ func explain(query *mgo.Query, operation elemental.Operation, identity elemental
 
 	return nil
 }
+
+func fieldByBSONName(obj interface{}, name string) (reflect.Value, error) {
+
+	v := reflect.Indirect(reflect.ValueOf(obj))
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("object must be a struct or a pointer to a struct")
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		key := strings.ToLower(f.Name)
+		if tag := f.Tag.Get("bson"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				key = n
+			}
+		}
+
+		if key == name {
+			return v.Field(i), nil
+		}
+	}
+
+	return reflect.Value{}, fmt.Errorf("unable to find field '%s' in object", name)
+}
+
+// resetFieldByBSONName sets the field with the given BSON
+// name of the given object to its zero value, if it exists.
+func resetFieldByBSONName(obj interface{}, name string) {
+
+	fv, err := fieldByBSONName(obj, name)
+	if err != nil || !fv.CanSet() {
+		return
+	}
+
+	fv.Set(reflect.Zero(fv.Type()))
+}
+
+func getFieldByBSONName(obj interface{}, name string) (interface{}, error) {
+
+	fv, err := fieldByBSONName(obj, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return fv.Interface(), nil
+}
+
+func setFieldByBSONName(obj interface{}, name string, value interface{}) error {
+
+	fv, err := fieldByBSONName(obj, name)
+	if err != nil {
+		return err
+	}
+
+	if !fv.CanSet() {
+		return fmt.Errorf("field '%s' is not settable", name)
+	}
+
+	vv := reflect.ValueOf(value)
+
+	switch {
+
+	// A nil value, like a null in BSON, sets the zero value.
+	case !vv.IsValid():
+		fv.Set(reflect.Zero(fv.Type()))
+
+	case fv.Kind() >= reflect.Int && fv.Kind() <= reflect.Int64 && vv.Kind() >= reflect.Int && vv.Kind() <= reflect.Int64:
+		fv.SetInt(vv.Int())
+
+	case fv.Kind() == reflect.String && vv.Kind() == reflect.String:
+		fv.SetString(vv.String())
+
+	case vv.Type().AssignableTo(fv.Type()):
+		fv.Set(vv)
+
+	default:
+		return fmt.Errorf("cannot set value of type '%s' to field '%s' of type '%s'", vv.Type(), name, fv.Type())
+	}
+
+	return nil
+}
@@ -12,6 +12,8 @@
 package manipmongo
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"net"
@@ -20,6 +22,7 @@ import (
 
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
 	"go.aporeto.io/elemental"
 	"go.aporeto.io/manipulate"
 )
@@ -582,6 +585,42 @@ func Test_getErrorCode(t *testing.T) {
 	}
 }
 
+func Test_writeConcern(t *testing.T) {
+	tests := []struct {
+		name string
+		safe *mgo.Safe
+		want bson.D
+	}{
+		{
+			"none",
+			nil,
+			bson.D{{Name: "w", Value: 0}},
+		},
+		{
+			"default",
+			convertWriteConsistency(manipulate.WriteConsistencyDefault),
+			bson.D{},
+		},
+		{
+			"strongest",
+			convertWriteConsistency(manipulate.WriteConsistencyStrongest),
+			bson.D{{Name: "w", Value: "majority"}, {Name: "j", Value: true}},
+		},
+		{
+			"w and timeout",
+			&mgo.Safe{W: 2, WTimeout: 1000},
+			bson.D{{Name: "w", Value: 2}, {Name: "wtimeout", Value: 1000}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := writeConcern(tt.safe); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("writeConcern() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func Test_explainIfNeeded(t *testing.T) {
 
 	identity := elemental.MakeIdentity("thing", "things")
@@ -681,3 +720,469 @@ func Test_explainIfNeeded(t *testing.T) {
 		})
 	}
 }
+
+func Test_setFieldByBSONName(t *testing.T) {
+	type args struct {
+		obj   interface{}
+		name  string
+		value interface{}
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{
+			"int64 in int64",
+			args{
+				&shardedObject{},
+				"zhash",
+				int64(42),
+			},
+			false,
+		},
+		{
+			"int in int",
+			args{
+				&shardedObject{},
+				"zone",
+				42,
+			},
+			false,
+		},
+		{
+			"string in string",
+			args{
+				&shardedObject{},
+				"namespace",
+				"/a",
+			},
+			false,
+		},
+		{
+			"nil in int",
+			args{
+				&shardedObject{Zone: 3},
+				"zone",
+				nil,
+			},
+			false,
+		},
+		{
+			"nil in string",
+			args{
+				&shardedObject{Namespace: "/a"},
+				"namespace",
+				nil,
+			},
+			false,
+		},
+		{
+			"string in int",
+			args{
+				&shardedObject{},
+				"zone",
+				"/a",
+			},
+			true,
+		},
+		{
+			"ignored field",
+			args{
+				&shardedObject{},
+				"name",
+				"a",
+			},
+			true,
+		},
+		{
+			"not a struct",
+			args{
+				"hello",
+				"name",
+				"a",
+			},
+			true,
+		},
+		{
+			"not settable",
+			args{
+				shardedObject{},
+				"zone",
+				1,
+			},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := setFieldByBSONName(tt.args.obj, tt.args.name, tt.args.value); (err != nil) != tt.wantErr {
+				t.Errorf("setFieldByBSONName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if o, ok := tt.args.obj.(*shardedObject); ok && tt.args.value == nil && (o.Zone != 0 || o.Namespace != "") {
+				t.Errorf("setFieldByBSONName() = %v, want the zero value", o)
+			}
+		})
+	}
+}
+
+func Test_resetFieldByBSONName(t *testing.T) {
+
+	Convey("Given I have an object", t, func() {
+
+		o := &shardedObject{Namespace: "/a", Zone: 3}
+
+		Convey("When I reset some of its fields", func() {
+
+			resetFieldByBSONName(o, "zone")
+			resetFieldByBSONName(o, "nope")
+
+			Convey("Then only the existing ones should be reset", func() {
+				So(o.Zone, ShouldEqual, 0)
+				So(o.Namespace, ShouldEqual, "/a")
+			})
+		})
+	})
+}
+
+func Test_getCollation(t *testing.T) {
+
+	identityCollation := &mgo.Collation{Locale: "en", Strength: 2}
+	contextCollation := &mgo.Collation{Locale: "fr", Strength: 1}
+	collations := map[elemental.Identity]*mgo.Collation{
+		shardedIdentity: identityCollation,
+	}
+
+	type args struct {
+		mctx       manipulate.Context
+		identity   elemental.Identity
+		collations map[elemental.Identity]*mgo.Collation
+	}
+	tests := []struct {
+		name string
+		args args
+		want *mgo.Collation
+	}{
+		{
+			"no collation",
+			args{
+				manipulate.NewContext(context.Background()),
+				shardedIdentity,
+				nil,
+			},
+			nil,
+		},
+		{
+			"identity collation",
+			args{
+				manipulate.NewContext(context.Background()),
+				shardedIdentity,
+				collations,
+			},
+			identityCollation,
+		},
+		{
+			"other identity",
+			args{
+				manipulate.NewContext(context.Background()),
+				elemental.MakeIdentity("other", "others"),
+				collations,
+			},
+			nil,
+		},
+		{
+			"context collation",
+			args{
+				manipulate.NewContext(context.Background(), ContextOptionCollation(contextCollation)),
+				shardedIdentity,
+				collations,
+			},
+			contextCollation,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getCollation(tt.args.mctx, tt.args.identity, tt.args.collations); got != tt.want {
+				t.Errorf("getCollation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_hasNearFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		mctx manipulate.Context
+		want bool
+	}{
+		{
+			"no search filter",
+			manipulate.NewContext(context.Background()),
+			false,
+		},
+		{
+			"other search filter",
+			manipulate.NewContext(context.Background(), ContextOptionGeoWithin("location", bson.M{"type": "Polygon"})),
+			false,
+		},
+		{
+			"near search filter",
+			manipulate.NewContext(
+				context.Background(),
+				ContextOptionGeoWithin("location", bson.M{"type": "Polygon"}),
+				ContextOptionNear("location", 1, 2, 0, 10),
+			),
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasNearFilter(tt.mctx); got != tt.want {
+				t.Errorf("hasNearFilter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_compileFilter(t *testing.T) {
+
+	f := elemental.NewFilterComposer().WithKey("name").Matches("^a").Done()
+
+	regex := func(filter bson.D) bson.D {
+		return filter[0].Value.([]bson.D)[0][0].Value.([]bson.D)[0][0].Value.(bson.D)
+	}
+
+	tests := []struct {
+		name      string
+		collation *mgo.Collation
+		want      bson.D
+	}{
+		{
+			"no collation",
+			nil,
+			bson.D{{Name: "$regex", Value: "^a"}},
+		},
+		{
+			"case sensitive collation",
+			&mgo.Collation{Locale: "en"},
+			bson.D{{Name: "$regex", Value: "^a"}},
+		},
+		{
+			"case insensitive collation",
+			&mgo.Collation{Locale: "en", Strength: 2},
+			bson.D{{Name: "$regex", Value: "^a"}, {Name: "$options", Value: "i"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := regex(compileFilter(f, tt.collation)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("compileFilter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_cursorOrdering(t *testing.T) {
+	type args struct {
+		order []string
+	}
+	tests := []struct {
+		name string
+		args args
+		want []string
+	}{
+		{
+			"nil",
+			args{nil},
+			[]string{"_id"},
+		},
+		{
+			"without _id",
+			args{[]string{"name", "-date"}},
+			[]string{"name", "-date", "_id"},
+		},
+		{
+			"with _id",
+			args{[]string{"-_id", "name"}},
+			[]string{"-_id", "name"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cursorOrdering(tt.args.order); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("cursorOrdering() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_makeNextFilter(t *testing.T) {
+	type args struct {
+		cursor bson.D
+		order  []string
+	}
+	tests := []struct {
+		name string
+		args args
+		want bson.D
+	}{
+		{
+			"_id only",
+			args{
+				bson.D{{Name: "_id", Value: "x"}},
+				[]string{"_id"},
+			},
+			bson.D{{Name: "_id", Value: bson.D{{Name: "$gt", Value: "x"}}}},
+		},
+		{
+			"compound",
+			args{
+				bson.D{{Name: "a", Value: 1}, {Name: "b", Value: 2}, {Name: "_id", Value: "x"}},
+				[]string{"a", "-b", "_id"},
+			},
+			bson.D{{Name: "$or", Value: []bson.D{
+				{{Name: "a", Value: bson.D{{Name: "$gt", Value: 1}}}},
+				{{Name: "a", Value: 1}, {Name: "b", Value: bson.D{{Name: "$lt", Value: 2}}}},
+				{{Name: "a", Value: 1}, {Name: "b", Value: 2}, {Name: "_id", Value: bson.D{{Name: "$gt", Value: "x"}}}},
+			}}},
+		},
+		{
+			"ascending null",
+			args{
+				bson.D{{Name: "a", Value: nil}, {Name: "_id", Value: "x"}},
+				[]string{"a", "_id"},
+			},
+			bson.D{{Name: "$or", Value: []bson.D{
+				{{Name: "a", Value: bson.D{{Name: "$ne", Value: nil}}}},
+				{{Name: "a", Value: nil}, {Name: "_id", Value: bson.D{{Name: "$gt", Value: "x"}}}},
+			}}},
+		},
+		{
+			"descending null",
+			args{
+				bson.D{{Name: "a", Value: nil}, {Name: "_id", Value: "x"}},
+				[]string{"-a", "_id"},
+			},
+			bson.D{{Name: "a", Value: nil}, {Name: "_id", Value: bson.D{{Name: "$gt", Value: "x"}}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := makeNextFilter(tt.args.cursor, tt.args.order); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("makeNextFilter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_nextCursor(t *testing.T) {
+
+	Convey("Given I have an object", t, func() {
+
+		o := &shardedObject{
+			ID:        "5d66b8f7919e0c446f0b4597",
+			Namespace: "/a",
+			Zone:      3,
+		}
+
+		Convey("When I encode and decode a cursor for it", func() {
+
+			order := []string{"-zone", "namespace", "_id"}
+			next, err := encodeNextCursor(o, order)
+			So(err, ShouldBeNil)
+
+			cursor, ok, err := decodeNextCursor(next, order, &shardedObject{})
+
+			Convey("Then the cursor should be correct", func() {
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeTrue)
+				So(len(cursor), ShouldEqual, 3)
+				So(cursor[0].Name, ShouldEqual, "zone")
+				So(cursor[0].Value, ShouldEqual, 3)
+				So(cursor[1].Name, ShouldEqual, "namespace")
+				So(cursor[1].Value, ShouldEqual, "/a")
+				So(cursor[2].Name, ShouldEqual, "_id")
+				So(cursor[2].Value, ShouldEqual, bson.ObjectIdHex("5d66b8f7919e0c446f0b4597"))
+			})
+		})
+
+		Convey("When I decode a cursor with another ordering", func() {
+
+			next, _ := encodeNextCursor(o, []string{"zone", "_id"})
+			_, ok, err := decodeNextCursor(next, []string{"namespace", "_id"}, &shardedObject{})
+
+			Convey("Then err should not be nil", func() {
+				So(ok, ShouldBeTrue)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "cursor does not match the requested ordering")
+			})
+		})
+
+		Convey("When I decode a cursor holding a document", func() {
+
+			data, _ := bson.Marshal(bson.D{
+				{Name: "namespace", Value: bson.D{{Name: "$ne", Value: nil}}},
+				{Name: "_id", Value: "x"},
+			})
+			_, ok, err := decodeNextCursor(base64.RawURLEncoding.EncodeToString(data), []string{"namespace", "_id"}, &shardedObject{})
+
+			Convey("Then err should not be nil", func() {
+				So(ok, ShouldBeTrue)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "invalid value for field 'namespace' in cursor")
+			})
+		})
+
+		Convey("When I decode a cursor holding an array of documents", func() {
+
+			data, _ := bson.Marshal(bson.D{
+				{Name: "namespace", Value: []interface{}{bson.M{"$gt": ""}}},
+				{Name: "_id", Value: "x"},
+			})
+			_, ok, err := decodeNextCursor(base64.RawURLEncoding.EncodeToString(data), []string{"namespace", "_id"}, &shardedObject{})
+
+			Convey("Then err should not be nil", func() {
+				So(ok, ShouldBeTrue)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "invalid value for field 'namespace' in cursor")
+			})
+		})
+
+		Convey("When I decode a cursor holding a value of the wrong type", func() {
+
+			data, _ := bson.Marshal(bson.D{
+				{Name: "zone", Value: "3"},
+				{Name: "_id", Value: "x"},
+			})
+			_, ok, err := decodeNextCursor(base64.RawURLEncoding.EncodeToString(data), []string{"zone", "_id"}, &shardedObject{})
+
+			Convey("Then err should not be nil", func() {
+				So(ok, ShouldBeTrue)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "invalid type string for field 'zone' in cursor")
+			})
+		})
+
+		Convey("When I decode a cursor holding an invalid _id", func() {
+
+			data, _ := bson.Marshal(bson.D{{Name: "_id", Value: 42}})
+			_, ok, err := decodeNextCursor(base64.RawURLEncoding.EncodeToString(data), []string{"_id"}, &shardedObject{})
+
+			Convey("Then err should not be nil", func() {
+				So(ok, ShouldBeTrue)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "invalid value for field '_id' in cursor")
+			})
+		})
+
+		Convey("When I decode an identifier", func() {
+
+			_, ok, err := decodeNextCursor("5d66b8f7919e0c446f0b4597", []string{"_id"}, &shardedObject{})
+
+			Convey("Then it should not be considered as a cursor", func() {
+				So(ok, ShouldBeFalse)
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
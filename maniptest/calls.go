@@ -0,0 +1,235 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maniptest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/manipulate"
+)
+
+// Names of the methods recorded by the TestManipulator.
+const (
+	MethodRetrieveMany = "RetrieveMany"
+	MethodRetrieve     = "Retrieve"
+	MethodCreate       = "Create"
+	MethodUpdate       = "Update"
+	MethodDelete       = "Delete"
+	MethodDeleteMany   = "DeleteMany"
+	MethodCount        = "Count"
+	MethodCommit       = "Commit"
+	MethodAbort        = "Abort"
+)
+
+// A Call is a call made to a TestManipulator.
+type Call struct {
+
+	// Method is the name of the called method.
+	Method string
+
+	// Context is a copy of the manipulate.Context
+	// given to the method. It can be nil.
+	Context manipulate.Context
+
+	// Identity is the identity of the object, of the
+	// destination list or the identity given to the method.
+	Identity elemental.Identity
+
+	// Object is the object given to the method, for Retrieve,
+	// Create, Update and Delete. It is not copied, so it can be
+	// matched with CallWithObject, but it reflects the changes
+	// made to the object after the call.
+	Object elemental.Identifiable
+
+	// TransactionID is the transaction ID given
+	// to the method, for Commit and Abort.
+	TransactionID manipulate.TransactionID
+}
+
+// String returns the string representation of the call.
+func (c Call) String() string {
+
+	if c.Method == MethodCommit || c.Method == MethodAbort {
+		return fmt.Sprintf("%s(%s)", c.Method, c.TransactionID)
+	}
+
+	return fmt.Sprintf("%s(%s)", c.Method, c.Identity.Name)
+}
+
+// A CallMatcher returns true if the given call matches.
+type CallMatcher func(Call) bool
+
+// CallWithIdentity matches the calls made for the given identity.
+func CallWithIdentity(identity elemental.Identity) CallMatcher {
+	return func(c Call) bool {
+		return c.Identity.Name == identity.Name && c.Identity.Category == identity.Category
+	}
+}
+
+// CallWithNamespace matches the calls made with
+// a manipulate.Context using the given namespace.
+func CallWithNamespace(namespace string) CallMatcher {
+	return func(c Call) bool {
+		return c.Context != nil && c.Context.Namespace() == namespace
+	}
+}
+
+// CallWithFilter matches the calls made with a manipulate.Context
+// using a filter with the same string representation as the given one.
+// A nil filter matches the calls made without filter.
+func CallWithFilter(filter *elemental.Filter) CallMatcher {
+	return func(c Call) bool {
+
+		var f *elemental.Filter
+		if c.Context != nil {
+			f = c.Context.Filter()
+		}
+
+		if filter == nil || f == nil {
+			return filter == nil && f == nil
+		}
+
+		return f.String() == filter.String()
+	}
+}
+
+// CallWithObject matches the calls made with the given object.
+func CallWithObject(object elemental.Identifiable) CallMatcher {
+	return func(c Call) bool {
+		return c.Object == object
+	}
+}
+
+func (m *testManipulator) Calls(matchers ...CallMatcher) []Call {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	out := []Call{}
+
+	for _, c := range m.calls {
+		if matchCall(c, matchers) {
+			out = append(out, c)
+		}
+	}
+
+	return out
+}
+
+func (m *testManipulator) ResetCalls() {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.calls = nil
+}
+
+func (m *testManipulator) Strict(t *testing.T) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.strictTest = t
+}
+
+func (m *testManipulator) AssertCalled(t *testing.T, method string, times int, matchers ...CallMatcher) {
+
+	t.Helper()
+
+	calls := m.Calls(append([]CallMatcher{callWithMethod(method)}, matchers...)...)
+
+	if len(calls) != times {
+		t.Errorf("expected %s to be called %d time(s) but it was called %d time(s). calls: %s", method, times, len(calls), m.callsString())
+	}
+}
+
+func (m *testManipulator) AssertNotCalled(t *testing.T, method string, matchers ...CallMatcher) {
+
+	t.Helper()
+
+	m.AssertCalled(t, method, 0, matchers...)
+}
+
+func (m *testManipulator) AssertCallOrder(t *testing.T, methods ...string) {
+
+	t.Helper()
+
+	calls := m.Calls()
+
+	i := 0
+	for _, c := range calls {
+		if i < len(methods) && c.Method == methods[i] {
+			i++
+		}
+	}
+
+	if i != len(methods) {
+		t.Errorf("expected calls in order %s. calls: %s", strings.Join(methods, ", "), m.callsString())
+	}
+}
+
+// record records the given call, and returns an error if the
+// manipulator is strict and the method has not been mocked.
+// The lock must be held.
+func (m *testManipulator) record(c Call, mocked bool) error {
+
+	if c.Context != nil {
+		c.Context = c.Context.Derive()
+	}
+
+	m.calls = append(m.calls, c)
+
+	if mocked || m.strictTest == nil {
+		return nil
+	}
+
+	m.strictTest.Errorf("unexpected call to %s: the method has not been mocked", c)
+
+	return fmt.Errorf("unexpected call to %s: the method has not been mocked", c)
+}
+
+func (m *testManipulator) callsString() string {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if len(m.calls) == 0 {
+		return "none"
+	}
+
+	out := make([]string, len(m.calls))
+	for i, c := range m.calls {
+		out[i] = c.String()
+	}
+
+	return strings.Join(out, ", ")
+}
+
+func callWithMethod(method string) CallMatcher {
+	return func(c Call) bool {
+		return c.Method == method
+	}
+}
+
+func matchCall(c Call, matchers []CallMatcher) bool {
+
+	for _, match := range matchers {
+		if !match(c) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,155 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maniptest
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	testmodel "go.aporeto.io/elemental/test/model"
+	"go.aporeto.io/manipulate"
+)
+
+func TestTestManipulator_Calls(t *testing.T) {
+
+	Convey("Given I have TestManipulator", t, func() {
+
+		m := NewTestManipulator()
+
+		filter := elemental.NewFilterComposer().WithKey("name").Equals("a").Done()
+
+		l1 := testmodel.NewList()
+		l2 := testmodel.NewList()
+
+		mctx := manipulate.NewContext(context.Background(), manipulate.ContextOptionNamespace("/ns"))
+
+		So(m.Create(mctx, l1), ShouldBeNil)
+		So(m.Create(nil, l2), ShouldBeNil)
+		So(m.Create(mctx, testmodel.NewTask()), ShouldBeNil)
+		So(m.RetrieveMany(manipulate.NewContext(context.Background(), manipulate.ContextOptionFilter(filter)), &testmodel.ListsList{}), ShouldBeNil)
+		So(m.Retrieve(nil, nil), ShouldBeNil)
+		So(m.Commit("tid"), ShouldBeNil)
+
+		Convey("Then all the calls should be recorded", func() {
+			calls := m.Calls()
+			So(len(calls), ShouldEqual, 6)
+			So(calls[0].Method, ShouldEqual, MethodCreate)
+			So(calls[0].Object, ShouldEqual, l1)
+			So(calls[0].Context.Namespace(), ShouldEqual, "/ns")
+			So(calls[3].Identity.Name, ShouldEqual, testmodel.ListIdentity.Name)
+			So(calls[4].Identity.Name, ShouldBeEmpty)
+			So(calls[5].TransactionID, ShouldEqual, manipulate.TransactionID("tid"))
+			So(calls[5].String(), ShouldEqual, "Commit(tid)")
+		})
+
+		Convey("Then the contexts should be copies", func() {
+			So(m.Calls()[0].Context, ShouldNotEqual, mctx)
+		})
+
+		Convey("Then the matchers should work", func() {
+			So(len(m.Calls(CallWithIdentity(testmodel.ListIdentity))), ShouldEqual, 3)
+			So(len(m.Calls(CallWithIdentity(testmodel.ListIdentity), CallWithNamespace("/ns"))), ShouldEqual, 1)
+			So(len(m.Calls(CallWithFilter(filter))), ShouldEqual, 1)
+			So(len(m.Calls(CallWithFilter(nil))), ShouldEqual, 5)
+			So(len(m.Calls(CallWithObject(l2))), ShouldEqual, 1)
+		})
+
+		Convey("Then the assertions should pass", func() {
+
+			ft := &testing.T{}
+
+			m.AssertCalled(ft, MethodCreate, 3)
+			m.AssertCalled(ft, MethodCreate, 2, CallWithIdentity(testmodel.ListIdentity))
+			m.AssertCalled(ft, MethodRetrieveMany, 1, CallWithFilter(filter))
+			m.AssertNotCalled(ft, MethodDelete)
+			m.AssertCallOrder(ft, MethodCreate, MethodRetrieveMany, MethodCommit)
+
+			So(ft.Failed(), ShouldBeFalse)
+		})
+
+		Convey("Then wrong call counts should fail the test", func() {
+
+			ft := &testing.T{}
+			m.AssertCalled(ft, MethodCreate, 2)
+
+			So(ft.Failed(), ShouldBeTrue)
+		})
+
+		Convey("Then wrong call orders should fail the test", func() {
+
+			ft := &testing.T{}
+			m.AssertCallOrder(ft, MethodCommit, MethodCreate)
+
+			So(ft.Failed(), ShouldBeTrue)
+		})
+
+		Convey("When I reset the calls", func() {
+
+			m.ResetCalls()
+
+			Convey("Then there should be no calls", func() {
+				So(len(m.Calls()), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestTestManipulator_Strict(t *testing.T) {
+
+	Convey("Given I have a strict TestManipulator", t, func() {
+
+		ft := &testing.T{}
+
+		m := NewTestManipulator()
+		m.Strict(ft)
+
+		Convey("When I call a mocked method", func() {
+
+			m.MockCreate(t, func(mctx manipulate.Context, object elemental.Identifiable) error { return nil })
+			err := m.Create(nil, testmodel.NewList())
+
+			Convey("Then it should work", func() {
+				So(err, ShouldBeNil)
+				So(ft.Failed(), ShouldBeFalse)
+			})
+		})
+
+		Convey("When I call a method that is not mocked", func() {
+
+			n, err := m.Count(nil, testmodel.ListIdentity)
+
+			Convey("Then it should fail the test", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "unexpected call to Count(list): the method has not been mocked")
+				So(n, ShouldEqual, 0)
+				So(ft.Failed(), ShouldBeTrue)
+			})
+
+			Convey("Then the call should be recorded", func() {
+				So(len(m.Calls()), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When I turn strict mode off and call a method that is not mocked", func() {
+
+			m.Strict(nil)
+			_, err := m.Count(nil, testmodel.ListIdentity)
+
+			Convey("Then it should work", func() {
+				So(err, ShouldBeNil)
+				So(ft.Failed(), ShouldBeFalse)
+			})
+		})
+	})
+}
@@ -24,4 +24,11 @@
 // If you need to reset the mocked method in the context of the same test, simply do:
 //
 //      m.MockCreate(t, nil)
+//
+// All the calls are recorded, and can be checked with Calls, AssertCalled,
+// AssertNotCalled and AssertCallOrder. In strict mode, calls to methods that
+// have not been mocked fail the test.
+//
+// It also contains a Server, an in-memory fake API server that can be used
+// to test code using maniphttp end to end.
 package maniptest // import "go.aporeto.io/manipulate/maniptest"
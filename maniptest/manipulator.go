@@ -12,6 +12,7 @@
 package maniptest
 
 import (
+	"reflect"
 	"sync"
 	"testing"
 
@@ -43,13 +44,43 @@ type TestManipulator interface {
 	MockCount(t *testing.T, impl func(mctx manipulate.Context, identity elemental.Identity) (int, error))
 	MockCommit(t *testing.T, impl func(tid manipulate.TransactionID) error)
 	MockAbort(t *testing.T, impl func(tid manipulate.TransactionID) bool)
+
+	// Calls returns the recorded calls matching all the given matchers.
+	Calls(matchers ...CallMatcher) []Call
+
+	// ResetCalls forgets the recorded calls.
+	ResetCalls()
+
+	// Strict makes the calls to the methods that have not
+	// been mocked fail the given test and return an error.
+	// The manipulator stays strict until Strict is called
+	// again. Calling Strict with nil turns it off, which must
+	// be done before the given test ends if the manipulator
+	// outlives it, as failing a finished test panics.
+	Strict(t *testing.T)
+
+	// AssertCalled fails the given test if the given method has not been
+	// called exactly the given number of times with all the given matchers.
+	AssertCalled(t *testing.T, method string, times int, matchers ...CallMatcher)
+
+	// AssertNotCalled fails the given test if the given
+	// method has been called with all the given matchers.
+	AssertNotCalled(t *testing.T, method string, matchers ...CallMatcher)
+
+	// AssertCallOrder fails the given test if the given methods
+	// have not been called in the given order. Other calls
+	// can be made between them.
+	AssertCallOrder(t *testing.T, methods ...string)
 }
 
 // A testManipulator is an empty TransactionalManipulator that can be easily mocked.
+// It records all the calls made to it.
 type testManipulator struct {
 	mocks       map[*testing.T]*mockedMethods
 	lock        *sync.Mutex
 	currentTest *testing.T
+	strictTest  *testing.T
+	calls       []Call
 }
 
 // NewTestManipulator returns a new TestManipulator.
@@ -137,7 +168,13 @@ func (m *testManipulator) RetrieveMany(mctx manipulate.Context, dest elemental.I
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	if mock := m.currentMocks(m.currentTest); mock != nil && mock.retrieveManyMock != nil {
+	mock := m.currentMocks(m.currentTest)
+
+	if err := m.record(Call{Method: MethodRetrieveMany, Context: mctx, Identity: identityOf(dest)}, mock.retrieveManyMock != nil); err != nil {
+		return err
+	}
+
+	if mock.retrieveManyMock != nil {
 		return mock.retrieveManyMock(mctx, dest)
 	}
 
@@ -149,7 +186,13 @@ func (m *testManipulator) Retrieve(mctx manipulate.Context, object elemental.Ide
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	if mock := m.currentMocks(m.currentTest); mock != nil && mock.retrieveMock != nil {
+	mock := m.currentMocks(m.currentTest)
+
+	if err := m.record(Call{Method: MethodRetrieve, Context: mctx, Identity: identityOf(object), Object: object}, mock.retrieveMock != nil); err != nil {
+		return err
+	}
+
+	if mock.retrieveMock != nil {
 		return mock.retrieveMock(mctx, object)
 	}
 
@@ -161,7 +204,13 @@ func (m *testManipulator) Create(mctx manipulate.Context, object elemental.Ident
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	if mock := m.currentMocks(m.currentTest); mock != nil && mock.createMock != nil {
+	mock := m.currentMocks(m.currentTest)
+
+	if err := m.record(Call{Method: MethodCreate, Context: mctx, Identity: identityOf(object), Object: object}, mock.createMock != nil); err != nil {
+		return err
+	}
+
+	if mock.createMock != nil {
 		return mock.createMock(mctx, object)
 	}
 
@@ -173,7 +222,13 @@ func (m *testManipulator) Update(mctx manipulate.Context, object elemental.Ident
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	if mock := m.currentMocks(m.currentTest); mock != nil && mock.updateMock != nil {
+	mock := m.currentMocks(m.currentTest)
+
+	if err := m.record(Call{Method: MethodUpdate, Context: mctx, Identity: identityOf(object), Object: object}, mock.updateMock != nil); err != nil {
+		return err
+	}
+
+	if mock.updateMock != nil {
 		return mock.updateMock(mctx, object)
 	}
 
@@ -185,7 +240,13 @@ func (m *testManipulator) Delete(mctx manipulate.Context, object elemental.Ident
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	if mock := m.currentMocks(m.currentTest); mock != nil && mock.deleteMock != nil {
+	mock := m.currentMocks(m.currentTest)
+
+	if err := m.record(Call{Method: MethodDelete, Context: mctx, Identity: identityOf(object), Object: object}, mock.deleteMock != nil); err != nil {
+		return err
+	}
+
+	if mock.deleteMock != nil {
 		return mock.deleteMock(mctx, object)
 	}
 
@@ -198,7 +259,13 @@ func (m *testManipulator) DeleteMany(mctx manipulate.Context, identity elemental
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	if mock := m.currentMocks(m.currentTest); mock != nil && mock.deleteManyMock != nil {
+	mock := m.currentMocks(m.currentTest)
+
+	if err := m.record(Call{Method: MethodDeleteMany, Context: mctx, Identity: identity}, mock.deleteManyMock != nil); err != nil {
+		return err
+	}
+
+	if mock.deleteManyMock != nil {
 		return mock.deleteManyMock(mctx, identity)
 	}
 
@@ -210,7 +277,13 @@ func (m *testManipulator) Count(mctx manipulate.Context, identity elemental.Iden
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	if mock := m.currentMocks(m.currentTest); mock != nil && mock.countMock != nil {
+	mock := m.currentMocks(m.currentTest)
+
+	if err := m.record(Call{Method: MethodCount, Context: mctx, Identity: identity}, mock.countMock != nil); err != nil {
+		return 0, err
+	}
+
+	if mock.countMock != nil {
 		return mock.countMock(mctx, identity)
 	}
 
@@ -222,7 +295,13 @@ func (m *testManipulator) Commit(id manipulate.TransactionID) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	if mock := m.currentMocks(m.currentTest); mock != nil && mock.commitMock != nil {
+	mock := m.currentMocks(m.currentTest)
+
+	if err := m.record(Call{Method: MethodCommit, TransactionID: id}, mock.commitMock != nil); err != nil {
+		return err
+	}
+
+	if mock.commitMock != nil {
 		return mock.commitMock(id)
 	}
 
@@ -234,7 +313,13 @@ func (m *testManipulator) Abort(id manipulate.TransactionID) bool {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	if mock := m.currentMocks(m.currentTest); mock != nil && mock.abortMock != nil {
+	mock := m.currentMocks(m.currentTest)
+
+	if err := m.record(Call{Method: MethodAbort, TransactionID: id}, mock.abortMock != nil); err != nil {
+		return false
+	}
+
+	if mock.abortMock != nil {
 		return mock.abortMock(id)
 	}
 
@@ -253,3 +338,18 @@ func (m *testManipulator) currentMocks(t *testing.T) *mockedMethods {
 	m.currentTest = t
 	return mocks
 }
+
+// identityOf returns the identity of the given object, or an
+// empty identity if it is nil.
+func identityOf(object interface{ Identity() elemental.Identity }) elemental.Identity {
+
+	if object == nil {
+		return elemental.Identity{}
+	}
+
+	if v := reflect.ValueOf(object); v.Kind() == reflect.Ptr && v.IsNil() {
+		return elemental.Identity{}
+	}
+
+	return object.Identity()
+}
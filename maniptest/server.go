@@ -0,0 +1,761 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maniptest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/manipulate"
+	"go.aporeto.io/manipulate/manipmemory"
+)
+
+const (
+	serverEventsEndpoint = "events"
+	serverEventsChSize   = 256
+)
+
+// namespaceable is implemented by the objects living in a namespace.
+type namespaceable interface {
+	GetNamespace() string
+	SetNamespace(string)
+}
+
+// A Server is a fake API server, backed by manipmemory, implementing
+// the REST conventions expected by maniphttp, so client code can be
+// tested end to end.
+//
+// It serves the collection (/lists), object (/lists/id) and children
+// (/users/id/lists) urls, with an optional /v/N prefix. Collections can
+// be listed, counted, created in and deleted from, and objects can be
+// retrieved, updated, patched and deleted. A PATCH only changes the
+// attributes given in the body, like a sparse update. It honors the
+// X-Namespace header, the q, page, pagesize, after, limit and recursive
+// parameters, and sets the X-Count-Total and X-Next headers. Errors
+// are returned as elemental errors. The events of the changes are
+// pushed to the clients connected to the /events websocket.
+//
+// The objects are listed by ID, and the q parameter is evaluated by
+// manipmemory, so it only supports what manipmemory supports.
+type Server struct {
+	*httptest.Server
+
+	manager     elemental.ModelManager
+	store       manipulate.TransactionalManipulator
+	parents     map[string]string
+	upgrader    websocket.Upgrader
+	subscribers map[*serverSubscriber]struct{}
+	lock        sync.RWMutex
+}
+
+// NewServer returns a new started Server serving the identities of
+// the given elemental.ModelManager, stored in a manipmemory with the
+// given schema. Each identity must have a unique "id" index on "ID".
+func NewServer(manager elemental.ModelManager, schema map[string]*manipmemory.IdentitySchema) (*Server, error) {
+
+	store, err := manipmemory.New(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		manager:     manager,
+		store:       store,
+		parents:     map[string]string{},
+		subscribers: map[*serverSubscriber]struct{}{},
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.ServeHTTP))
+
+	return s, nil
+}
+
+// Close disconnects the events clients and shuts down the server.
+func (s *Server) Close() {
+
+	s.lock.Lock()
+	for sub := range s.subscribers {
+		_ = sub.conn.Close() // nolint
+	}
+	s.lock.Unlock()
+
+	s.Server.Close()
+}
+
+// Store returns the manipulator storing the objects. It can be
+// used to prepare or check the data, but the changes made through
+// it are not pushed to the events clients.
+func (s *Server) Store() manipulate.TransactionalManipulator {
+	return s.store
+}
+
+// ServeHTTP serves the given request.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	readEncoding, writeEncoding, err := elemental.EncodingFromHeaders(r.Header)
+	if err != nil {
+		writeErrors(w, elemental.EncodingTypeJSON, http.StatusUnsupportedMediaType, elemental.NewError("Unsupported Media Type", err.Error(), "maniptest", http.StatusUnsupportedMediaType))
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) > 2 && parts[0] == "v" {
+		parts = parts[2:]
+	}
+
+	if len(parts) == 1 && parts[0] == serverEventsEndpoint {
+		s.serveEvents(w, r, readEncoding, writeEncoding)
+		return
+	}
+
+	req := &serverRequest{
+		w:             w,
+		r:             r,
+		readEncoding:  readEncoding,
+		writeEncoding: writeEncoding,
+		namespace:     r.Header.Get("X-Namespace"),
+	}
+
+	if req.namespace == "" {
+		req.namespace = "/"
+	}
+
+	switch len(parts) {
+
+	case 1:
+		if req.identity = s.manager.IdentityFromCategory(parts[0]); req.identity.Name == "" {
+			req.notFound(fmt.Sprintf("unknown identity '%s'", parts[0]))
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			s.retrieveMany(req)
+		case http.MethodPost:
+			s.create(req)
+		case http.MethodDelete:
+			s.deleteMany(req)
+		default:
+			req.methodNotAllowed()
+		}
+
+	case 2:
+		if req.identity = s.manager.IdentityFromCategory(parts[0]); req.identity.Name == "" {
+			req.notFound(fmt.Sprintf("unknown identity '%s'", parts[0]))
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			s.retrieve(req, parts[1])
+		case http.MethodPut:
+			s.update(req, parts[1])
+		case http.MethodPatch:
+			s.patch(req, parts[1])
+		case http.MethodDelete:
+			s.delete(req, parts[1])
+		default:
+			req.methodNotAllowed()
+		}
+
+	case 3:
+		parentIdentity := s.manager.IdentityFromCategory(parts[0])
+		if parentIdentity.Name == "" {
+			req.notFound(fmt.Sprintf("unknown identity '%s'", parts[0]))
+			return
+		}
+
+		if req.identity = s.manager.IdentityFromCategory(parts[2]); req.identity.Name == "" {
+			req.notFound(fmt.Sprintf("unknown identity '%s'", parts[2]))
+			return
+		}
+
+		parent := s.manager.Identifiable(parentIdentity)
+		parent.SetIdentifier(parts[1])
+		if err := s.store.Retrieve(nil, parent); err != nil {
+			req.error(err)
+			return
+		}
+		req.parent = objectKey(parent)
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			s.retrieveMany(req)
+		case http.MethodPost:
+			s.create(req)
+		case http.MethodDelete:
+			s.deleteMany(req)
+		default:
+			req.methodNotAllowed()
+		}
+
+	default:
+		req.notFound(fmt.Sprintf("unknown url '%s'", r.URL.Path))
+	}
+}
+
+// list returns the objects matching the filter, the namespace
+// and the parent of the request, sorted by ID. It writes an
+// error if it cannot.
+func (s *Server) list(req *serverRequest) (elemental.IdentifiablesList, bool) {
+
+	q := req.r.URL.Query()
+
+	mctx := manipulate.NewContext(req.r.Context())
+
+	if filter := q.Get("q"); filter != "" {
+		f, err := elemental.NewFilterFromString(filter)
+		if err != nil {
+			req.badRequest(fmt.Sprintf("invalid filter: %s", err))
+			return nil, false
+		}
+		mctx = manipulate.NewContext(req.r.Context(), manipulate.ContextOptionFilter(f))
+	}
+
+	dest := s.manager.Identifiables(req.identity)
+	if err := s.store.RetrieveMany(mctx, dest); err != nil {
+		req.error(err)
+		return nil, false
+	}
+
+	recursive := q.Get("recursive") == "true"
+
+	s.lock.RLock()
+	items := elemental.IdentifiablesList{}
+	for _, o := range dest.List() {
+		if !inNamespace(o, req.namespace, recursive) {
+			continue
+		}
+		if s.parents[objectKey(o)] != req.parent {
+			continue
+		}
+		items = append(items, o)
+	}
+	s.lock.RUnlock()
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Identifier() < items[j].Identifier() })
+
+	return items, true
+}
+
+func (s *Server) retrieveMany(req *serverRequest) {
+
+	q := req.r.URL.Query()
+
+	items, ok := s.list(req)
+	if !ok {
+		return
+	}
+
+	req.w.Header().Set("X-Count-Total", strconv.Itoa(len(items)))
+
+	if req.r.Method == http.MethodHead {
+		req.w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	page, _ := strconv.Atoi(q.Get("page"))
+	pageSize, _ := strconv.Atoi(q.Get("pagesize"))
+	limit, _ := strconv.Atoi(q.Get("limit"))
+
+	switch {
+
+	case q.Get("after") != "" || limit > 0:
+
+		after := q.Get("after")
+		start := sort.Search(len(items), func(i int) bool { return items[i].Identifier() > after })
+		items = items[start:]
+
+		if limit > 0 && len(items) > limit {
+			items = items[:limit]
+			req.w.Header().Set("X-Next", items[limit-1].Identifier())
+		}
+
+	case page > 0 && pageSize > 0:
+
+		start := (page - 1) * pageSize
+		if start > len(items) {
+			start = len(items)
+		}
+
+		end := start + pageSize
+		if end > len(items) {
+			end = len(items)
+		}
+
+		items = items[start:end]
+	}
+
+	req.write(http.StatusOK, items)
+}
+
+func (s *Server) retrieve(req *serverRequest, id string) {
+
+	obj, ok := s.find(req, id)
+	if !ok {
+		return
+	}
+
+	req.write(http.StatusOK, obj)
+}
+
+func (s *Server) create(req *serverRequest) {
+
+	obj := s.manager.Identifiable(req.identity)
+	if !req.decode(obj) {
+		return
+	}
+
+	if n, ok := obj.(namespaceable); ok {
+		n.SetNamespace(req.namespace)
+	}
+
+	if err := s.store.Create(nil, obj); err != nil {
+		req.error(err)
+		return
+	}
+
+	s.lock.Lock()
+	if req.parent != "" {
+		s.parents[objectKey(obj)] = req.parent
+	}
+	s.lock.Unlock()
+
+	s.publish(elemental.EventCreate, obj)
+
+	req.write(http.StatusOK, obj)
+}
+
+func (s *Server) update(req *serverRequest, id string) {
+
+	existing, ok := s.find(req, id)
+	if !ok {
+		return
+	}
+
+	obj := s.manager.Identifiable(req.identity)
+	if !req.decode(obj) {
+		return
+	}
+
+	obj.SetIdentifier(id)
+
+	if n, ok := obj.(namespaceable); ok {
+		n.SetNamespace(existing.(namespaceable).GetNamespace())
+	}
+
+	if err := s.store.Update(nil, obj); err != nil {
+		req.error(err)
+		return
+	}
+
+	s.publish(elemental.EventUpdate, obj)
+
+	req.write(http.StatusOK, obj)
+}
+
+func (s *Server) delete(req *serverRequest, id string) {
+
+	obj, ok := s.find(req, id)
+	if !ok {
+		return
+	}
+
+	if err := s.store.Delete(nil, obj); err != nil {
+		req.error(err)
+		return
+	}
+
+	s.lock.Lock()
+	delete(s.parents, objectKey(obj))
+	s.lock.Unlock()
+
+	s.publish(elemental.EventDelete, obj)
+
+	req.write(http.StatusOK, obj)
+}
+
+func (s *Server) deleteMany(req *serverRequest) {
+
+	items, ok := s.list(req)
+	if !ok {
+		return
+	}
+
+	for _, obj := range items {
+
+		if err := s.store.Delete(nil, obj); err != nil {
+			req.error(err)
+			return
+		}
+
+		s.lock.Lock()
+		delete(s.parents, objectKey(obj))
+		s.lock.Unlock()
+
+		s.publish(elemental.EventDelete, obj)
+	}
+
+	req.write(http.StatusOK, items)
+}
+
+// patch merges the attributes given in the body of the
+// request into the existing object, like for a sparse update.
+func (s *Server) patch(req *serverRequest, id string) {
+
+	existing, ok := s.find(req, id)
+	if !ok {
+		return
+	}
+
+	data, err := ioutil.ReadAll(req.r.Body)
+	if err != nil {
+		req.badRequest(fmt.Sprintf("unable to read body: %s", err))
+		return
+	}
+
+	changes := map[string]interface{}{}
+	if err := elemental.Decode(req.readEncoding, data, &changes); err != nil {
+		req.badRequest(fmt.Sprintf("unable to decode body: %s", err))
+		return
+	}
+
+	// We merge in the representation of the objects, so
+	// the attributes are matched by their encoded names.
+	current, err := elemental.Encode(req.readEncoding, existing)
+	if err != nil {
+		req.error(err)
+		return
+	}
+
+	merged := map[string]interface{}{}
+	if err := elemental.Decode(req.readEncoding, current, &merged); err != nil {
+		req.error(err)
+		return
+	}
+
+	for k, v := range changes {
+		merged[k] = v
+	}
+
+	if data, err = elemental.Encode(req.readEncoding, merged); err != nil {
+		req.error(err)
+		return
+	}
+
+	obj := s.manager.Identifiable(req.identity)
+	if !req.decodeData(data, obj) {
+		return
+	}
+
+	obj.SetIdentifier(id)
+
+	if n, ok := obj.(namespaceable); ok {
+		n.SetNamespace(existing.(namespaceable).GetNamespace())
+	}
+
+	if err := s.store.Update(nil, obj); err != nil {
+		req.error(err)
+		return
+	}
+
+	s.publish(elemental.EventUpdate, obj)
+
+	req.write(http.StatusOK, obj)
+}
+
+// find retrieves the object with the given ID in the namespace of
+// the request or its children. It writes an error if it cannot.
+func (s *Server) find(req *serverRequest, id string) (elemental.Identifiable, bool) {
+
+	obj := s.manager.Identifiable(req.identity)
+	obj.SetIdentifier(id)
+
+	if err := s.store.Retrieve(nil, obj); err != nil {
+		req.error(err)
+		return nil, false
+	}
+
+	if !inNamespace(obj, req.namespace, true) {
+		req.notFound("cannot find the object for the given ID")
+		return nil, false
+	}
+
+	return obj, true
+}
+
+// serverRequest holds what is needed to serve a request.
+type serverRequest struct {
+	w             http.ResponseWriter
+	r             *http.Request
+	readEncoding  elemental.EncodingType
+	writeEncoding elemental.EncodingType
+	namespace     string
+	identity      elemental.Identity
+	parent        string
+}
+
+func (req *serverRequest) decode(obj elemental.Identifiable) bool {
+
+	data, err := ioutil.ReadAll(req.r.Body)
+	if err != nil {
+		req.badRequest(fmt.Sprintf("unable to read body: %s", err))
+		return false
+	}
+
+	return req.decodeData(data, obj)
+}
+
+// decodeData decodes the given data into the given object and
+// validates it. It writes an error if it cannot.
+func (req *serverRequest) decodeData(data []byte, obj elemental.Identifiable) bool {
+
+	if err := elemental.Decode(req.readEncoding, data, obj); err != nil {
+		req.badRequest(fmt.Sprintf("unable to decode body: %s", err))
+		return false
+	}
+
+	if v, ok := obj.(elemental.Validatable); ok {
+		if err := v.Validate(); err != nil {
+			req.error(err)
+			return false
+		}
+	}
+
+	return true
+}
+
+func (req *serverRequest) write(status int, data interface{}) {
+
+	out, err := elemental.Encode(req.writeEncoding, data)
+	if err != nil {
+		req.error(err)
+		return
+	}
+
+	req.w.Header().Set("Content-Type", string(req.writeEncoding))
+	req.w.WriteHeader(status)
+	_, _ = req.w.Write(out) // nolint
+}
+
+func (req *serverRequest) error(err error) {
+
+	switch e := err.(type) {
+
+	case elemental.Errors:
+		writeErrors(req.w, req.writeEncoding, e.Code(), e...)
+
+	case elemental.Error:
+		writeErrors(req.w, req.writeEncoding, e.Code, e)
+
+	case manipulate.ErrObjectNotFound:
+		req.notFound(e.Error())
+
+	default:
+		if manipulate.IsCannotExecuteQueryError(err) {
+			writeErrors(req.w, req.writeEncoding, http.StatusUnprocessableEntity, elemental.NewError("Unprocessable Entity", err.Error(), "maniptest", http.StatusUnprocessableEntity))
+			return
+		}
+		writeErrors(req.w, req.writeEncoding, http.StatusInternalServerError, elemental.NewError("Internal Server Error", err.Error(), "maniptest", http.StatusInternalServerError))
+	}
+}
+
+func (req *serverRequest) notFound(description string) {
+	writeErrors(req.w, req.writeEncoding, http.StatusNotFound, elemental.NewError("Not Found", description, "maniptest", http.StatusNotFound))
+}
+
+func (req *serverRequest) badRequest(description string) {
+	writeErrors(req.w, req.writeEncoding, http.StatusBadRequest, elemental.NewError("Bad Request", description, "maniptest", http.StatusBadRequest))
+}
+
+func (req *serverRequest) methodNotAllowed() {
+	writeErrors(req.w, req.writeEncoding, http.StatusMethodNotAllowed, elemental.NewError("Method Not Allowed", fmt.Sprintf("method %s is not allowed", req.r.Method), "maniptest", http.StatusMethodNotAllowed))
+}
+
+func writeErrors(w http.ResponseWriter, encoding elemental.EncodingType, status int, errs ...elemental.Error) {
+
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+
+	data, err := elemental.Encode(encoding, errs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", string(encoding))
+	w.WriteHeader(status)
+	_, _ = w.Write(data) // nolint
+}
+
+// serverSubscriber is a client connected to the events websocket.
+type serverSubscriber struct {
+	conn      *websocket.Conn
+	namespace string
+	recursive bool
+	encoding  elemental.EncodingType
+	filter    *elemental.PushConfig
+	events    chan *elemental.Event
+	lock      sync.RWMutex
+}
+
+// serverControlMessage is used to recognize the control
+// messages sent by the subscribers, like token renewals.
+type serverControlMessage struct {
+	Control string `json:"control" msgpack:"control"`
+}
+
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request, readEncoding elemental.EncodingType, writeEncoding elemental.EncodingType) {
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	namespace := r.URL.Query().Get("namespace")
+	if namespace == "" {
+		namespace = "/"
+	}
+
+	sub := &serverSubscriber{
+		conn:      conn,
+		namespace: namespace,
+		recursive: r.URL.Query().Get("mode") == "all",
+		encoding:  writeEncoding,
+		events:    make(chan *elemental.Event, serverEventsChSize),
+	}
+
+	s.lock.Lock()
+	s.subscribers[sub] = struct{}{}
+	s.lock.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	defer func() {
+		cancel()
+		s.lock.Lock()
+		delete(s.subscribers, sub)
+		s.lock.Unlock()
+		_ = conn.Close() // nolint
+	}()
+
+	go sub.write(ctx)
+
+	for {
+
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		ctrl := serverControlMessage{}
+		if err := elemental.Decode(readEncoding, data, &ctrl); err == nil && ctrl.Control != "" {
+			continue
+		}
+
+		filter := elemental.NewPushConfig()
+		if err := elemental.Decode(readEncoding, data, filter); err != nil {
+			continue
+		}
+
+		sub.lock.Lock()
+		sub.filter = filter
+		sub.lock.Unlock()
+	}
+}
+
+// write sends the events to the client until the given context is done.
+func (sub *serverSubscriber) write(ctx context.Context) {
+
+	for {
+		select {
+
+		case evt := <-sub.events:
+
+			data, err := elemental.Encode(sub.encoding, evt)
+			if err != nil {
+				continue
+			}
+
+			if err := sub.conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+				return
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// publish pushes an event for the given object to the
+// subscribers whose namespace and filter match.
+func (s *Server) publish(eventType elemental.EventType, obj elemental.Identifiable) {
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for sub := range s.subscribers {
+
+		if !inNamespace(obj, sub.namespace, sub.recursive) {
+			continue
+		}
+
+		sub.lock.RLock()
+		filtered := sub.filter != nil && sub.filter.IsFilteredOut(obj.Identity().Name, eventType)
+		sub.lock.RUnlock()
+
+		if filtered {
+			continue
+		}
+
+		select {
+		case sub.events <- elemental.NewEventWithEncoding(eventType, obj, sub.encoding):
+		default:
+		}
+	}
+}
+
+// inNamespace returns true if the given object is in the given
+// namespace, or in one of its children if recursive is true.
+// Objects without namespace are in all namespaces.
+func inNamespace(obj elemental.Identifiable, namespace string, recursive bool) bool {
+
+	n, ok := obj.(namespaceable)
+	if !ok {
+		return true
+	}
+
+	ns := n.GetNamespace()
+	if ns == namespace {
+		return true
+	}
+
+	if !recursive {
+		return false
+	}
+
+	return namespace == "/" || strings.HasPrefix(ns, namespace+"/")
+}
+
+func objectKey(obj elemental.Identifiable) string {
+	return obj.Identity().Name + "/" + obj.Identifier()
+}
@@ -0,0 +1,321 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maniptest
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	testmodel "go.aporeto.io/elemental/test/model"
+	"go.aporeto.io/manipulate"
+	"go.aporeto.io/manipulate/maniphttp"
+	"go.aporeto.io/manipulate/manipmemory"
+)
+
+func serverSchema() map[string]*manipmemory.IdentitySchema {
+
+	return map[string]*manipmemory.IdentitySchema{
+		testmodel.ListIdentity.Category: {
+			Identity: testmodel.ListIdentity,
+			Indexes: []*manipmemory.Index{
+				{
+					Name:      "id",
+					Type:      manipmemory.IndexTypeString,
+					Unique:    true,
+					Attribute: "ID",
+				},
+				{
+					Name:      "name",
+					Type:      manipmemory.IndexTypeString,
+					Attribute: "Name",
+				},
+			},
+		},
+		testmodel.TaskIdentity.Category: {
+			Identity: testmodel.TaskIdentity,
+			Indexes: []*manipmemory.Index{
+				{
+					Name:      "id",
+					Type:      manipmemory.IndexTypeString,
+					Unique:    true,
+					Attribute: "ID",
+				},
+			},
+		},
+	}
+}
+
+func TestServer_New(t *testing.T) {
+
+	Convey("Given I create a server with a bad schema", t, func() {
+
+		s, err := NewServer(testmodel.Manager(), nil)
+
+		Convey("Then err should not be nil", func() {
+			So(err, ShouldNotBeNil)
+			So(s, ShouldBeNil)
+		})
+	})
+}
+
+func TestServer_CRUD(t *testing.T) {
+
+	Convey("Given I have a server and a http manipulator", t, func() {
+
+		s, err := NewServer(testmodel.Manager(), serverSchema())
+		So(err, ShouldBeNil)
+		defer s.Close()
+
+		m, err := maniphttp.New(context.Background(), s.URL, maniphttp.OptionNamespace("/ns"))
+		So(err, ShouldBeNil)
+
+		Convey("When I create some lists", func() {
+
+			l1 := &testmodel.List{Name: "a"}
+			l2 := &testmodel.List{Name: "b"}
+			l3 := &testmodel.List{Name: "c"}
+
+			So(m.Create(nil, l1), ShouldBeNil)
+			So(m.Create(nil, l2), ShouldBeNil)
+			So(m.Create(nil, l3), ShouldBeNil)
+
+			Convey("Then they should have an ID", func() {
+				So(l1.ID, ShouldNotBeEmpty)
+			})
+
+			Convey("Then they should be in the store", func() {
+				l := &testmodel.List{ID: l1.ID}
+				So(s.Store().Retrieve(nil, l), ShouldBeNil)
+				So(l.Name, ShouldEqual, "a")
+			})
+
+			Convey("Then I should be able to retrieve one", func() {
+				l := &testmodel.List{ID: l2.ID}
+				So(m.Retrieve(nil, l), ShouldBeNil)
+				So(l.Name, ShouldEqual, "b")
+			})
+
+			Convey("Then I should be able to retrieve them all", func() {
+				lists := testmodel.ListsList{}
+				So(m.RetrieveMany(nil, &lists), ShouldBeNil)
+				So(len(lists), ShouldEqual, 3)
+			})
+
+			Convey("Then I should be able to retrieve them with a filter", func() {
+				lists := testmodel.ListsList{}
+				mctx := manipulate.NewContext(
+					context.Background(),
+					manipulate.ContextOptionFilter(elemental.NewFilterComposer().WithKey("name").Equals("b").Done()),
+				)
+				So(m.RetrieveMany(mctx, &lists), ShouldBeNil)
+				So(len(lists), ShouldEqual, 1)
+				So(lists[0].ID, ShouldEqual, l2.ID)
+			})
+
+			Convey("Then I should be able to retrieve a page", func() {
+				lists := testmodel.ListsList{}
+				mctx := manipulate.NewContext(context.Background(), manipulate.ContextOptionPage(2, 2))
+				So(m.RetrieveMany(mctx, &lists), ShouldBeNil)
+				So(len(lists), ShouldEqual, 1)
+				So(mctx.Count(), ShouldEqual, 3)
+			})
+
+			Convey("Then I should be able to count them", func() {
+				n, err := m.Count(nil, testmodel.ListIdentity)
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 3)
+			})
+
+			Convey("When I update one", func() {
+
+				l1.Name = "z"
+				So(m.Update(nil, l1), ShouldBeNil)
+
+				Convey("Then it should be updated", func() {
+					l := &testmodel.List{ID: l1.ID}
+					So(m.Retrieve(nil, l), ShouldBeNil)
+					So(l.Name, ShouldEqual, "z")
+				})
+			})
+
+			Convey("When I delete one", func() {
+
+				So(m.Delete(nil, l1), ShouldBeNil)
+
+				Convey("Then it should be gone", func() {
+					err := m.Retrieve(nil, &testmodel.List{ID: l1.ID})
+					So(err, ShouldNotBeNil)
+					So(manipulate.IsObjectNotFoundError(err), ShouldBeTrue)
+				})
+			})
+
+			Convey("When I patch one", func() {
+
+				req, err := http.NewRequest(http.MethodPatch, s.URL+"/lists/"+l1.ID, strings.NewReader(`{"name":"z"}`))
+				So(err, ShouldBeNil)
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("X-Namespace", "/ns")
+
+				resp, err := http.DefaultClient.Do(req)
+				So(err, ShouldBeNil)
+				defer resp.Body.Close() // nolint
+
+				Convey("Then only the given attributes should be updated", func() {
+					So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+					l := &testmodel.List{ID: l1.ID}
+					So(m.Retrieve(nil, l), ShouldBeNil)
+					So(l.Name, ShouldEqual, "z")
+
+					n, err := m.Count(nil, testmodel.ListIdentity)
+					So(err, ShouldBeNil)
+					So(n, ShouldEqual, 3)
+				})
+			})
+
+			Convey("When I delete some with a filter", func() {
+
+				mctx := manipulate.NewContext(
+					context.Background(),
+					manipulate.ContextOptionFilter(elemental.NewFilterComposer().WithKey("name").In("a", "b").Done()),
+				)
+				So(m.DeleteMany(mctx, testmodel.ListIdentity), ShouldBeNil)
+
+				Convey("Then only the others should remain", func() {
+					lists := testmodel.ListsList{}
+					So(m.RetrieveMany(nil, &lists), ShouldBeNil)
+					So(len(lists), ShouldEqual, 1)
+					So(lists[0].ID, ShouldEqual, l3.ID)
+				})
+			})
+
+			Convey("When I delete them all", func() {
+
+				So(m.DeleteMany(nil, testmodel.ListIdentity), ShouldBeNil)
+
+				Convey("Then they should be gone", func() {
+					n, err := m.Count(nil, testmodel.ListIdentity)
+					So(err, ShouldBeNil)
+					So(n, ShouldEqual, 0)
+				})
+			})
+
+			Convey("When I create a child task", func() {
+
+				task := &testmodel.Task{Name: "t"}
+				So(m.Create(manipulate.NewContext(context.Background(), manipulate.ContextOptionParent(l1)), task), ShouldBeNil)
+
+				Convey("Then I should retrieve it from its parent only", func() {
+					tasks := testmodel.TasksList{}
+					So(m.RetrieveMany(manipulate.NewContext(context.Background(), manipulate.ContextOptionParent(l1)), &tasks), ShouldBeNil)
+					So(len(tasks), ShouldEqual, 1)
+
+					tasks = testmodel.TasksList{}
+					So(m.RetrieveMany(manipulate.NewContext(context.Background(), manipulate.ContextOptionParent(l2)), &tasks), ShouldBeNil)
+					So(len(tasks), ShouldEqual, 0)
+				})
+			})
+		})
+
+		Convey("When I retrieve an object that does not exist", func() {
+
+			err := m.Retrieve(nil, &testmodel.List{ID: "nope"})
+
+			Convey("Then err should be a not found error", func() {
+				So(manipulate.IsObjectNotFoundError(err), ShouldBeTrue)
+			})
+		})
+
+		Convey("When I send a request for an unknown identity", func() {
+
+			resp, err := http.Get(s.URL + "/nopes")
+			So(err, ShouldBeNil)
+			defer resp.Body.Close() // nolint
+
+			Convey("Then I should get a 404", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+			})
+		})
+
+		Convey("When I send a bad filter", func() {
+
+			resp, err := http.Get(s.URL + "/lists?q=name%20==")
+			So(err, ShouldBeNil)
+			defer resp.Body.Close() // nolint
+
+			Convey("Then I should get a 400", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+	})
+}
+
+func TestServer_Events(t *testing.T) {
+
+	Convey("Given I have a server and a subscriber", t, func() {
+
+		s, err := NewServer(testmodel.Manager(), serverSchema())
+		So(err, ShouldBeNil)
+		defer s.Close()
+
+		m, err := maniphttp.New(context.Background(), s.URL, maniphttp.OptionNamespace("/ns"))
+		So(err, ShouldBeNil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		filter := elemental.NewPushConfig()
+		filter.FilterIdentity(testmodel.ListIdentity.Name, elemental.EventCreate)
+
+		sub := maniphttp.NewSubscriber(m)
+		sub.Start(ctx, filter)
+
+		select {
+		case st := <-sub.Status():
+			So(st, ShouldEqual, manipulate.SubscriberStatusInitialConnection)
+		case <-time.After(2 * time.Second):
+			So("no connection", ShouldBeEmpty)
+		}
+
+		// Wait for the server to receive the push config.
+		time.Sleep(100 * time.Millisecond)
+
+		Convey("When I create and update a list", func() {
+
+			l := &testmodel.List{Name: "a"}
+			So(m.Create(nil, l), ShouldBeNil)
+			So(m.Update(nil, l), ShouldBeNil)
+
+			Convey("Then I should only receive the create event", func() {
+
+				select {
+				case evt := <-sub.Events():
+					So(evt.Type, ShouldEqual, elemental.EventCreate)
+					So(evt.Identity, ShouldEqual, testmodel.ListIdentity.Name)
+				case <-time.After(2 * time.Second):
+					So("no event", ShouldBeEmpty)
+				}
+
+				select {
+				case evt := <-sub.Events():
+					So(evt, ShouldBeNil)
+				case <-time.After(100 * time.Millisecond):
+				}
+			})
+		})
+	})
+}
@@ -13,6 +13,7 @@ package manipulate
 
 import (
 	"context"
+	"time"
 
 	"go.aporeto.io/elemental"
 )
@@ -85,6 +86,7 @@ const (
 	SubscriberStatusDisconnection
 	SubscriberStatusFinalDisconnection
 	SubscriberStatusTokenRenewal
+	SubscriberStatusGapDetected
 )
 
 // A Subscriber is the interface to control a push event subscription.
@@ -109,6 +111,57 @@ type Subscriber interface {
 	Status() chan SubscriberStatus
 }
 
+// A GapDetectingSubscriber is a Subscriber that publishes
+// SubscriberStatusGapDetected right after SubscriberStatusReconnection
+// when some events may have been lost during the disconnection.
+// Its consumers can then resync only when there is a gap,
+// instead of after every reconnection. Unlike the other statuses,
+// SubscriberStatusGapDetected is never dropped when the status
+// channel is full, so the consumers must keep reading it.
+type GapDetectingSubscriber interface {
+	Subscriber
+
+	// DetectsGaps returns true if the subscriber detects gaps.
+	DetectsGaps() bool
+}
+
+// SubscriberStats contains the statistics of a subscriber.
+type SubscriberStats struct {
+
+	// ConnectedSince is the time of the current connection.
+	// It is zero when the subscriber is not connected.
+	ConnectedSince time.Time
+
+	// Reconnections is the number of successful reconnections.
+	Reconnections int
+
+	// LastEventTime is the time the last event has been received.
+	LastEventTime time.Time
+
+	// EventsReceived is the number of events received per identity.
+	EventsReceived map[string]int
+
+	// EventsDropped is the number of events dropped per identity,
+	// because the events channel was full.
+	EventsDropped map[string]int
+
+	// Filter is the current push config.
+	Filter *elemental.PushConfig
+
+	// Backoff is the current delay before the next connection
+	// attempt. It is zero when the subscriber is connected.
+	Backoff time.Duration
+}
+
+// A StatsReportingSubscriber is a Subscriber that
+// reports statistics about its connection and events.
+type StatsReportingSubscriber interface {
+	Subscriber
+
+	// Stats returns the current statistics of the subscriber.
+	Stats() SubscriberStats
+}
+
 // A TokenManager issues an renew tokens periodically.
 type TokenManager interface {
 
@@ -676,7 +676,13 @@ func (m *vortexManipulator) monitor(ctx context.Context) {
 
 			switch status {
 
-			case manipulate.SubscriberStatusReconnection:
+			case manipulate.SubscriberStatusReconnection, manipulate.SubscriberStatusGapDetected:
+
+				// If the upstream subscriber detects gaps, we only
+				// resync when some events have been lost.
+				if status == manipulate.SubscriberStatusReconnection && m.upstreamDetectsGaps() {
+					break
+				}
 
 				// We resync everything
 				if err := m.Flush(ctx); err != nil {
@@ -696,6 +702,13 @@ func (m *vortexManipulator) monitor(ctx context.Context) {
 	}
 }
 
+func (m *vortexManipulator) upstreamDetectsGaps() bool {
+
+	s, ok := m.upstreamSubscriber.(manipulate.GapDetectingSubscriber)
+
+	return ok && s.DetectsGaps()
+}
+
 func (m *vortexManipulator) pushEvent(evt *elemental.Event) {
 
 	m.RLock()
@@ -1308,6 +1308,88 @@ func Test_Monitor(t *testing.T) {
 
 }
 
+type testGapDetectingSubscriber struct {
+	maniptest.TestSubscriber
+}
+
+func (s *testGapDetectingSubscriber) DetectsGaps() bool { return true }
+
+func Test_MonitorGapDetection(t *testing.T) {
+
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	Convey("Given a valid memdb vortex with a subscriber detecting gaps", t, func() {
+		m := maniptest.NewTestManipulator()
+		s := maniptest.NewTestSubscriber()
+
+		s.MockStart(t, func(ctx context.Context, filter *elemental.PushConfig) {})
+
+		eventChannel := make(chan *elemental.Event)
+		s.MockEvents(t, func() chan *elemental.Event { return eventChannel })
+
+		errorsChannel := make(chan error)
+		s.MockErrors(t, func() chan error { return errorsChannel })
+
+		statusChannel := make(chan manipulate.SubscriberStatus)
+		s.MockStatus(t, func() chan manipulate.SubscriberStatus {
+			return statusChannel
+		})
+
+		d, err := newDatastore()
+		So(err, ShouldBeNil)
+
+		v, err := New(
+			ctx,
+			d,
+			newIdentityProcessor(manipulate.ReadConsistencyDefault, manipulate.WriteConsistencyDefault),
+			testmodel.Manager(),
+			OptionUpstreamManipulator(m),
+			OptionPrefetcher(NewDefaultPrefetcher()),
+			OptionUpstreamSubscriber(&testGapDetectingSubscriber{TestSubscriber: s}),
+		)
+		So(err, ShouldBeNil)
+
+		obj1 := newObject("obj1", []string{"a=b"})
+		obj1.ID = "ID1"
+
+		m.MockRetrieveMany(t, func(mctx manipulate.Context, dest elemental.Identifiables) error {
+			if mctx.Page() > 1 {
+				return nil
+			}
+			*dest.(*testmodel.ListsList) = testmodel.ListsList{obj1}
+			return nil
+		})
+
+		Convey("When I push a reconnection status, the db must not be resynced", func() {
+
+			statusChannel <- manipulate.SubscriberStatusReconnection
+
+			time.Sleep(100 * time.Millisecond)
+
+			objects := testmodel.ListsList{}
+			err := v.RetrieveMany(nil, &objects)
+			So(err, ShouldBeNil)
+			So(len(objects), ShouldEqual, 0)
+		})
+
+		Convey("When I push a reconnection status followed by a gap detected status, the db must be resynced", func() {
+
+			statusChannel <- manipulate.SubscriberStatusReconnection
+			statusChannel <- manipulate.SubscriberStatusGapDetected
+
+			time.Sleep(100 * time.Millisecond)
+
+			objects := testmodel.ListsList{}
+			err := v.RetrieveMany(nil, &objects)
+			So(err, ShouldBeNil)
+			So(len(objects), ShouldEqual, 1)
+		})
+	})
+}
+
 func Test_WriteBackBackend(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()